@@ -0,0 +1,243 @@
+// Command migrate-storage copies uploaded images and files from one storage
+// backend to another, e.g. from the local filesystem to S3:
+//
+//	go run ./cmd/migrate-storage -from local -to s3
+//
+// Both backends are configured from the same environment variables as the
+// server; -from-root and -to-root override the local root of either side.
+// Objects that already exist at the destination with the same size are
+// skipped, so the command can be run again after an interruption. File
+// records that still point at their pre-storage location under uploads/
+// are updated to their storage key once copied; running the command with
+// the same local storage on both sides only does that.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"simultaneous-memo-app/backend/config"
+	"simultaneous-memo-app/backend/models"
+	"simultaneous-memo-app/backend/storage"
+
+	"gorm.io/gorm"
+)
+
+// object is a stored object referenced by a record
+type object struct {
+	// path is the path stored in the record
+	path        string
+	key         string
+	contentType string
+}
+
+func main() {
+	cfg := config.Load()
+
+	from := flag.String("from", storage.DriverLocal, "source storage driver (local or s3)")
+	to := flag.String("to", storage.DriverS3, "destination storage driver (local or s3)")
+	fromRoot := flag.String("from-root", cfg.Storage.LocalRoot, "root directory of a local source")
+	toRoot := flag.String("to-root", cfg.Storage.LocalRoot, "root directory of a local destination")
+	dryRun := flag.Bool("dry-run", false, "only report what would be copied")
+	flag.Parse()
+
+	srcCfg := cfg.Storage
+	srcCfg.Driver, srcCfg.LocalRoot = *from, *fromRoot
+	src, err := storage.New(srcCfg)
+	if err != nil {
+		log.Fatal("Failed to initialize source storage:", err)
+	}
+	dstCfg := cfg.Storage
+	dstCfg.Driver, dstCfg.LocalRoot = *to, *toRoot
+	dst, err := storage.New(dstCfg)
+	if err != nil {
+		log.Fatal("Failed to initialize destination storage:", err)
+	}
+
+	db, err := models.InitDB(cfg.DatabaseURL)
+	if err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+
+	objects, err := referencedObjects(db)
+	if err != nil {
+		log.Fatal("Failed to load records:", err)
+	}
+
+	ctx := context.Background()
+	copied, skipped, failed := 0, 0, 0
+	migrated := make(map[string]bool)
+	for _, obj := range objects {
+		done, err := copyObject(ctx, dst, src, obj, *dryRun)
+		switch {
+		case err == storage.ErrNotExist:
+			fmt.Printf("見つかりません: %s\n", obj.path)
+			failed++
+			continue
+		case err != nil:
+			fmt.Printf("コピーエラー %s: %v\n", obj.path, err)
+			failed++
+			continue
+		case done:
+			copied++
+		default:
+			skipped++
+		}
+		migrated[obj.path] = true
+	}
+
+	updated := 0
+	if !*dryRun {
+		if updated, err = updateLegacyPaths(db, migrated); err != nil {
+			log.Fatal("Failed to update file paths:", err)
+		}
+	}
+
+	fmt.Printf("コピー: %d, スキップ: %d, 失敗: %d, パス更新: %d\n", copied, skipped, failed, updated)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// referencedObjects returns every object referenced by image, file and
+// blob records, once each
+func referencedObjects(db *gorm.DB) ([]object, error) {
+	var objects []object
+	seen := make(map[string]bool)
+	add := func(path, key, contentType string) {
+		if path == "" || seen[key] {
+			return
+		}
+		seen[key] = true
+		objects = append(objects, object{path: path, key: key, contentType: contentType})
+	}
+
+	var images []models.Image
+	if err := db.Find(&images).Error; err != nil {
+		return nil, err
+	}
+	for _, image := range images {
+		add(image.Path, models.ImageKey(image.Path), image.ContentType)
+		add(image.ThumbnailPath, models.ImageKey(image.ThumbnailPath), image.ContentType)
+		var variants []models.ImageVariant
+		if len(image.Variants) > 0 {
+			if err := json.Unmarshal(image.Variants, &variants); err != nil {
+				return nil, fmt.Errorf("image %d: %w", image.ID, err)
+			}
+		}
+		for _, variant := range variants {
+			add(variant.Path, models.ImageKey(variant.Path), image.ContentType)
+		}
+	}
+
+	var files []models.File
+	if err := db.Find(&files).Error; err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		add(file.Path, models.FileKey(file.Path), file.ContentType)
+	}
+
+	// Blobs are normally covered by their records, but one may outlive
+	// them briefly while it is released
+	var blobs []models.Blob
+	if err := db.Find(&blobs).Error; err != nil {
+		return nil, err
+	}
+	for _, blob := range blobs {
+		add(blob.Path, blob.Key(), "")
+		if blob.ThumbnailPath != "" {
+			add(blob.ThumbnailPath, models.ImageKey(blob.ThumbnailPath), "")
+		}
+	}
+
+	return objects, nil
+}
+
+// copyObject copies an object unless the destination already has it, and
+// reports whether it was copied
+func copyObject(ctx context.Context, dst, src storage.Storage, obj object, dryRun bool) (bool, error) {
+	content, size, err := openSource(ctx, src, obj)
+	if err != nil {
+		return false, err
+	}
+	defer content.Close()
+
+	if info, err := dst.Stat(ctx, obj.key); err == nil && info.Size == size {
+		return false, nil
+	} else if err != nil && err != storage.ErrNotExist {
+		return false, err
+	}
+
+	if dryRun {
+		fmt.Printf("コピー予定: %s -> %s\n", obj.path, obj.key)
+		return true, nil
+	}
+	if err := dst.Put(ctx, obj.key, content, size, obj.contentType); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// openSource opens an object in the source storage. Files uploaded before
+// storage drivers may still be at their old path relative to the working
+// directory.
+func openSource(ctx context.Context, src storage.Storage, obj object) (io.ReadCloser, int64, error) {
+	info, err := src.Stat(ctx, obj.key)
+	if err == nil {
+		content, err := src.Get(ctx, obj.key)
+		return content, info.Size, err
+	}
+	if err != storage.ErrNotExist || !isLegacyPath(obj.path) {
+		return nil, 0, err
+	}
+
+	file, err := os.Open(obj.path)
+	if os.IsNotExist(err) {
+		return nil, 0, storage.ErrNotExist
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, stat.Size(), nil
+}
+
+// updateLegacyPaths points file and blob records whose content was copied
+// from an old uploads/ path at its storage key
+func updateLegacyPaths(db *gorm.DB, migrated map[string]bool) (int, error) {
+	updated := 0
+	for path := range migrated {
+		if !isLegacyPath(path) {
+			continue
+		}
+		key := models.FileKey(path)
+		result := db.Model(&models.File{}).Where("path = ?", path).Update("path", key)
+		if result.Error != nil {
+			return updated, result.Error
+		}
+		updated += int(result.RowsAffected)
+
+		result = db.Model(&models.Blob{}).Where("kind = ? AND path = ?", models.BlobKindFile, path).Update("path", key)
+		if result.Error != nil {
+			return updated, result.Error
+		}
+		updated += int(result.RowsAffected)
+	}
+	return updated, nil
+}
+
+// isLegacyPath reports whether a file path predates storage drivers
+func isLegacyPath(path string) bool {
+	return strings.HasPrefix(path, "uploads/")
+}
@@ -2,20 +2,59 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"simultaneous-memo-app/backend/storage"
 )
 
 type Config struct {
 	Port        string
 	DatabaseURL string
 	Environment string
+	Storage     storage.Config
+	// Metadata stripped from uploaded images
+	StripImageLocation bool
+	StripImageDevice   bool
+	// Widths of the resized variants generated for uploaded images; empty
+	// keeps the default
+	ImageVariantWidths []int
+	// Directory of the on-disk cache of resized images
+	ImageCacheDir string
 }
 
 func Load() *Config {
-	return &Config{
+	cfg := &Config{
 		Port:        getEnv("PORT", "8080"),
 		DatabaseURL: getEnv("DATABASE_URL", buildDatabaseURL()),
 		Environment: getEnv("GO_ENV", "development"),
+		Storage: storage.Config{
+			Driver:           getEnv("STORAGE_DRIVER", storage.DriverLocal),
+			LocalRoot:        getEnv("STORAGE_LOCAL_ROOT", "../uploads"),
+			S3Endpoint:       getEnv("S3_ENDPOINT", ""),
+			S3Bucket:         getEnv("S3_BUCKET", ""),
+			S3AccessKey:      getEnv("S3_ACCESS_KEY", ""),
+			S3SecretKey:      getEnv("S3_SECRET_KEY", ""),
+			S3Region:         getEnv("S3_REGION", ""),
+			S3UseSSL:         getEnv("S3_USE_SSL", "false") == "true",
+			S3PublicEndpoint: getEnv("S3_PUBLIC_ENDPOINT", ""),
+		},
+		StripImageLocation: getEnv("IMAGE_STRIP_LOCATION", "true") != "false",
+		StripImageDevice:   getEnv("IMAGE_STRIP_DEVICE", "true") != "false",
+		ImageVariantWidths: getEnvInts("IMAGE_VARIANT_WIDTHS"),
+	}
+	cfg.ImageCacheDir = getEnv("IMAGE_CACHE_DIR", defaultImageCacheDir(cfg.Storage))
+	return cfg
+}
+
+// defaultImageCacheDir keeps the resized image cache next to locally
+// stored uploads, and in the temporary directory when uploads are in S3
+func defaultImageCacheDir(cfg storage.Config) string {
+	if cfg.Driver == storage.DriverLocal {
+		return filepath.Join(cfg.LocalRoot, "cache", "variants")
 	}
+	return filepath.Join(os.TempDir(), "simultaneous-memo-app", "variants")
 }
 
 func getEnv(key, defaultValue string) string {
@@ -25,6 +64,18 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvInts parses a comma-separated list of positive integers, skipping
+// entries that are not
+func getEnvInts(key string) []int {
+	var values []int
+	for _, part := range strings.Split(os.Getenv(key), ",") {
+		if n, err := strconv.Atoi(strings.TrimSpace(part)); err == nil && n > 0 {
+			values = append(values, n)
+		}
+	}
+	return values
+}
+
 func buildDatabaseURL() string {
 	host := getEnv("DB_HOST", "localhost")
 	port := getEnv("DB_PORT", "5432")
@@ -19,7 +19,7 @@ func (h *Handler) CleanupImages(c echo.Context) error {
 	}
 
 	// Cleanup orphaned images older than 24 hours
-	err := CleanupOrphanedImages(h.db, 24*time.Hour)
+	err := CleanupOrphanedImages(h.db, h.store, 24*time.Hour)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
 			"error": "クリーンアップ中にエラーが発生しました",
@@ -27,15 +27,17 @@ func (h *Handler) CleanupImages(c echo.Context) error {
 		})
 	}
 
-	// Remove empty directories
-	if err := RemoveEmptyDirectories("../uploads/images"); err != nil {
+	// Remove stored files no record refers to anymore
+	removed, err := CleanupUnreferencedObjects(h.db, h.store, 24*time.Hour)
+	if err != nil {
 		return c.JSON(http.StatusOK, map[string]interface{}{
-			"message": "画像のクリーンアップは完了しましたが、ディレクトリ削除でエラーがありました",
+			"message": "画像のクリーンアップは完了しましたが、ファイル削除でエラーがありました",
 			"error": err.Error(),
 		})
 	}
 
-	return c.JSON(http.StatusOK, map[string]string{
+	return c.JSON(http.StatusOK, map[string]interface{}{
 		"message": "クリーンアップが正常に完了しました",
+		"removed_objects": removed,
 	})
 }
\ No newline at end of file
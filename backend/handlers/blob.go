@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"simultaneous-memo-app/backend/models"
+	"simultaneous-memo-app/backend/storage"
+
+	"gorm.io/gorm"
+)
+
+// blobMu serializes storing and removing blob files, so a blob is never
+// removed from storage while an upload of the same content is reusing it
+var blobMu sync.Mutex
+
+// fileDigest returns the hex SHA-256 digest and the size of a file
+func fileDigest(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// storeImageBlob stores a processed image at the local path tmpPath as a
+// blob and records a reference to it, returning the blob and its resized
+// variants. Content that is already stored is reused along with its
+// thumbnail and variants.
+func storeImageBlob(db *gorm.DB, store storage.Storage, tmpPath, ext, contentType string) (*models.Blob, []models.ImageVariant, error) {
+	ctx := context.Background()
+	digest, size, err := fileDigest(tmpPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	relPath := fmt.Sprintf("/images/sha256/%s/%s%s", digest[:2], digest, ext)
+	thumbRelPath := fmt.Sprintf("/images/sha256/%s/thumb_%s%s", digest[:2], digest, thumbnailExtension(ext))
+
+	blobMu.Lock()
+	defer blobMu.Unlock()
+
+	created, err := putIfMissing(ctx, store, models.ImageKey(relPath), tmpPath, contentType)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := putThumbnailIfMissing(ctx, store, models.ImageKey(thumbRelPath), tmpPath); err != nil {
+		// Log error but don't fail the upload
+		fmt.Printf("サムネイル作成エラー: %v\n", err)
+		thumbRelPath = ""
+	}
+
+	blob, err := models.AcquireBlob(db, &models.Blob{
+		Kind:          models.BlobKindImage,
+		Digest:        digest,
+		Path:          relPath,
+		ThumbnailPath: thumbRelPath,
+		Size:          size,
+	})
+	if err != nil {
+		if created {
+			removeImageFiles(store, relPath, thumbRelPath)
+		}
+		return nil, nil, err
+	}
+	// The same content was stored earlier under another extension
+	if blob.Path != relPath {
+		removeImageFiles(store, relPath, thumbRelPath)
+	}
+
+	// Variants are generated next to the blob so every reference shares
+	// them; an image without them is still usable
+	variants, err := putVariantsIfMissing(ctx, store, blob.Path, tmpPath, contentType)
+	if err != nil {
+		fmt.Printf("バリアント作成エラー: %v\n", err)
+	}
+	return blob, variants, nil
+}
+
+// storeFileBlob stores an uploaded file at the local path tmpPath as a
+// blob and records a reference to it, reusing content that is already
+// stored
+func storeFileBlob(db *gorm.DB, store storage.Storage, tmpPath, contentType string) (*models.Blob, error) {
+	ctx := context.Background()
+	digest, size, err := fileDigest(tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("files/sha256/%s/%s", digest[:2], digest)
+
+	blobMu.Lock()
+	defer blobMu.Unlock()
+
+	created, err := putIfMissing(ctx, store, key, tmpPath, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := models.AcquireBlob(db, &models.Blob{
+		Kind:   models.BlobKindFile,
+		Digest: digest,
+		Path:   key,
+		Size:   size,
+	})
+	if err != nil && created {
+		store.Delete(ctx, key)
+	}
+	return blob, err
+}
+
+// putIfMissing stores a local file under key unless the key is already
+// stored, and reports whether it was stored
+func putIfMissing(ctx context.Context, store storage.Storage, key, path, contentType string) (bool, error) {
+	if _, err := store.Stat(ctx, key); err != storage.ErrNotExist {
+		return false, err
+	}
+	if err := storage.PutFile(ctx, store, key, path, contentType); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// putThumbnailIfMissing creates a thumbnail of the local image at path and
+// stores it under key, in the format its extension names, unless the key
+// is already stored
+func putThumbnailIfMissing(ctx context.Context, store storage.Storage, key, path string) (bool, error) {
+	if _, err := store.Stat(ctx, key); err != storage.ErrNotExist {
+		return false, err
+	}
+
+	thumb, err := os.CreateTemp("", "thumb-*"+filepath.Ext(key))
+	if err != nil {
+		return false, err
+	}
+	thumb.Close()
+	defer os.Remove(thumb.Name())
+
+	if err := CreateThumbnail(path, thumb.Name(), DefaultImageConfig()); err != nil {
+		return false, err
+	}
+	if err := storage.PutFile(ctx, store, key, thumb.Name(), GetMIMEType(key)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// deleteImageRecord deletes an image record and releases its blobs, the
+// edited one and the original it was edited from. The files are removed
+// once no other record uses them; images stored before deduplication own
+// their files and lose them at once. Errors removing files are returned
+// separately since the record is gone by then.
+func deleteImageRecord(db *gorm.DB, store storage.Storage, image *models.Image) ([]error, error) {
+	blobMu.Lock()
+	defer blobMu.Unlock()
+
+	var released []*models.Blob
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := models.DeleteImage(tx, image.ID); err != nil {
+			return err
+		}
+		for _, digest := range []string{image.Checksum, image.OriginalChecksum} {
+			if digest == "" {
+				continue
+			}
+			blob, err := models.ReleaseBlob(tx, models.BlobKindImage, digest)
+			if err != nil {
+				return err
+			}
+			if blob != nil {
+				released = append(released, blob)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if image.Checksum == "" {
+		return removeImageFiles(store, image.Path, image.ThumbnailPath), nil
+	}
+	var errs []error
+	for _, blob := range released {
+		errs = append(errs, removeImageFiles(store, blob.Path, blob.ThumbnailPath)...)
+	}
+	return errs, nil
+}
+
+// deleteFileRecord deletes a file record and releases its blob, removing
+// the file once no other record uses it
+func deleteFileRecord(db *gorm.DB, store storage.Storage, file *models.File) error {
+	blobMu.Lock()
+	defer blobMu.Unlock()
+
+	var released *models.Blob
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.File{}, file.ID).Error; err != nil {
+			return err
+		}
+		if file.Checksum == "" {
+			return nil
+		}
+		var err error
+		released, err = models.ReleaseBlob(tx, models.BlobKindFile, file.Checksum)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if file.Checksum == "" {
+		return removeFileContent(store, file.Path)
+	}
+	if released != nil {
+		return removeFileContent(store, released.Path)
+	}
+	return nil
+}
+
+// releaseBlob drops a reference taken for a record that could not be
+// saved, removing the blob's files if nothing else uses them
+func releaseBlob(db *gorm.DB, store storage.Storage, kind, digest string) {
+	blobMu.Lock()
+	defer blobMu.Unlock()
+
+	blob, err := models.ReleaseBlob(db, kind, digest)
+	if err != nil {
+		fmt.Printf("ブロブ解放エラー: %v\n", err)
+		return
+	}
+	if blob == nil {
+		return
+	}
+	if kind == models.BlobKindImage {
+		removeImageFiles(store, blob.Path, blob.ThumbnailPath)
+	} else {
+		removeFileContent(store, blob.Path)
+	}
+}
+
+// openFileContent opens the content of a file record. Files uploaded
+// before storage drivers that have not been migrated yet are read from
+// their old location relative to the working directory.
+func openFileContent(ctx context.Context, store storage.Storage, path string) (storage.Object, error) {
+	obj, err := store.Get(ctx, models.FileKey(path))
+	if err == storage.ErrNotExist && isLegacyFilePath(path) {
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			return nil, storage.ErrNotExist
+		}
+		return file, err
+	}
+	return obj, err
+}
+
+// removeFileContent removes the content of a file record, including an
+// unmigrated copy at its old location
+func removeFileContent(store storage.Storage, path string) error {
+	if isLegacyFilePath(path) {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return store.Delete(context.Background(), models.FileKey(path))
+}
+
+// isLegacyFilePath reports whether a file path predates storage drivers
+func isLegacyFilePath(path string) bool {
+	return strings.HasPrefix(path, "uploads/")
+}
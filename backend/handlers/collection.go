@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"simultaneous-memo-app/backend/models"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/datatypes"
+)
+
+// CollectionRequest is the body for creating or updating a collection
+type CollectionRequest struct {
+	Name   string                      `json:"name"`
+	Schema []models.PropertyDefinition `json:"schema"`
+}
+
+// ListCollections returns all collections
+func (h *Handler) ListCollections(c echo.Context) error {
+	collections, err := models.GetAllCollections(h.db)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to retrieve collections",
+		})
+	}
+
+	return c.JSON(http.StatusOK, collections)
+}
+
+// GetCollection returns a collection and its schema
+func (h *Handler) GetCollection(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid collection ID",
+		})
+	}
+
+	collection, err := models.GetCollectionByID(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Collection not found",
+		})
+	}
+
+	return c.JSON(http.StatusOK, collection)
+}
+
+// CreateCollection creates a collection with a property schema
+func (h *Handler) CreateCollection(c echo.Context) error {
+	var req CollectionRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Collection name is required",
+		})
+	}
+
+	defs, err := models.NormalizeSchema(req.Schema)
+	if err != nil {
+		return invalidPropertiesResponse(c, err)
+	}
+	schema, err := json.Marshal(defs)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to create collection",
+		})
+	}
+
+	collection := models.Collection{Name: name, Schema: datatypes.JSON(schema)}
+	if err := models.CreateCollection(h.db, &collection); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to create collection",
+		})
+	}
+
+	return c.JSON(http.StatusCreated, collection)
+}
+
+// UpdateCollection renames a collection or replaces its schema. Values of
+// properties removed from the schema are deleted from the pages.
+func (h *Handler) UpdateCollection(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid collection ID",
+		})
+	}
+
+	collection, err := models.GetCollectionByID(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Collection not found",
+		})
+	}
+
+	var req struct {
+		Name   *string                      `json:"name"`
+		Schema *[]models.PropertyDefinition `json:"schema"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Collection name is required",
+			})
+		}
+		if err := h.db.Model(collection).Update("name", name).Error; err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to update collection",
+			})
+		}
+	}
+
+	if req.Schema != nil {
+		defs, err := models.NormalizeSchema(*req.Schema)
+		if err != nil {
+			return invalidPropertiesResponse(c, err)
+		}
+		if err := models.UpdateCollectionSchema(h.db, collection, defs); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to update collection",
+			})
+		}
+	}
+
+	collection, err = models.GetCollectionByID(h.db, collection.ID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Collection not found",
+		})
+	}
+
+	return c.JSON(http.StatusOK, collection)
+}
+
+// DeleteCollection deletes a collection. Its pages are kept without
+// properties.
+func (h *Handler) DeleteCollection(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid collection ID",
+		})
+	}
+
+	collection, err := models.GetCollectionByID(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Collection not found",
+		})
+	}
+
+	if err := models.DeleteCollection(h.db, collection.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to delete collection",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Collection deleted successfully",
+	})
+}
+
+// QueryCollection returns a collection's pages filtered and sorted by
+// property values
+func (h *Handler) QueryCollection(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid collection ID",
+		})
+	}
+
+	collection, err := models.GetCollectionByID(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Collection not found",
+		})
+	}
+
+	var req models.PropertyQuery
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+	if req.Limit <= 0 || req.Limit > 100 {
+		req.Limit = 50
+	}
+	if req.Offset < 0 {
+		req.Offset = 0
+	}
+
+	query, err := models.CollectionQuery(h.db, collection, req.Filters, req.Sorts)
+	if err != nil {
+		return invalidPropertiesResponse(c, err)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to count pages",
+		})
+	}
+
+	var pages []models.Page
+	if err := query.Select(models.PageSummaryColumns).Limit(req.Limit).Offset(req.Offset).Find(&pages).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to query pages",
+		})
+	}
+
+	summaries := make([]models.PageSummary, len(pages))
+	for i := range pages {
+		summaries[i] = pages[i].ToSummary()
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"pages":    summaries,
+		"total":    total,
+		"limit":    req.Limit,
+		"offset":   req.Offset,
+		"has_more": int64(req.Offset+len(pages)) < total,
+	})
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"simultaneous-memo-app/backend/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// CommentRequest is the body for creating, replying to or editing a comment.
+// The anchor fields are only used when starting a thread.
+type CommentRequest struct {
+	Author      string `json:"author"`
+	Body        string `json:"body"`
+	BlockID     string `json:"block_id"`
+	AnchorStart *int   `json:"anchor_start"`
+	AnchorEnd   *int   `json:"anchor_end"`
+}
+
+// ResolveRequest is the body for resolving or reopening a thread
+type ResolveRequest struct {
+	Author string `json:"author"`
+}
+
+// GetPageComments returns the comment threads of a page with their replies.
+// The status parameter selects open (default), resolved or all threads.
+func (h *Handler) GetPageComments(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid page ID",
+		})
+	}
+
+	status := c.QueryParam("status")
+	switch status {
+	case "":
+		status = models.CommentStatusOpen
+	case models.CommentStatusOpen, models.CommentStatusResolved, models.CommentStatusAll:
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid status, expected open, resolved or all",
+		})
+	}
+
+	comments, err := models.GetPageComments(h.db, uint(id), status)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to retrieve comments",
+		})
+	}
+
+	return c.JSON(http.StatusOK, comments)
+}
+
+// CreateComment starts a comment thread on a page, anchored to a block and
+// optionally to a character range within it
+func (h *Handler) CreateComment(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid page ID",
+		})
+	}
+
+	var req CommentRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+	body := strings.TrimSpace(req.Body)
+	if body == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Comment body is required",
+		})
+	}
+
+	page, err := models.GetPageByID(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Page not found",
+		})
+	}
+
+	comment := models.Comment{
+		PageID: page.ID,
+		Author: strings.TrimSpace(req.Author),
+		Body:   body,
+	}
+	if req.BlockID != "" {
+		quoted, err := models.ResolveAnchor(page.Content, req.BlockID, req.AnchorStart, req.AnchorEnd)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid anchor, the block or range does not exist in the page",
+			})
+		}
+		comment.BlockID = req.BlockID
+		comment.AnchorStart = req.AnchorStart
+		comment.AnchorEnd = req.AnchorEnd
+		comment.QuotedText = quoted
+	}
+
+	if err := models.CreateComment(h.db, &comment); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to create comment",
+		})
+	}
+
+	h.notifyComment("comment-created", &comment)
+
+	return c.JSON(http.StatusCreated, comment)
+}
+
+// ReplyToComment adds a reply to a thread. Replying to a reply adds to the
+// same thread.
+func (h *Handler) ReplyToComment(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid comment ID",
+		})
+	}
+
+	var req CommentRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+	body := strings.TrimSpace(req.Body)
+	if body == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Comment body is required",
+		})
+	}
+
+	parent, err := models.GetCommentByID(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Comment not found",
+		})
+	}
+	threadID := parent.ID
+	if parent.ParentID != nil {
+		threadID = *parent.ParentID
+	}
+
+	reply := models.Comment{
+		PageID:   parent.PageID,
+		ParentID: &threadID,
+		Author:   strings.TrimSpace(req.Author),
+		Body:     body,
+	}
+	if err := models.CreateComment(h.db, &reply); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to create reply",
+		})
+	}
+
+	h.notifyComment("comment-created", &reply)
+
+	return c.JSON(http.StatusCreated, reply)
+}
+
+// UpdateComment edits the body of a comment
+func (h *Handler) UpdateComment(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid comment ID",
+		})
+	}
+
+	var req CommentRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+	body := strings.TrimSpace(req.Body)
+	if body == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Comment body is required",
+		})
+	}
+
+	if _, err := models.GetCommentByID(h.db, uint(id)); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Comment not found",
+		})
+	}
+
+	if err := models.UpdateComment(h.db, uint(id), map[string]interface{}{"body": body}); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to update comment",
+		})
+	}
+
+	comment, err := models.GetCommentByID(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Comment not found",
+		})
+	}
+
+	h.notifyComment("comment-updated", comment)
+
+	return c.JSON(http.StatusOK, comment)
+}
+
+// DeleteComment deletes a comment, and its replies when it starts a thread
+func (h *Handler) DeleteComment(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid comment ID",
+		})
+	}
+
+	comment, err := models.GetCommentByID(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Comment not found",
+		})
+	}
+
+	if err := models.DeleteComment(h.db, comment.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to delete comment",
+		})
+	}
+
+	h.notifyComment("comment-deleted", comment)
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Comment deleted successfully",
+	})
+}
+
+// ResolveComment marks a thread as resolved
+func (h *Handler) ResolveComment(c echo.Context) error {
+	return h.setCommentResolved(c, true)
+}
+
+// ReopenComment marks a resolved thread as open again
+func (h *Handler) ReopenComment(c echo.Context) error {
+	return h.setCommentResolved(c, false)
+}
+
+func (h *Handler) setCommentResolved(c echo.Context, resolved bool) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid comment ID",
+		})
+	}
+
+	var req ResolveRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	comment, err := models.GetCommentByID(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Comment not found",
+		})
+	}
+	if comment.ParentID != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Only threads can be resolved, not replies",
+		})
+	}
+
+	if err := models.SetCommentResolved(h.db, comment.ID, resolved, strings.TrimSpace(req.Author)); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to update comment",
+		})
+	}
+
+	thread, err := models.GetCommentThread(h.db, comment.ID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Comment not found",
+		})
+	}
+
+	event := "comment-reopened"
+	if resolved {
+		event = "comment-resolved"
+	}
+	h.notifyComment(event, thread)
+
+	return c.JSON(http.StatusOK, thread)
+}
+
+// notifyComment tells everyone connected to the comment's page about a change
+func (h *Handler) notifyComment(event string, comment *models.Comment) {
+	h.notifyPage(comment.PageID, event, map[string]interface{}{"comment": comment})
+}
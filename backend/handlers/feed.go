@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"simultaneous-memo-app/backend/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// FeedTokenRequest is the body for creating a feed token
+type FeedTokenRequest struct {
+	Name string `json:"name"`
+	User string `json:"user"`
+}
+
+// feedTokenResponse is a feed token with the URLs of its feeds
+type feedTokenResponse struct {
+	models.FeedToken
+	CalendarURL string `json:"calendar_url"`
+	AtomURL     string `json:"atom_url"`
+}
+
+func newFeedTokenResponse(c echo.Context, feed models.FeedToken) feedTokenResponse {
+	base := getBaseURL(c) + "/api/feeds/" + feed.Token
+	return feedTokenResponse{
+		FeedToken:   feed,
+		CalendarURL: base + "/calendar.ics",
+		AtomURL:     base + "/atom.xml",
+	}
+}
+
+// ListFeedTokens returns the feed tokens with their feed URLs
+func (h *Handler) ListFeedTokens(c echo.Context) error {
+	feeds, err := models.GetAllFeedTokens(h.db)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to retrieve feed tokens",
+		})
+	}
+
+	response := make([]feedTokenResponse, len(feeds))
+	for i := range feeds {
+		response[i] = newFeedTokenResponse(c, feeds[i])
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// CreateFeedToken creates a feed token, for the whole workspace or for one
+// user
+func (h *Handler) CreateFeedToken(c echo.Context) error {
+	var req FeedTokenRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	feed := models.FeedToken{
+		Name: strings.TrimSpace(req.Name),
+		User: strings.TrimSpace(req.User),
+	}
+	if feed.Name == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Feed name is required",
+		})
+	}
+
+	if err := models.CreateFeedToken(h.db, &feed); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to create feed token",
+		})
+	}
+
+	return c.JSON(http.StatusCreated, newFeedTokenResponse(c, feed))
+}
+
+// DeleteFeedToken revokes a feed token
+func (h *Handler) DeleteFeedToken(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid feed token ID",
+		})
+	}
+
+	if err := models.DeleteFeedToken(h.db, uint(id)); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to delete feed token",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Feed token deleted successfully",
+	})
+}
+
+// CalendarFeed serves an iCalendar feed of dated tasks as VTODO entries
+// and date properties of pages as VEVENT entries
+func (h *Handler) CalendarFeed(c echo.Context) error {
+	feed, err := models.GetFeedToken(h.db, c.Param("token"))
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "Invalid feed token",
+		})
+	}
+
+	tasks, err := models.ListTasks(h.db, models.TaskListOptions{
+		Status:   models.TaskStatusAll,
+		Assignee: feed.User,
+		DueOnly:  true,
+		Limit:    5000,
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to retrieve tasks",
+		})
+	}
+	dated, err := models.GetDatedPages(h.db, feed.User)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to retrieve pages",
+		})
+	}
+
+	baseURL := getBaseURL(c)
+	host := c.Request().Host
+	now := time.Now().UTC()
+
+	cal := &icalWriter{}
+	cal.line("BEGIN", "VCALENDAR")
+	cal.line("VERSION", "2.0")
+	cal.line("PRODID", "-//simultaneous-memo-app//calendar feed//JA")
+	cal.line("CALSCALE", "GREGORIAN")
+	cal.line("X-WR-CALNAME", feed.Name)
+
+	for _, task := range tasks {
+		uid := fmt.Sprintf("task-%d-%d@%s", task.PageID, task.Position, host)
+		if task.BlockID != "" {
+			uid = fmt.Sprintf("task-%d-%s@%s", task.PageID, task.BlockID, host)
+		}
+		cal.line("BEGIN", "VTODO")
+		cal.line("UID", uid)
+		cal.line("DTSTAMP", now.Format(icalTimeFormat))
+		cal.line("SUMMARY", task.Text)
+		cal.line("DESCRIPTION", task.PageTitle)
+		cal.line("DUE;VALUE=DATE", task.DueDate.Format(icalDateFormat))
+		cal.line("URL", baseURL+models.PageURL(task.PageID))
+		if task.Checked {
+			cal.line("STATUS", "COMPLETED")
+		} else {
+			cal.line("STATUS", "NEEDS-ACTION")
+		}
+		cal.line("END", "VTODO")
+	}
+
+	for _, page := range dated {
+		cal.line("BEGIN", "VEVENT")
+		cal.line("UID", fmt.Sprintf("page-%d-%s@%s", page.PageID, page.PropertyID, host))
+		cal.line("DTSTAMP", now.Format(icalTimeFormat))
+		cal.line("LAST-MODIFIED", page.UpdatedAt.UTC().Format(icalTimeFormat))
+		cal.line("SUMMARY", page.Title)
+		cal.line("DESCRIPTION", page.PropertyName)
+		// Dates are all-day events, times are events of an hour
+		if start, err := time.Parse("2006-01-02", page.Date); err == nil {
+			cal.line("DTSTART;VALUE=DATE", start.Format(icalDateFormat))
+			cal.line("DTEND;VALUE=DATE", start.AddDate(0, 0, 1).Format(icalDateFormat))
+		} else if start, err := time.Parse(time.RFC3339, page.Date); err == nil {
+			cal.line("DTSTART", start.UTC().Format(icalTimeFormat))
+			cal.line("DTEND", start.UTC().Add(time.Hour).Format(icalTimeFormat))
+		}
+		cal.line("URL", baseURL+models.PageURL(page.PageID))
+		cal.line("END", "VEVENT")
+	}
+
+	cal.line("END", "VCALENDAR")
+
+	c.Response().Header().Set("Cache-Control", "private, max-age=300")
+	return c.Blob(http.StatusOK, "text/calendar; charset=utf-8", []byte(cal.String()))
+}
+
+const (
+	icalTimeFormat = "20060102T150405Z"
+	icalDateFormat = "20060102"
+)
+
+// icalEscaper escapes text property values (RFC 5545 section 3.3.11)
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// icalWriter builds an iCalendar document with CRLF line endings and long
+// lines folded at 75 octets
+type icalWriter struct {
+	strings.Builder
+}
+
+// line writes a content line. Values of parameters such as DUE;VALUE=DATE
+// are not text and are written as they are.
+func (w *icalWriter) line(name, value string) {
+	switch name {
+	case "SUMMARY", "DESCRIPTION", "X-WR-CALNAME":
+		value = icalEscaper.Replace(value)
+	}
+	content := name + ":" + value
+
+	// Fold without splitting a UTF-8 sequence. Continuation lines start
+	// with a space, which counts towards their length.
+	limit := 75
+	for len(content) > limit {
+		cut := limit
+		for cut > 0 && content[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		w.WriteString(content[:cut])
+		w.WriteString("\r\n ")
+		content = content[cut:]
+		limit = 74
+	}
+	w.WriteString(content)
+	w.WriteString("\r\n")
+}
+
+// atomFeed is an Atom 1.0 feed (RFC 4287)
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomPerson  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Published string      `xml:"published"`
+	Author    *atomPerson `xml:"author,omitempty"`
+	Link      atomLink    `xml:"link"`
+	Summary   string      `xml:"summary"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+// AtomFeed serves an Atom feed of recently created and updated pages,
+// newest first. It can be limited to pages with any of the given tags
+// (tag) or to a page and its subpages (page_id). Each save is a separate entry,
+// so feed readers show updates of a page they have already seen.
+func (h *Handler) AtomFeed(c echo.Context) error {
+	if _, err := models.GetFeedToken(h.db, c.Param("token")); err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "Invalid feed token",
+		})
+	}
+
+	isTemplate := false
+	opts := models.PageListOptions{
+		Limit:      50,
+		Sort:       models.PageSortUpdated,
+		Desc:       true,
+		IsTemplate: &isTemplate,
+	}
+	if l := c.QueryParam("limit"); l != "" {
+		if limitNum, err := strconv.Atoi(l); err == nil && limitNum > 0 && limitNum <= 100 {
+			opts.Limit = limitNum
+		}
+	}
+	// Pages with any of the tags are included
+	opts.TagsAny = true
+	for _, value := range c.QueryParams()["tag"] {
+		for _, name := range strings.Split(value, ",") {
+			if strings.TrimSpace(name) == "" {
+				continue
+			}
+			tag, err := models.NormalizeTagName(name)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"error": "Invalid tag",
+				})
+			}
+			opts.Tags = append(opts.Tags, tag)
+		}
+	}
+	if p := c.QueryParam("page_id"); p != "" {
+		pageID, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid page ID",
+			})
+		}
+		root := uint(pageID)
+		opts.Subtree = &root
+	}
+
+	pages, _, err := models.ListPages(h.db, opts)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to retrieve pages",
+		})
+	}
+
+	baseURL := getBaseURL(c)
+	host := c.Request().Host
+	selfURL := baseURL + c.Request().URL.RequestURI()
+
+	feed := atomFeed{
+		ID:      selfURL,
+		Title:   "メモの更新",
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Author:  atomPerson{Name: host},
+		Links: []atomLink{
+			{Href: selfURL, Rel: "self", Type: "application/atom+xml"},
+			{Href: baseURL},
+		},
+		Entries: make([]atomEntry, 0, len(pages)),
+	}
+	if len(pages) > 0 {
+		feed.Updated = pages[0].UpdatedAt.UTC().Format(time.RFC3339)
+	}
+
+	for _, page := range pages {
+		// A page saved within a second of its creation is reported as new
+		title := "更新: " + page.Title
+		author := page.UpdatedBy
+		if page.UpdatedAt.Sub(page.CreatedAt) < time.Second {
+			title = "作成: " + page.Title
+			author = page.Author
+		}
+
+		entry := atomEntry{
+			ID:        fmt.Sprintf("tag:%s,%s:page-%d-%d", host, page.CreatedAt.UTC().Format("2006-01-02"), page.ID, page.UpdatedAt.UnixMilli()),
+			Title:     title,
+			Updated:   page.UpdatedAt.UTC().Format(time.RFC3339),
+			Published: page.CreatedAt.UTC().Format(time.RFC3339),
+			Link:      atomLink{Href: baseURL + models.PageURL(page.ID), Rel: "alternate"},
+			Summary:   models.Excerpt(page.Content, models.PageExcerptLength),
+		}
+		if author != "" {
+			entry.Author = &atomPerson{Name: author}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	out, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to build feed",
+		})
+	}
+
+	c.Response().Header().Set("Cache-Control", "private, max-age=300")
+	return c.Blob(http.StatusOK, "application/atom+xml; charset=utf-8", append([]byte(xml.Header), out...))
+}
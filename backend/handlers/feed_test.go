@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestICalWriterEscaping(t *testing.T) {
+	tests := []struct {
+		name, value, want string
+	}{
+		{"SUMMARY", `a, b; c\d`, `SUMMARY:a\, b\; c\\d` + "\r\n"},
+		{"DESCRIPTION", "line 1\r\nline 2\nline 3", `DESCRIPTION:line 1\nline 2\nline 3` + "\r\n"},
+		{"X-WR-CALNAME", "Team, work", `X-WR-CALNAME:Team\, work` + "\r\n"},
+		// Non-text values are written as they are
+		{"DUE;VALUE=DATE", "20240501", "DUE;VALUE=DATE:20240501\r\n"},
+		{"URL", "http://example.com/a,b", "URL:http://example.com/a,b\r\n"},
+	}
+	for _, tt := range tests {
+		w := &icalWriter{}
+		w.line(tt.name, tt.value)
+		if got := w.String(); got != tt.want {
+			t.Errorf("line(%q, %q) = %q, want %q", tt.name, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestICalWriterFolding(t *testing.T) {
+	values := []string{
+		strings.Repeat("a", 200),
+		strings.Repeat("日本語のタスク", 20),
+		strings.Repeat("x", 66) + "漢字" + strings.Repeat("y", 80),
+		strings.Repeat("a", 66),
+	}
+	for _, value := range values {
+		w := &icalWriter{}
+		w.line("SUMMARY", value)
+		out := w.String()
+
+		if !strings.HasSuffix(out, "\r\n") {
+			t.Errorf("line does not end with CRLF: %q", out)
+		}
+		lines := strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+		for i, line := range lines {
+			if len(line) > 75 {
+				t.Errorf("line %d is %d octets long", i, len(line))
+			}
+			if i > 0 && !strings.HasPrefix(line, " ") {
+				t.Errorf("continuation line %d does not start with a space: %q", i, line)
+			}
+			if !utf8.ValidString(line) {
+				t.Errorf("line %d splits a UTF-8 sequence: %q", i, line)
+			}
+		}
+
+		// Unfolding restores the content line
+		if unfolded := strings.ReplaceAll(strings.TrimSuffix(out, "\r\n"), "\r\n ", ""); unfolded != "SUMMARY:"+value {
+			t.Errorf("unfolded line = %q, want %q", unfolded, "SUMMARY:"+value)
+		}
+	}
+}
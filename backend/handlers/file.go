@@ -1,6 +1,9 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,8 +15,10 @@ import (
 	"time"
 
 	"simultaneous-memo-app/backend/models"
+	"simultaneous-memo-app/backend/storage"
 
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
 )
 
 const (
@@ -28,6 +33,8 @@ var AllowedImageTypes = map[string]bool{
 	"image/png":  true,
 	"image/gif":  true,
 	"image/webp": true,
+	// SVGs are sanitized before they are stored
+	"image/svg+xml": true,
 }
 
 // AllowedImageExtensions contains allowed file extensions
@@ -37,6 +44,7 @@ var AllowedImageExtensions = map[string]bool{
 	".png":  true,
 	".gif":  true,
 	".webp": true,
+	".svg":  true,
 }
 
 // UploadFile handles file uploads with validation and saves metadata to database
@@ -49,21 +57,6 @@ func (h *Handler) UploadFile(c echo.Context) error {
 		})
 	}
 
-	// Validate file size
-	if file.Size > MaxFileSize {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": fmt.Sprintf("ファイルサイズが大きすぎます。最大サイズは%dMBです", MaxFileSize/1024/1024),
-		})
-	}
-
-	// Validate file extension
-	ext := strings.ToLower(filepath.Ext(file.Filename))
-	if !AllowedImageExtensions[ext] {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "許可されていないファイル形式です。JPEG、PNG、GIF、WebPのみアップロード可能です",
-		})
-	}
-
 	// Open the file
 	src, err := file.Open()
 	if err != nil {
@@ -75,133 +68,303 @@ func (h *Handler) UploadFile(c echo.Context) error {
 
 	// Read first 512 bytes to detect MIME type
 	buffer := make([]byte, 512)
-	_, err = src.Read(buffer)
+	n, err := src.Read(buffer)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "ファイルの読み取りに失敗しました",
 		})
 	}
 
-	// Detect content type
-	contentType := http.DetectContentType(buffer)
-	if !AllowedImageTypes[contentType] {
+	// Validate size, extension and detected content type
+	contentType, err := validateImageUpload(file.Filename, file.Size, buffer[:n])
+	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "許可されていないファイル形式です。画像ファイルのみアップロード可能です",
+			"error": err.Error(),
 		})
 	}
 
 	// Reset file reader to beginning
 	src.Seek(0, 0)
 
-	// Create uploads directory structure (YYYY/MM)
-	now := time.Now()
-	uploadsDir := fmt.Sprintf("../uploads/images/%d/%02d", now.Year(), now.Month())
-	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
+	// Store, process and create the thumbnail
+	imageRecord, err := saveImage(h.db, h.store, src, file.Filename, contentType)
+	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "アップロードディレクトリの作成に失敗しました",
+			"error": err.Error(),
 		})
 	}
 
-	// Generate unique filename with sanitization
-	timestamp := now.Unix()
-	safeFilename := sanitizeFilename(file.Filename)
-	filename := fmt.Sprintf("%d_%s", timestamp, safeFilename)
-	fullPath := filepath.Join(uploadsDir, filename)
+	// Check if page_id is provided in the request
+	if pageIDStr := c.FormValue("page_id"); pageIDStr != "" {
+		if pageID, err := strconv.ParseUint(pageIDStr, 10, 32); err == nil {
+			pageIDUint := uint(pageID)
+			imageRecord.PageID = &pageIDUint
+		}
+	}
 
-	// Create destination file
-	dst, err := os.Create(fullPath)
-	if err != nil {
+	// Save to database
+	if err := models.CreateImage(h.db, imageRecord); err != nil {
+		fmt.Printf("画像メタデータの保存エラー: %v\n", err)
+		releaseBlob(h.db, h.store, models.BlobKindImage, imageRecord.Checksum)
 		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "ファイルの作成に失敗しました",
+			"error": "画像メタデータの保存に失敗しました",
 		})
 	}
-	defer dst.Close()
+	
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"id":          imageRecord.ID,
+		"filename":    imageRecord.Filename,
+		"size":        imageRecord.Size,
+		"originalSize": file.Size,
+		"url":         fmt.Sprintf("/api/img%s", imageRecord.Path),
+		"thumbnailUrl": fmt.Sprintf("/api/img%s?size=thumbnail", imageRecord.Path),
+		"contentType": contentType,
+		"width":       imageRecord.Width,
+		"height":      imageRecord.Height,
+		"pageId":      imageRecord.PageID,
+		"checksum":    imageRecord.Checksum,
+		"exif": map[string]interface{}{
+			"cameraMake":   imageRecord.CameraMake,
+			"cameraModel":  imageRecord.CameraModel,
+			"takenAt":      imageRecord.TakenAt,
+			"orientation":  imageRecord.Orientation,
+			"gpsLatitude":  imageRecord.GPSLatitude,
+			"gpsLongitude": imageRecord.GPSLongitude,
+		},
+		"metadataRemoved": imageRecord.MetadataRemoved,
+		"blurhash":      imageRecord.BlurHash,
+		"preview":       imageRecord.Preview,
+		"dominantColor": imageRecord.DominantColor,
+		"variants":      imageRecord.Variants,
+		"srcset":        imageRecord.Srcset,
+		"uploadedAt":  imageRecord.CreatedAt,
+	})
+}
+
+// validateImageUpload checks the size, extension and sniffed content type of
+// an image upload and returns the detected content type
+func validateImageUpload(filename string, size int64, header []byte) (string, error) {
+	// Validate file size
+	if size > MaxFileSize {
+		return "", fmt.Errorf("ファイルサイズが大きすぎます。最大サイズは%dMBです", MaxFileSize/1024/1024)
+	}
 
-	// Copy file first to temporary location
-	if _, err := io.Copy(dst, src); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "ファイルの保存に失敗しました",
-		})
+	// Validate file extension
+	ext := strings.ToLower(filepath.Ext(filename))
+	if !AllowedImageExtensions[ext] {
+		return "", errors.New("許可されていないファイル形式です。JPEG、PNG、GIF、WebP、SVGのみアップロード可能です")
+	}
+
+	// Detect content type. SVG is sniffed as plain text or XML; whether it
+	// really is an SVG is checked when it is sanitized.
+	contentType := http.DetectContentType(header)
+	if ext == ".svg" && (strings.HasPrefix(contentType, "text/xml") || strings.HasPrefix(contentType, "text/plain")) {
+		contentType = "image/svg+xml"
+	}
+	if !AllowedImageTypes[contentType] {
+		return "", errors.New("許可されていないファイル形式です。画像ファイルのみアップロード可能です")
+	}
+
+	return contentType, nil
+}
+
+// saveImage optimizes an uploaded image and adds it to the blob store under
+// images/sha256, where identical images are stored only once. The returned
+// record is not yet saved to the database so callers can attach a page
+// before creating it; if that fails, its blob must be released.
+func saveImage(db *gorm.DB, store storage.Storage, src io.Reader, originalName, contentType string) (*models.Image, error) {
+	// Process the upload in a temporary file before storing it
+	ext := strings.ToLower(filepath.Ext(originalName))
+	tmp, err := os.CreateTemp("", "upload-*"+ext)
+	if err != nil {
+		return nil, errors.New("ファイルの作成に失敗しました")
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, src)
+	tmp.Close()
+	if err != nil {
+		return nil, errors.New("ファイルの保存に失敗しました")
+	}
+
+	// Remove scripts and external references before anything reads an SVG
+	if contentType == "image/svg+xml" {
+		if err := sanitizeSVGFile(tmp.Name()); err != nil {
+			fmt.Printf("SVGサニタイズエラー: %v\n", err)
+			return nil, errors.New("SVGファイルを読み取れませんでした")
+		}
+	}
+
+	// Read the metadata before processing drops it
+	image := &models.Image{}
+	original, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return nil, errors.New("ファイルの読み取りに失敗しました")
+	}
+	exifData, metadataKinds, err := scanImageMetadata(original, contentType)
+	if err != nil {
+		fmt.Printf("メタデータ読み取りエラー: %v\n", err)
+	}
+	if exifData != nil {
+		applyExif(image, exifData)
 	}
-	dst.Close()
 
 	// Process image (resize and optimize)
 	config := DefaultImageConfig()
-	processedPath := fullPath
-	
+
 	// For JPEG and PNG, apply processing
+	processed := false
 	if contentType == "image/jpeg" || contentType == "image/png" {
-		err = ProcessImage(fullPath, processedPath, config)
-		if err != nil {
+		if err := ProcessImage(tmp.Name(), tmp.Name(), config); err != nil {
 			// If processing fails, keep the original
-			processedPath = fullPath
+			fmt.Printf("画像処理エラー: %v\n", err)
+		} else {
+			processed = true
 		}
 	}
 
-	// Create thumbnail
-	thumbFilename := "thumb_" + filename
-	thumbPath := filepath.Join(uploadsDir, thumbFilename)
-	err = CreateThumbnail(processedPath, thumbPath, config)
+	// Strip location and device data from the file before anything is
+	// stored, so neither the image nor its thumbnail carries it
+	removed, err := stripImageMetadata(tmp.Name(), exifData, metadataKinds, processed, imageMetadataPolicy)
 	if err != nil {
-		// Log error but don't fail the upload
-		fmt.Printf("サムネイル作成エラー: %v\n", err)
+		fmt.Printf("メタデータ除去エラー: %v\n", err)
+		return nil, errors.New("画像のメタデータを除去できませんでした")
 	}
+	image.MetadataRemoved, _ = json.Marshal(removed)
 
 	// Get image dimensions
-	width, height, err := GetImageDimensions(processedPath)
+	width, height, err := GetImageDimensions(tmp.Name())
 	if err != nil {
 		width, height = 0, 0
 	}
 
-	// Get final file size after processing
-	fileInfo, _ := os.Stat(processedPath)
-	finalSize := file.Size
-	if fileInfo != nil {
-		finalSize = fileInfo.Size()
+	// Placeholders are optional, so failing to compute them is not fatal
+	if err := applyPlaceholders(image, tmp.Name()); err != nil {
+		fmt.Printf("プレースホルダー作成エラー: %v\n", err)
 	}
 
-	// Save image metadata to database
-	relativePath := fmt.Sprintf("/images/%d/%02d/%s", now.Year(), now.Month(), filename)
-	thumbRelativePath := fmt.Sprintf("/images/%d/%02d/%s", now.Year(), now.Month(), thumbFilename)
-	
-	imageRecord := &models.Image{
-		Filename:      filename,
-		OriginalName:  file.Filename,
-		Path:          relativePath,
-		ThumbnailPath: thumbRelativePath,
-		Size:          finalSize,
-		Width:         width,
-		Height:        height,
-		ContentType:   contentType,
+	// Store the processed image by its digest
+	blob, variants, err := storeImageBlob(db, store, tmp.Name(), ext, contentType)
+	if err != nil {
+		fmt.Printf("画像の保存エラー: %v\n", err)
+		return nil, errors.New("ファイルの保存に失敗しました")
 	}
 
-	// Check if page_id is provided in the request
-	if pageIDStr := c.FormValue("page_id"); pageIDStr != "" {
-		if pageID, err := strconv.ParseUint(pageIDStr, 10, 32); err == nil {
-			pageIDUint := uint(pageID)
-			imageRecord.PageID = &pageIDUint
+	image.Filename = filepath.Base(blob.Path)
+	image.OriginalName = originalName
+	image.Path = blob.Path
+	image.ThumbnailPath = blob.ThumbnailPath
+	image.Size = blob.Size
+	image.Width = width
+	image.Height = height
+	image.ContentType = contentType
+	image.Checksum = blob.Digest
+	image.Variants, _ = json.Marshal(variants)
+	image.Srcset = variantSrcset(image, variants)
+	return image, nil
+}
+
+// uniqueUploadKey returns a key named <timestamp>_<safeFilename> under
+// prefix that is not stored yet, adding a counter if another upload in the
+// same second already used the name. It returns the key and the filename.
+func uniqueUploadKey(ctx context.Context, store storage.Storage, prefix string, now time.Time, safeFilename string) (string, string, error) {
+	timestamp := now.Unix()
+	filename := fmt.Sprintf("%d_%s", timestamp, safeFilename)
+	for i := 1; ; i++ {
+		_, err := store.Stat(ctx, prefix+filename)
+		if err == storage.ErrNotExist {
+			return prefix + filename, filename, nil
 		}
+		if err != nil {
+			return "", "", err
+		}
+		if i == 100 {
+			return "", "", fmt.Errorf("%s の空きファイル名が見つかりません", safeFilename)
+		}
+		filename = fmt.Sprintf("%d_%d_%s", timestamp, i, safeFilename)
 	}
+}
 
-	// Save to database
-	if err := models.CreateImage(h.db, imageRecord); err != nil {
-		// Log error but don't fail the upload
-		fmt.Printf("画像メタデータの保存エラー: %v\n", err)
+// copyImage returns an unsaved record for a copy of an image. Copies share
+// the image's blob; images stored before deduplication have their files
+// copied instead, so pages never share the same files.
+func copyImage(db *gorm.DB, store storage.Storage, image *models.Image) (*models.Image, error) {
+	imageCopy := *image
+	imageCopy.ID = 0
+	imageCopy.PageID = nil
+	imageCopy.CreatedAt = time.Time{}
+	imageCopy.UpdatedAt = time.Time{}
+
+	if image.Checksum != "" {
+		// Edited images also keep their original so the copy can be
+		// reverted. Both references are taken together so a failure
+		// cannot leave one behind.
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := models.AddBlobReference(tx, models.BlobKindImage, image.Checksum); err != nil {
+				return err
+			}
+			if image.OriginalChecksum != "" {
+				return models.AddBlobReference(tx, models.BlobKindImage, image.OriginalChecksum)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("画像 %s のコピーに失敗しました: %w", image.Filename, err)
+		}
+		return &imageCopy, nil
 	}
-	
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"id":          imageRecord.ID,
-		"filename":    filename,
-		"size":        finalSize,
-		"originalSize": file.Size,
-		"url":         fmt.Sprintf("/api/img%s", relativePath),
-		"thumbnailUrl": fmt.Sprintf("/api/img%s?size=thumbnail", relativePath),
-		"contentType": contentType,
-		"width":       width,
-		"height":      height,
-		"pageId":      imageRecord.PageID,
-		"uploadedAt":  imageRecord.CreatedAt,
-	})
+
+	ctx := context.Background()
+	now := time.Now()
+	prefix := fmt.Sprintf("images/%d/%02d/", now.Year(), now.Month())
+	key, filename, err := uniqueUploadKey(ctx, store, prefix, now, sanitizeFilename(image.OriginalName))
+	if err != nil {
+		return nil, errors.New("ファイルの作成に失敗しました")
+	}
+	if err := storage.Copy(ctx, store, key, store, models.ImageKey(image.Path), image.ContentType); err != nil {
+		return nil, fmt.Errorf("画像 %s のコピーに失敗しました: %w", image.Filename, err)
+	}
+
+	imageCopy.Filename = filename
+	imageCopy.Path = "/" + key
+	imageCopy.ThumbnailPath = ""
+	// Variants belong to the original's files
+	imageCopy.Variants = nil
+	imageCopy.Srcset = ""
+
+	if image.ThumbnailPath != "" {
+		thumbKey := prefix + "thumb_" + filename
+		if err := storage.Copy(ctx, store, thumbKey, store, models.ImageKey(image.ThumbnailPath), image.ContentType); err != nil {
+			// The thumbnail can be regenerated, so a missing one is not fatal
+			fmt.Printf("サムネイルのコピーエラー: %v\n", err)
+		} else {
+			imageCopy.ThumbnailPath = "/" + thumbKey
+		}
+	}
+
+	return &imageCopy, nil
+}
+
+// removeImageFiles deletes an image file, its thumbnail and its resized
+// variants from storage, returning any errors
+func removeImageFiles(store storage.Storage, path, thumbnailPath string) []error {
+	ctx := context.Background()
+	errs := []error{}
+	if err := store.Delete(ctx, models.ImageKey(path)); err != nil {
+		errs = append(errs, fmt.Errorf("メイン画像の削除エラー: %w", err))
+	}
+	if thumbnailPath != "" {
+		if err := store.Delete(ctx, models.ImageKey(thumbnailPath)); err != nil {
+			errs = append(errs, fmt.Errorf("サムネイルの削除エラー: %w", err))
+		}
+	}
+	if err := removeImageVariants(ctx, store, path); err != nil {
+		errs = append(errs, fmt.Errorf("バリアントの削除エラー: %w", err))
+	}
+	if imageVariants != nil {
+		imageVariants.Invalidate(path)
+	}
+	return errs
 }
 
 // sanitizeFilename removes potentially dangerous characters from filename
@@ -231,7 +394,6 @@ func sanitizeFilename(filename string) string {
 func (h *Handler) GetFile(c echo.Context) error {
 	// Parse the path parameter to support nested directories
 	path := c.Param("*")
-	fullPath := filepath.Join("../uploads", path)
 
 	// Prevent path traversal attacks
 	if strings.Contains(path, "..") {
@@ -241,15 +403,22 @@ func (h *Handler) GetFile(c echo.Context) error {
 	}
 
 	// Check if file exists
-	fileInfo, err := os.Stat(fullPath)
-	if os.IsNotExist(err) {
+	ctx := c.Request().Context()
+	fileInfo, err := h.store.Stat(ctx, path)
+	if err == storage.ErrNotExist {
 		return c.JSON(http.StatusNotFound, map[string]string{
 			"error": "ファイルが見つかりません",
 		})
 	}
+	if err != nil {
+		fmt.Printf("ファイル情報の取得エラー: %v\n", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "ファイルを開けませんでした",
+		})
+	}
 
 	// Open file to detect MIME type
-	file, err := os.Open(fullPath)
+	file, err := h.store.Get(ctx, path)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "ファイルを開けませんでした",
@@ -258,19 +427,19 @@ func (h *Handler) GetFile(c echo.Context) error {
 	defer file.Close()
 
 	// Get MIME type based on file extension first
-	contentType := GetMIMEType(fullPath)
+	contentType := GetMIMEType(path)
 	
 	// If unknown, detect from file content
 	if contentType == "application/octet-stream" {
 		buffer := make([]byte, 512)
-		n, _ := file.Read(buffer)
+		n, _ := io.ReadFull(file, buffer)
 		contentType = http.DetectContentType(buffer[:n])
 		// Reset file pointer
-		file.Seek(0, 0)
+		file.Seek(0, io.SeekStart)
 	}
 
 	// Get appropriate headers for the file
-	headers := GetImageHeaders(fullPath, fileInfo.Size())
+	headers := GetImageHeaders(path, fileInfo.Size)
 	for key, value := range headers {
 		c.Response().Header().Set(key, value)
 	}
@@ -282,7 +451,7 @@ func (h *Handler) GetFile(c echo.Context) error {
 	}
 
 	// Set Content-Disposition for download
-	filename := filepath.Base(fullPath)
+	filename := filepath.Base(path)
 	if c.QueryParam("download") == "true" {
 		c.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
 	} else {
@@ -290,16 +459,16 @@ func (h *Handler) GetFile(c echo.Context) error {
 	}
 
 	// Set Last-Modified header
-	c.Response().Header().Set("Last-Modified", fileInfo.ModTime().Format(http.TimeFormat))
+	c.Response().Header().Set("Last-Modified", fileInfo.ModTime.Format(http.TimeFormat))
 
 	// Handle If-Modified-Since header for caching
 	if ifModifiedSince := c.Request().Header.Get("If-Modified-Since"); ifModifiedSince != "" {
 		t, err := time.Parse(http.TimeFormat, ifModifiedSince)
-		if err == nil && !fileInfo.ModTime().After(t) {
+		if err == nil && !fileInfo.ModTime.After(t) {
 			return c.NoContent(http.StatusNotModified)
 		}
 	}
 
 	// Serve the file
 	return c.Stream(http.StatusOK, contentType, file)
-}
\ No newline at end of file
+}
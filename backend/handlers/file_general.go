@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,11 +12,16 @@ import (
 	"time"
 
 	"simultaneous-memo-app/backend/models"
+	"simultaneous-memo-app/backend/storage"
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
 )
 
 const (
 	MaxGeneralFileSize = 50 * 1024 * 1024 // 50MB
+
+	// fileURLExpiry is how long presigned download URLs stay valid
+	fileURLExpiry = 15 * time.Minute
 )
 
 // AllowedFileTypes defines allowed file extensions and their MIME types
@@ -104,27 +110,29 @@ func (h *Handler) UploadGeneralFile(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid file content type"})
 	}
 
-	// Create directory structure
-	uploadDir := filepath.Join("uploads", "files", time.Now().Format("2006/01"))
-	if err := os.MkdirAll(uploadDir, 0755); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create upload directory"})
+	// Write the upload to a temporary file to compute its digest
+	tmp, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create file"})
 	}
+	defer os.Remove(tmp.Name())
 
-	// Generate unique filename
-	timestamp := time.Now().Unix()
-	safeFilename := sanitizeGeneralFilename(file.Filename)
-	filename := fmt.Sprintf("%d_%s", timestamp, safeFilename)
-	filePath := filepath.Join(uploadDir, filename)
+	_, err = io.Copy(tmp, src)
+	tmp.Close()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save file"})
+	}
 
-	// Save the file
-	dst, err := os.Create(filePath)
+	// Store the content by its digest, reusing an identical earlier upload
+	blob, err := storeFileBlob(h.db, h.store, tmp.Name(), contentType)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create file"})
+		fmt.Printf("ファイルの保存エラー: %v\n", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save file"})
 	}
-	defer dst.Close()
 
-	if _, err = io.Copy(dst, src); err != nil {
-		os.Remove(filePath)
+	filename, err := uniqueFilename(h.db, time.Now(), sanitizeGeneralFilename(file.Filename))
+	if err != nil {
+		releaseBlob(h.db, h.store, models.BlobKindFile, blob.Digest)
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save file"})
 	}
 
@@ -142,13 +150,14 @@ func (h *Handler) UploadGeneralFile(c echo.Context) error {
 		Filename:     filename,
 		OriginalName: file.Filename,
 		ContentType:  contentType,
-		Size:         file.Size,
-		Path:         filePath,
+		Size:         blob.Size,
+		Path:         blob.Path,
+		Checksum:     blob.Digest,
 		PageID:       pageID,
 	}
 
 	if err := h.db.Create(fileModel).Error; err != nil {
-		os.Remove(filePath)
+		releaseBlob(h.db, h.store, models.BlobKindFile, blob.Digest)
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save file metadata"})
 	}
 
@@ -252,16 +261,12 @@ func (h *Handler) DeleteFile(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "File not found"})
 	}
 
-	// Delete physical file
-	if err := os.Remove(file.Path); err != nil && !os.IsNotExist(err) {
+	// Delete the record, and the physical file once no other record uses it
+	if err := deleteFileRecord(h.db, h.store, &file); err != nil {
+		fmt.Printf("ファイル削除エラー: %v\n", err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete file"})
 	}
 
-	// Delete database record
-	if err := h.db.Delete(&file).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete file metadata"})
-	}
-
 	return c.JSON(http.StatusOK, map[string]string{"message": "File deleted successfully"})
 }
 
@@ -283,42 +288,105 @@ func (h *Handler) ServeFile(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "File not found"})
 	}
 
-	// Validate that the file path is within uploads directory
-	absPath, err := filepath.Abs(file.Path)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Invalid file path"})
+	// Determine if file should be downloaded or displayed inline
+	disposition := "inline"
+	if c.QueryParam("download") == "true" {
+		disposition = "attachment"
 	}
-	
-	uploadsDir, err := filepath.Abs("uploads")
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Server configuration error"})
+	disposition = fmt.Sprintf(`%s; filename="%s"`, disposition, file.OriginalName)
+
+	// Let the browser download straight from the storage backend if it can
+	ctx := c.Request().Context()
+	url, err := h.store.PresignGet(ctx, models.FileKey(file.Path), fileURLExpiry, file.ContentType, disposition)
+	if err == nil {
+		return c.Redirect(http.StatusFound, url)
 	}
-	
-	// Ensure the file path is within the uploads directory
-	if !strings.HasPrefix(absPath, uploadsDir) {
-		return c.JSON(http.StatusForbidden, map[string]string{"error": "Access denied"})
+	if err != storage.ErrUnsupported {
+		fmt.Printf("署名付きURLの作成エラー: %v\n", err)
 	}
 
-	// Check if file exists
-	if _, err := os.Stat(file.Path); os.IsNotExist(err) {
-		return c.JSON(http.StatusNotFound, map[string]string{"error": "File not found on disk"})
+	content, err := openFileContent(ctx, h.store, file.Path)
+	if err == storage.ErrNotExist {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "File not found in storage"})
+	}
+	if err != nil {
+		fmt.Printf("ファイル読み込みエラー: %v\n", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to open file"})
 	}
+	defer content.Close()
 
 	// Set appropriate headers
 	c.Response().Header().Set("Content-Type", file.ContentType)
-	c.Response().Header().Set("Content-Length", fmt.Sprintf("%d", file.Size))
-	
+
 	// Set cache headers for static files
 	c.Response().Header().Set("Cache-Control", "public, max-age=86400") // 1 day
+	c.Response().Header().Set("Content-Disposition", disposition)
 
-	// Determine if file should be downloaded or displayed inline
-	disposition := "inline"
-	if c.QueryParam("download") == "true" {
-		disposition = "attachment"
+	http.ServeContent(c.Response(), c.Request(), file.Filename, file.CreatedAt, content)
+	return nil
+}
+
+// copyGeneralFile returns an unsaved record for a copy of an uploaded
+// file. Copies share the file's blob; files stored before deduplication
+// are copied to a new object instead.
+func copyGeneralFile(db *gorm.DB, store storage.Storage, file *models.File) (*models.File, error) {
+	now := time.Now()
+	filename, err := uniqueFilename(db, now, sanitizeGeneralFilename(file.OriginalName))
+	if err != nil {
+		return nil, err
+	}
+
+	if file.Checksum != "" {
+		if err := models.AddBlobReference(db, models.BlobKindFile, file.Checksum); err != nil {
+			return nil, err
+		}
+		return &models.File{
+			Filename:     filename,
+			OriginalName: file.OriginalName,
+			ContentType:  file.ContentType,
+			Size:         file.Size,
+			Path:         file.Path,
+			Checksum:     file.Checksum,
+		}, nil
+	}
+
+	ctx := context.Background()
+	src, err := openFileContent(ctx, store, file.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	key := fmt.Sprintf("files/%s/%s", now.Format("2006/01"), filename)
+	if err := store.Put(ctx, key, src, file.Size, file.ContentType); err != nil {
+		return nil, err
 	}
-	c.Response().Header().Set("Content-Disposition", fmt.Sprintf(`%s; filename="%s"`, disposition, file.OriginalName))
 
-	return c.File(file.Path)
+	return &models.File{
+		Filename:     filename,
+		OriginalName: file.OriginalName,
+		ContentType:  file.ContentType,
+		Size:         file.Size,
+		Path:         key,
+	}, nil
+}
+
+// uniqueFilename returns a name of the form <timestamp>_<safeFilename>
+// that no other file record uses yet. Files are served by this name, so
+// records sharing a blob still need their own.
+func uniqueFilename(db *gorm.DB, now time.Time, safeFilename string) (string, error) {
+	timestamp := now.Unix()
+	filename := fmt.Sprintf("%d_%s", timestamp, safeFilename)
+	for i := 1; ; i++ {
+		var count int64
+		if err := db.Model(&models.File{}).Where("filename = ?", filename).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return filename, nil
+		}
+		filename = fmt.Sprintf("%d_%d_%s", timestamp, i, safeFilename)
+	}
 }
 
 // sanitizeGeneralFilename removes potentially dangerous characters from filename
@@ -1,13 +1,44 @@
 package handlers
 
 import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"simultaneous-memo-app/backend/storage"
+	"simultaneous-memo-app/backend/websocket"
+
 	"gorm.io/gorm"
 )
 
 type Handler struct {
-	db *gorm.DB
+	db       *gorm.DB
+	hub      *websocket.Hub
+	store    storage.Storage
+	variants *variantCache
 }
 
-func NewHandler(db *gorm.DB) *Handler {
-	return &Handler{db: db}
-}
\ No newline at end of file
+// NewHandler creates the handlers. Resized images are cached on local disk
+// in variantCacheDir whichever storage holds the originals.
+func NewHandler(db *gorm.DB, hub *websocket.Hub, store storage.Storage, variantCacheDir string) *Handler {
+	variants := newVariantCache(variantCacheDir, variantCacheMaxBytes)
+	// Image files are removed by helpers that have no handler, and they
+	// invalidate the cached variants through imageVariants
+	imageVariants = variants
+	return &Handler{db: db, hub: hub, store: store, variants: variants}
+}
+
+// notifyPage sends a JSON event to everyone listening on the events socket
+// of a page. The payload is sent with its "type" set to the event name.
+func (h *Handler) notifyPage(pageID uint, event string, payload map[string]interface{}) {
+	if h.hub == nil {
+		return
+	}
+	payload["type"] = event
+	data, err := json.Marshal(payload)
+	if err != nil {
+		fmt.Printf("通知のエンコードエラー: %v\n", err)
+		return
+	}
+	h.hub.Publish(strconv.FormatUint(uint64(pageID), 10), event, data)
+}
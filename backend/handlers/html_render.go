@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+
+	"simultaneous-memo-app/backend/models"
+)
+
+// htmlRenderer converts TipTap documents into HTML. Only the node and mark
+// types of the editor schema are rendered, all text and attributes are
+// escaped and link targets are checked, so the output is safe to serve
+// even if the stored document was not.
+type htmlRenderer struct {
+	// image returns the attributes of the img tag for an image node, or nil
+	// to leave the image out
+	image func(attrs map[string]interface{}) map[string]string
+	// pageURL returns the URL to use for a link to another page; links to
+	// pages without one are rendered as plain text
+	pageURL func(id uint) (string, bool)
+}
+
+// Render renders a doc node as HTML
+func (r *htmlRenderer) Render(doc map[string]interface{}) string {
+	var b strings.Builder
+	r.blocks(&b, nodeChildren(doc))
+	return b.String()
+}
+
+func (r *htmlRenderer) blocks(b *strings.Builder, nodes []map[string]interface{}) {
+	for _, node := range nodes {
+		r.block(b, node)
+	}
+}
+
+func (r *htmlRenderer) block(b *strings.Builder, node map[string]interface{}) {
+	attrs := nodeAttrs(node)
+
+	switch nodeType(node) {
+	case "paragraph":
+		b.WriteString("<p>")
+		r.inline(b, nodeChildren(node))
+		b.WriteString("</p>\n")
+	case "heading":
+		level := intAttr(attrs, "level", 1)
+		if level < 1 || level > 6 {
+			level = 1
+		}
+		fmt.Fprintf(b, "<h%d>", level)
+		r.inline(b, nodeChildren(node))
+		fmt.Fprintf(b, "</h%d>\n", level)
+	case "blockquote":
+		b.WriteString("<blockquote>\n")
+		r.blocks(b, nodeChildren(node))
+		b.WriteString("</blockquote>\n")
+	case "bulletList":
+		r.list(b, "<ul>\n", "</ul>\n", node)
+	case "orderedList":
+		open := "<ol>\n"
+		if start := intAttr(attrs, "start", 1); start != 1 {
+			open = fmt.Sprintf("<ol start=\"%d\">\n", start)
+		}
+		r.list(b, open, "</ol>\n", node)
+	case "taskList":
+		r.list(b, "<ul class=\"task-list\">\n", "</ul>\n", node)
+	case "codeBlock":
+		b.WriteString("<pre><code")
+		if language := stringAttr(attrs, "language"); language != "" {
+			fmt.Fprintf(b, " class=\"language-%s\"", html.EscapeString(language))
+		}
+		b.WriteString(">")
+		b.WriteString(html.EscapeString(nodeText(node)))
+		b.WriteString("</code></pre>\n")
+	case "horizontalRule":
+		b.WriteString("<hr>\n")
+	case "image", "resizableImage":
+		r.img(b, attrs)
+	case "table":
+		r.table(b, node)
+	default:
+		// Unknown containers still contribute their children
+		r.blocks(b, nodeChildren(node))
+	}
+}
+
+func (r *htmlRenderer) list(b *strings.Builder, open, close string, node map[string]interface{}) {
+	b.WriteString(open)
+	for _, item := range nodeChildren(node) {
+		if nodeType(item) == "taskItem" {
+			b.WriteString("<li class=\"task-item\"><input type=\"checkbox\" disabled")
+			if checked, _ := nodeAttrs(item)["checked"].(bool); checked {
+				b.WriteString(" checked")
+			}
+			b.WriteString(">")
+		} else {
+			b.WriteString("<li>")
+		}
+		r.blocks(b, nodeChildren(item))
+		b.WriteString("</li>\n")
+	}
+	b.WriteString(close)
+}
+
+func (r *htmlRenderer) img(b *strings.Builder, attrs map[string]interface{}) {
+	if r.image == nil {
+		return
+	}
+	imgAttrs := r.image(attrs)
+	if imgAttrs == nil {
+		return
+	}
+
+	b.WriteString("<img")
+	for _, name := range []string{"src", "srcset", "sizes", "width", "height", "alt", "title"} {
+		if value, ok := imgAttrs[name]; ok && (value != "" || name == "alt") {
+			fmt.Fprintf(b, " %s=\"%s\"", name, html.EscapeString(value))
+		}
+	}
+	b.WriteString(" loading=\"lazy\" decoding=\"async\">\n")
+}
+
+func (r *htmlRenderer) table(b *strings.Builder, node map[string]interface{}) {
+	b.WriteString("<table>\n")
+	for _, row := range nodeChildren(node) {
+		b.WriteString("<tr>")
+		for _, cell := range nodeChildren(row) {
+			tag := "td"
+			if nodeType(cell) == "tableHeader" {
+				tag = "th"
+			}
+			b.WriteString("<" + tag)
+			cellAttrs := nodeAttrs(cell)
+			if span := intAttr(cellAttrs, "colspan", 1); span > 1 {
+				fmt.Fprintf(b, " colspan=\"%d\"", span)
+			}
+			if span := intAttr(cellAttrs, "rowspan", 1); span > 1 {
+				fmt.Fprintf(b, " rowspan=\"%d\"", span)
+			}
+			b.WriteString(">")
+			r.blocks(b, nodeChildren(cell))
+			b.WriteString("</" + tag + ">")
+		}
+		b.WriteString("</tr>\n")
+	}
+	b.WriteString("</table>\n")
+}
+
+// inline renders text, mentions and hard breaks with their marks
+func (r *htmlRenderer) inline(b *strings.Builder, nodes []map[string]interface{}) {
+	for _, node := range nodes {
+		switch nodeType(node) {
+		case "text":
+			r.text(b, node)
+		case "hardBreak":
+			b.WriteString("<br>")
+		case "mention":
+			r.mention(b, nodeAttrs(node))
+		}
+	}
+}
+
+// htmlMarkTags maps marks to their tags, innermost first
+var htmlMarkTags = []struct{ mark, tag string }{
+	{"code", "code"},
+	{"bold", "strong"},
+	{"italic", "em"},
+	{"underline", "u"},
+	{"strike", "s"},
+}
+
+func (r *htmlRenderer) text(b *strings.Builder, node map[string]interface{}) {
+	out := html.EscapeString(nodeTextValue(node))
+	marks := nodeMarks(node)
+	for _, m := range htmlMarkTags {
+		for _, mark := range marks {
+			if mark == m.mark {
+				out = "<" + m.tag + ">" + out + "</" + m.tag + ">"
+				break
+			}
+		}
+	}
+	if href, ok := linkHref(node); ok {
+		if target, ok := r.linkURL(href); ok {
+			out = fmt.Sprintf("<a href=\"%s\" rel=\"noopener noreferrer nofollow\">%s</a>", html.EscapeString(target), out)
+		}
+	}
+	b.WriteString(out)
+}
+
+func (r *htmlRenderer) mention(b *strings.Builder, attrs map[string]interface{}) {
+	label := html.EscapeString("@" + stringAttr(attrs, "label"))
+	if stringAttr(attrs, "type") == "page" {
+		var id uint
+		switch v := attrs["id"].(type) {
+		case float64:
+			id = uint(v)
+		case string:
+			if parsed, err := strconv.ParseUint(v, 10, 32); err == nil {
+				id = uint(parsed)
+			}
+		}
+		if target, ok := r.pageTarget(id); ok {
+			fmt.Fprintf(b, "<a class=\"mention\" href=\"%s\">%s</a>", html.EscapeString(target), label)
+			return
+		}
+	}
+	fmt.Fprintf(b, "<span class=\"mention\">%s</span>", label)
+}
+
+// linkURL checks a link target and points links to other pages at their
+// public version
+func (r *htmlRenderer) linkURL(href string) (string, bool) {
+	if id, ok := models.ParsePageURL(href); ok {
+		return r.pageTarget(id)
+	}
+	if !models.IsSafeLinkURL(href) {
+		return "", false
+	}
+	return href, true
+}
+
+func (r *htmlRenderer) pageTarget(id uint) (string, bool) {
+	if id == 0 || r.pageURL == nil {
+		return "", false
+	}
+	return r.pageURL(id)
+}
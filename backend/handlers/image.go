@@ -1,10 +1,7 @@
 package handlers
 
 import (
-	"fmt"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
 
 	"simultaneous-memo-app/backend/models"
@@ -82,29 +79,17 @@ func (h *Handler) DeleteImageByID(c echo.Context) error {
 		})
 	}
 
-	// Delete actual files from filesystem
-	errors := []string{}
-	
-	// Delete main image file
-	mainPath := filepath.Join("../uploads", image.Path)
-	if err := os.Remove(mainPath); err != nil && !os.IsNotExist(err) {
-		errors = append(errors, fmt.Sprintf("メイン画像の削除エラー: %v", err))
-	}
-	
-	// Delete thumbnail file
-	if image.ThumbnailPath != "" {
-		thumbPath := filepath.Join("../uploads", image.ThumbnailPath)
-		if err := os.Remove(thumbPath); err != nil && !os.IsNotExist(err) {
-			errors = append(errors, fmt.Sprintf("サムネイルの削除エラー: %v", err))
-		}
-	}
-
-	// Delete from database
-	if err := models.DeleteImage(h.db, uint(id)); err != nil {
+	// Delete from database, and the files once no other image uses them
+	fileErrors, err := deleteImageRecord(h.db, h.store, image)
+	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "データベースからの削除に失敗しました",
 		})
 	}
+	errors := []string{}
+	for _, err := range fileErrors {
+		errors = append(errors, err.Error())
+	}
 
 	// Return response
 	if len(errors) > 0 {
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// variantCacheMaxBytes caps the total size of cached variants (512MB)
+const variantCacheMaxBytes = 512 * 1024 * 1024
+
+// imageVariants is the variant cache of the handler, invalidated when
+// image files are removed. It is nil until NewHandler sets it.
+var imageVariants *variantCache
+
+// variantCache keeps resized image variants on disk and evicts the least
+// recently used ones once the cache grows beyond maxBytes. Variants are
+// grouped in a directory per source image, so all variants of an image can
+// be dropped when it is deleted or edited. Concurrent requests for the same
+// missing variant share a single render.
+type variantCache struct {
+	dir      string
+	maxBytes int64
+
+	once  sync.Once
+	mu    sync.Mutex
+	size  int64
+	lru   *list.List               // most recently used at the front
+	items map[string]*list.Element // by path relative to dir
+	group singleflight.Group
+}
+
+// variantEntry is a cached variant file
+type variantEntry struct {
+	path string
+	size int64
+}
+
+func newVariantCache(dir string, maxBytes int64) *variantCache {
+	return &variantCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// load indexes the variants left on disk by a previous run, treating the
+// most recently written as the most recently used
+func (c *variantCache) load() {
+	type found struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []found
+	filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		// Renders interrupted by a restart
+		if strings.HasPrefix(d.Name(), "tmp-") {
+			os.Remove(path)
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(c.dir, path)
+		if err != nil {
+			return nil
+		}
+		files = append(files, found{path: rel, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, f := range files {
+		c.items[f.path] = c.lru.PushFront(&variantEntry{path: f.path, size: f.size})
+		c.size += f.size
+	}
+	c.evict()
+}
+
+// sourceDir returns the directory holding the variants of a source image
+func sourceDir(sourcePath string) string {
+	sum := sha256.Sum256([]byte(filepath.ToSlash(filepath.Clean("/" + sourcePath))))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Get opens the cached variant identified by key, rendering it with render
+// first if it is not cached. The key must change whenever the output would,
+// e.g. by including the size, quality and the source's modification time.
+func (c *variantCache) Get(sourcePath, key, ext string, render func(w io.Writer) error) (*os.File, error) {
+	c.once.Do(c.load)
+
+	sum := sha256.Sum256([]byte(key))
+	rel := filepath.Join(sourceDir(sourcePath), hex.EncodeToString(sum[:])+ext)
+
+	if file := c.open(rel); file != nil {
+		return file, nil
+	}
+
+	_, err, _ := c.group.Do(rel, func() (interface{}, error) {
+		c.mu.Lock()
+		_, ok := c.items[rel]
+		c.mu.Unlock()
+		if ok {
+			return nil, nil
+		}
+		return nil, c.store(rel, render)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if file := c.open(rel); file != nil {
+		return file, nil
+	}
+	return nil, fmt.Errorf("キャッシュされた画像が見つかりません: %s", rel)
+}
+
+// open opens a cached variant and marks it as recently used. The file is
+// opened under the lock so eviction cannot remove it in between.
+func (c *variantCache) open(rel string) *os.File {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[rel]
+	if !ok {
+		return nil
+	}
+	file, err := os.Open(filepath.Join(c.dir, rel))
+	if err != nil {
+		// Removed from disk behind our back
+		c.remove(elem)
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return file
+}
+
+// store renders a variant into a temporary file and moves it into place
+func (c *variantCache) store(rel string, render func(w io.Writer) error) error {
+	dir := filepath.Join(c.dir, filepath.Dir(rel))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := render(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(c.dir, rel)); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items[rel] = c.lru.PushFront(&variantEntry{path: rel, size: info.Size()})
+	c.size += info.Size()
+	c.evict()
+	return nil
+}
+
+// evict removes least recently used variants until the cache fits. The
+// newest variant is always kept. Must be called with mu held.
+func (c *variantCache) evict() {
+	for c.size > c.maxBytes && c.lru.Len() > 1 {
+		elem := c.lru.Back()
+		os.Remove(filepath.Join(c.dir, elem.Value.(*variantEntry).path))
+		c.remove(elem)
+	}
+}
+
+// remove drops an entry from the index. Must be called with mu held.
+func (c *variantCache) remove(elem *list.Element) {
+	entry := elem.Value.(*variantEntry)
+	c.lru.Remove(elem)
+	delete(c.items, entry.path)
+	c.size -= entry.size
+}
+
+// Invalidate removes every cached variant of a source image. It is called
+// when the image is deleted or its file is replaced.
+func (c *variantCache) Invalidate(sourcePath string) {
+	c.once.Do(c.load)
+
+	dir := sourceDir(sourcePath)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for rel, elem := range c.items {
+		if filepath.Dir(rel) == dir {
+			c.remove(elem)
+		}
+	}
+	os.RemoveAll(filepath.Join(c.dir, dir))
+}
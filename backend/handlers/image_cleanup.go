@@ -1,17 +1,17 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
-	"os"
-	"path/filepath"
 	"time"
 
 	"simultaneous-memo-app/backend/models"
+	"simultaneous-memo-app/backend/storage"
 	"gorm.io/gorm"
 )
 
 // DeleteImagesByPageID deletes all images associated with a page
-func DeleteImagesByPageID(db *gorm.DB, pageID uint) error {
+func DeleteImagesByPageID(db *gorm.DB, store storage.Storage, pageID uint) error {
 	// Get all images for the page
 	images, err := models.GetImagesByPageID(db, pageID)
 	if err != nil {
@@ -22,24 +22,14 @@ func DeleteImagesByPageID(db *gorm.DB, pageID uint) error {
 
 	// Delete each image
 	for _, image := range images {
-		// Delete main image file
-		mainPath := filepath.Join("../uploads", image.Path)
-		if err := os.Remove(mainPath); err != nil && !os.IsNotExist(err) {
-			errors = append(errors, fmt.Errorf("画像 %s の削除エラー: %w", image.Filename, err))
-		}
-
-		// Delete thumbnail
-		if image.ThumbnailPath != "" {
-			thumbPath := filepath.Join("../uploads", image.ThumbnailPath)
-			if err := os.Remove(thumbPath); err != nil && !os.IsNotExist(err) {
-				errors = append(errors, fmt.Errorf("サムネイル %s の削除エラー: %w", image.Filename, err))
-			}
-		}
-
-		// Delete from database
-		if err := models.DeleteImage(db, image.ID); err != nil {
+		// Delete from database, and the files once no other image uses them
+		fileErrors, err := deleteImageRecord(db, store, &image)
+		if err != nil {
 			errors = append(errors, fmt.Errorf("画像 %s のDB削除エラー: %w", image.Filename, err))
 		}
+		for _, err := range fileErrors {
+			errors = append(errors, fmt.Errorf("画像 %s: %w", image.Filename, err))
+		}
 	}
 
 	if len(errors) > 0 {
@@ -50,7 +40,7 @@ func DeleteImagesByPageID(db *gorm.DB, pageID uint) error {
 }
 
 // CleanupOrphanedImages removes images not associated with any page
-func CleanupOrphanedImages(db *gorm.DB, olderThan time.Duration) error {
+func CleanupOrphanedImages(db *gorm.DB, store storage.Storage, olderThan time.Duration) error {
 	// Get orphaned images older than specified duration
 	cutoffTime := time.Now().Add(-olderThan)
 	orphanedImages, err := models.GetOrphanedImages(db, cutoffTime)
@@ -62,26 +52,15 @@ func CleanupOrphanedImages(db *gorm.DB, olderThan time.Duration) error {
 	errors := []error{}
 
 	for _, image := range orphanedImages {
-		// Delete main image file
-		mainPath := filepath.Join("../uploads", image.Path)
-		if err := os.Remove(mainPath); err != nil && !os.IsNotExist(err) {
-			errors = append(errors, fmt.Errorf("画像 %s の削除エラー: %w", image.Filename, err))
-			continue
-		}
-
-		// Delete thumbnail
-		if image.ThumbnailPath != "" {
-			thumbPath := filepath.Join("../uploads", image.ThumbnailPath)
-			if err := os.Remove(thumbPath); err != nil && !os.IsNotExist(err) {
-				errors = append(errors, fmt.Errorf("サムネイル %s の削除エラー: %w", image.Filename, err))
-			}
-		}
-
-		// Delete from database
-		if err := models.DeleteImage(db, image.ID); err != nil {
+		// Delete from database, and the files once no other image uses them
+		fileErrors, err := deleteImageRecord(db, store, &image)
+		if err != nil {
 			errors = append(errors, fmt.Errorf("画像 %s のDB削除エラー: %w", image.Filename, err))
 			continue
 		}
+		for _, err := range fileErrors {
+			errors = append(errors, fmt.Errorf("画像 %s: %w", image.Filename, err))
+		}
 
 		deletedCount++
 	}
@@ -93,36 +72,80 @@ func CleanupOrphanedImages(db *gorm.DB, olderThan time.Duration) error {
 	return nil
 }
 
-// RemoveEmptyDirectories removes empty upload directories
-func RemoveEmptyDirectories(basePath string) error {
-	return filepath.Walk(basePath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+// CleanupUnreferencedObjects removes stored images and files older than
+// olderThan that no record refers to, such as files left behind by a
+// failed upload. It returns the number of objects removed.
+func CleanupUnreferencedObjects(db *gorm.DB, store storage.Storage, olderThan time.Duration) (int, error) {
+	referenced, err := referencedKeys(db)
+	if err != nil {
+		return 0, fmt.Errorf("参照中のファイルの取得に失敗しました: %w", err)
+	}
 
-		// Skip if not a directory
-		if !info.IsDir() {
-			return nil
-		}
+	ctx := context.Background()
+	cutoffTime := time.Now().Add(-olderThan)
+	deletedCount := 0
+	errors := []error{}
 
-		// Skip base directory
-		if path == basePath {
+	for _, prefix := range []string{"images/", "files/"} {
+		err := store.List(ctx, prefix, func(obj storage.ObjectInfo) error {
+			if referenced[obj.Key] || obj.ModTime.After(cutoffTime) {
+				return nil
+			}
+			// Variants are kept as long as their image is
+			if source, ok := variantSource("/" + obj.Key); ok && referenced[models.ImageKey(source)] {
+				return nil
+			}
+			if err := store.Delete(ctx, obj.Key); err != nil {
+				errors = append(errors, fmt.Errorf("%s の削除エラー: %w", obj.Key, err))
+				return nil
+			}
+			deletedCount++
 			return nil
-		}
-
-		// Check if directory is empty
-		entries, err := os.ReadDir(path)
+		})
 		if err != nil {
-			return err
+			errors = append(errors, fmt.Errorf("%s の一覧取得エラー: %w", prefix, err))
 		}
+	}
 
-		// Remove if empty
-		if len(entries) == 0 {
-			if err := os.Remove(path); err != nil {
-				return fmt.Errorf("空のディレクトリ %s の削除エラー: %w", path, err)
-			}
+	if len(errors) > 0 {
+		return deletedCount, fmt.Errorf("%d個のファイルを削除しましたが、エラーがありました: %v", deletedCount, errors)
+	}
+
+	return deletedCount, nil
+}
+
+// referencedKeys returns the storage keys used by image, file and blob
+// records
+func referencedKeys(db *gorm.DB) (map[string]bool, error) {
+	keys := make(map[string]bool)
+
+	var images []models.Image
+	if err := db.Select("path", "thumbnail_path").Find(&images).Error; err != nil {
+		return nil, err
+	}
+	for _, image := range images {
+		keys[models.ImageKey(image.Path)] = true
+		keys[models.ImageKey(image.ThumbnailPath)] = true
+	}
+
+	var files []models.File
+	if err := db.Select("path").Find(&files).Error; err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		keys[models.FileKey(file.Path)] = true
+	}
+
+	var blobs []models.Blob
+	if err := db.Find(&blobs).Error; err != nil {
+		return nil, err
+	}
+	for _, blob := range blobs {
+		keys[blob.Key()] = true
+		if blob.ThumbnailPath != "" {
+			keys[models.ImageKey(blob.ThumbnailPath)] = true
 		}
+	}
 
-		return nil
-	})
-}
\ No newline at end of file
+	return keys, nil
+}
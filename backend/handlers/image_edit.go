@@ -0,0 +1,424 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"simultaneous-memo-app/backend/models"
+	"simultaneous-memo-app/backend/storage"
+
+	"github.com/disintegration/imaging"
+	"github.com/labstack/echo/v4"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// errImageChanged is returned when another request edited or deleted
+	// an image while an edit was being applied
+	errImageChanged = errors.New("image changed")
+	// errInvalidCrop is returned for a crop outside the original image
+	errInvalidCrop = errors.New("invalid crop")
+)
+
+// UpdateImageByID applies a crop, rotation, flip and focal point to an
+// image. The transform always applies to the original upload, so a new
+// one replaces the previous edit instead of adding to it.
+func (h *Handler) UpdateImageByID(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "無効な画像IDです",
+		})
+	}
+
+	var transform models.ImageTransform
+	if err := c.Bind(&transform); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "無効なリクエストです",
+		})
+	}
+	if err := normalizeTransform(&transform); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return h.editImageByID(c, uint(id), &transform)
+}
+
+// RevertImageByID restores the original upload of an edited image
+func (h *Handler) RevertImageByID(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "無効な画像IDです",
+		})
+	}
+
+	return h.editImageByID(c, uint(id), nil)
+}
+
+// editImageByID applies a transform to an image, or reverts it when the
+// transform is nil, and notifies the image's page
+func (h *Handler) editImageByID(c echo.Context, id uint, transform *models.ImageTransform) error {
+	image, err := models.GetImageByID(h.db, id)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "画像が見つかりません",
+		})
+	}
+	// SVGs are served as vectors, which cannot be cropped or rotated here
+	if transform.ChangesPixels() && image.ContentType == "image/svg+xml" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "SVG画像は切り抜き・回転・反転できません",
+		})
+	}
+
+	err = editImage(h.db, h.store, image, transform)
+	switch {
+	case errors.Is(err, errInvalidCrop):
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "切り抜き範囲が画像の外にあります",
+		})
+	case errors.Is(err, errImageChanged):
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": "画像が他の操作で変更されました。再読み込みしてください",
+		})
+	case err != nil:
+		fmt.Printf("画像編集エラー: %v\n", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "画像の編集に失敗しました",
+		})
+	}
+
+	edited, err := models.GetImageByID(h.db, id)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "画像が見つかりません",
+		})
+	}
+	// Editors point their image nodes at the new path
+	if edited.PageID != nil {
+		h.notifyPage(*edited.PageID, "image-updated", map[string]interface{}{"image": edited})
+	}
+	return c.JSON(http.StatusOK, edited)
+}
+
+// normalizeTransform checks a transform and brings the rotation into
+// [0, 360). The crop is checked against the image when it is applied.
+func normalizeTransform(t *models.ImageTransform) error {
+	t.Rotate = (t.Rotate%360 + 360) % 360
+	if t.Rotate%90 != 0 {
+		return errors.New("回転は90度単位で指定してください")
+	}
+	if t.Crop != nil && (t.Crop.X < 0 || t.Crop.Y < 0 || t.Crop.Width <= 0 || t.Crop.Height <= 0) {
+		return errors.New("切り抜き範囲が不正です")
+	}
+	if t.Focal != nil && (t.Focal.X < 0 || t.Focal.X > 1 || t.Focal.Y < 0 || t.Focal.Y > 1) {
+		return errors.New("焦点は0から1の範囲で指定してください")
+	}
+	return nil
+}
+
+// editImage saves an image pointed at its original transformed by t, or
+// at the original itself when t is nil or leaves the pixels alone. Stored
+// content is never modified: the edited file is stored as a blob of its
+// own, so other images sharing the original are unaffected, and the image
+// keeps a reference to the original until it is reverted or deleted.
+func editImage(db *gorm.DB, store storage.Storage, image *models.Image, t *models.ImageTransform) error {
+	var transformJSON datatypes.JSON
+	if t != nil {
+		transformJSON, _ = json.Marshal(t)
+	}
+
+	// Only the focal point changes on an image that shows its original
+	if !t.ChangesPixels() && image.OriginalChecksum == "" {
+		return db.Transaction(func(tx *gorm.DB) error {
+			if err := lockImage(tx, image); err != nil {
+				return err
+			}
+			return models.UpdateImage(tx, image.ID, map[string]interface{}{"transform": transformJSON})
+		})
+	}
+
+	ctx := context.Background()
+	originalPath := image.Path
+	if image.OriginalChecksum != "" {
+		originalPath = image.OriginalPath
+	}
+	ext := strings.ToLower(filepath.Ext(originalPath))
+	original, err := downloadImage(ctx, store, models.ImageKey(originalPath), ext)
+	if err != nil {
+		return fmt.Errorf("元画像の取得に失敗しました: %w", err)
+	}
+	defer os.Remove(original)
+
+	// acquired holds the blob references taken here, which are dropped
+	// again if the record cannot be updated
+	var acquired []string
+	release := func() {
+		for _, digest := range acquired {
+			releaseBlob(db, store, models.BlobKindImage, digest)
+		}
+	}
+
+	// Images stored before deduplication own their files. Their original
+	// is moved to the blob store first so it can be kept like any other.
+	originalChecksum := image.OriginalChecksum
+	if originalChecksum == "" {
+		originalChecksum = image.Checksum
+	}
+	legacy := originalChecksum == ""
+	if legacy {
+		blob, _, err := storeImageBlob(db, store, original, ext, image.ContentType)
+		if err != nil {
+			return fmt.Errorf("元画像の保存に失敗しました: %w", err)
+		}
+		originalChecksum = blob.Digest
+		acquired = append(acquired, blob.Digest)
+	}
+	originalBlob, err := models.GetBlob(db, models.BlobKindImage, originalChecksum)
+	if err != nil {
+		release()
+		return fmt.Errorf("元画像のブロブが見つかりません: %w", err)
+	}
+
+	content, contentBlob := original, originalBlob
+	if t.ChangesPixels() {
+		derived, err := renderTransform(original, ext, image.ContentType, t)
+		if err != nil {
+			release()
+			return err
+		}
+		defer os.Remove(derived)
+
+		blob, _, err := storeImageBlob(db, store, derived, ext, image.ContentType)
+		if err != nil {
+			release()
+			return fmt.Errorf("編集した画像の保存に失敗しました: %w", err)
+		}
+		if blob.Digest == originalBlob.Digest {
+			// The transform reproduced the original, which is held already
+			releaseBlob(db, store, models.BlobKindImage, blob.Digest)
+		} else {
+			acquired = append(acquired, blob.Digest)
+			content, contentBlob = derived, blob
+		}
+	}
+
+	edited := *image
+	setImageContent(ctx, store, &edited, contentBlob, content)
+	edited.OriginalChecksum, edited.OriginalPath = "", ""
+	if contentBlob.Digest != originalBlob.Digest {
+		edited.OriginalChecksum, edited.OriginalPath = originalBlob.Digest, originalBlob.Path
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lockImage(tx, image); err != nil {
+			return err
+		}
+		return models.UpdateImage(tx, image.ID, map[string]interface{}{
+			"filename":          edited.Filename,
+			"path":              edited.Path,
+			"thumbnail_path":    edited.ThumbnailPath,
+			"size":              edited.Size,
+			"width":             edited.Width,
+			"height":            edited.Height,
+			"checksum":          edited.Checksum,
+			"blur_hash":         edited.BlurHash,
+			"preview":           edited.Preview,
+			"dominant_color":    edited.DominantColor,
+			"variants":          edited.Variants,
+			"srcset":            edited.Srcset,
+			"transform":         transformJSON,
+			"original_checksum": edited.OriginalChecksum,
+			"original_path":     edited.OriginalPath,
+		})
+	})
+	if err != nil {
+		release()
+		return err
+	}
+
+	// The reference to the original is kept either way; the previous
+	// edit is no longer used by this image
+	if image.OriginalChecksum != "" {
+		releaseBlob(db, store, models.BlobKindImage, image.Checksum)
+	}
+	if legacy {
+		for _, err := range removeImageFiles(store, image.Path, image.ThumbnailPath) {
+			fmt.Printf("画像ファイル削除エラー: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// lockImage locks an image row for the rest of a transaction, failing with
+// errImageChanged if its content no longer matches image
+func lockImage(tx *gorm.DB, image *models.Image) error {
+	var current models.Image
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, image.ID).Error
+	if err == gorm.ErrRecordNotFound {
+		return errImageChanged
+	}
+	if err != nil {
+		return err
+	}
+	if current.Checksum != image.Checksum || current.OriginalChecksum != image.OriginalChecksum {
+		return errImageChanged
+	}
+	return nil
+}
+
+// setImageContent points an image record at a blob whose content is at the
+// local path, recomputing the dimensions, placeholders and variants
+func setImageContent(ctx context.Context, store storage.Storage, image *models.Image, blob *models.Blob, path string) {
+	image.Filename = filepath.Base(blob.Path)
+	image.Path = blob.Path
+	image.ThumbnailPath = blob.ThumbnailPath
+	image.Size = blob.Size
+	image.Checksum = blob.Digest
+
+	width, height, err := GetImageDimensions(path)
+	if err != nil {
+		width, height = 0, 0
+	}
+	image.Width, image.Height = width, height
+
+	if err := applyPlaceholders(image, path); err != nil {
+		fmt.Printf("プレースホルダー作成エラー: %v\n", err)
+	}
+	// Variants of content stored earlier are reused
+	variants, err := putVariantsIfMissing(ctx, store, blob.Path, path, image.ContentType)
+	if err != nil {
+		fmt.Printf("バリアント作成エラー: %v\n", err)
+	}
+	image.Variants, _ = json.Marshal(variants)
+	image.Srcset = variantSrcset(image, variants)
+}
+
+// renderTransform writes the image at path transformed by t to a new
+// temporary file and returns its path
+func renderTransform(path, ext, contentType string, t *models.ImageTransform) (string, error) {
+	// Animated GIFs are edited frame by frame
+	if contentType == "image/gif" {
+		if g, ok := readAnimatedGIF(path); ok {
+			edited, err := transformGIF(g, func(img image.Image) (image.Image, error) {
+				return transformImage(img, t)
+			})
+			if err != nil {
+				return "", err
+			}
+			return saveTransformed(ext, func(w io.Writer) error {
+				return gif.EncodeAll(w, edited)
+			})
+		}
+	}
+
+	src, err := imaging.Open(path, imaging.AutoOrientation(true))
+	if err != nil {
+		return "", fmt.Errorf("画像を開けませんでした: %w", err)
+	}
+	img, err := transformImage(src, t)
+	if err != nil {
+		return "", err
+	}
+
+	// Rotating may turn an image past the limits uploads are fitted to
+	config := DefaultImageConfig()
+	if contentType == "image/jpeg" || contentType == "image/png" {
+		bounds := img.Bounds()
+		if bounds.Dx() > config.MaxWidth || bounds.Dy() > config.MaxHeight {
+			img = imaging.Fit(img, config.MaxWidth, config.MaxHeight, imaging.Lanczos)
+		}
+	}
+
+	return saveTransformed(ext, func(w io.Writer) error {
+		return encodeImage(w, img, contentType, config.Quality)
+	})
+}
+
+// saveTransformed writes an edited image to a new temporary file with the
+// given extension and returns its path
+func saveTransformed(ext string, encode func(io.Writer) error) (string, error) {
+	tmp, err := os.CreateTemp("", "edit-*"+ext)
+	if err != nil {
+		return "", err
+	}
+	if err := encode(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("画像の保存に失敗しました: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// transformImage crops, rotates and flips an image as described by t
+func transformImage(src image.Image, t *models.ImageTransform) (image.Image, error) {
+	img := src
+	if t.Crop != nil {
+		rect := image.Rect(t.Crop.X, t.Crop.Y, t.Crop.X+t.Crop.Width, t.Crop.Y+t.Crop.Height)
+		if !rect.In(image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy())) {
+			return nil, errInvalidCrop
+		}
+		img = imaging.Crop(img, rect)
+	}
+
+	// The imaging rotations are counter-clockwise
+	switch t.Rotate {
+	case 90:
+		img = imaging.Rotate270(img)
+	case 180:
+		img = imaging.Rotate180(img)
+	case 270:
+		img = imaging.Rotate90(img)
+	}
+
+	if t.FlipH {
+		img = imaging.FlipH(img)
+	}
+	if t.FlipV {
+		img = imaging.FlipV(img)
+	}
+	return img, nil
+}
+
+// downloadImage copies a stored image to a temporary file with the given
+// extension and returns its path
+func downloadImage(ctx context.Context, store storage.Storage, key, ext string) (string, error) {
+	obj, err := store.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer obj.Close()
+
+	tmp, err := os.CreateTemp("", "image-*"+ext)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(tmp, obj)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
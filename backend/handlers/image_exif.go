@@ -0,0 +1,482 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// errBadMetadata is returned for EXIF data or image containers that cannot
+// be parsed
+var errBadMetadata = errors.New("画像のメタデータを解析できませんでした")
+
+// Metadata blocks found in image files
+const (
+	metadataExif = "EXIF"
+	metadataXMP  = "XMP"
+	metadataIPTC = "IPTC"
+)
+
+// EXIF tags handled when stripping metadata
+const (
+	exifTagOrientation = 0x0112
+	exifTagExifIFD     = 0x8769
+	exifTagGPSIFD      = 0x8825
+)
+
+// exifDeviceTags are the tags identifying the device an image was taken
+// with, in IFD0 and the Exif IFD
+var exifDeviceTags = map[uint16]string{
+	0x010F: "Make",
+	0x0110: "Model",
+	0x0131: "Software",
+	0x013C: "HostComputer",
+	0x927C: "MakerNote",
+	0xA430: "CameraOwnerName",
+	0xA431: "BodySerialNumber",
+	0xA433: "LensMake",
+	0xA434: "LensModel",
+	0xA435: "LensSerialNumber",
+}
+
+// exifTypeSizes are the sizes of the TIFF field types in bytes
+var exifTypeSizes = map[uint16]uint64{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// tiffEditor edits EXIF data, which is stored in TIFF format, in place
+type tiffEditor struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// filterExif returns a copy of raw EXIF data without the tags the policy
+// strips, along with the names of the removed tags. Removed values are
+// zeroed rather than just unlinked so they cannot be recovered. When
+// resetOrientation is set the orientation is set to normal, for images
+// whose pixels have already been rotated.
+func filterExif(data []byte, policy ImageMetadataPolicy, resetOrientation bool) ([]byte, []string, error) {
+	t := &tiffEditor{data: append([]byte(nil), data...)}
+	if len(t.data) < 8 {
+		return nil, nil, errBadMetadata
+	}
+	switch string(t.data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, nil, errBadMetadata
+	}
+	ifd0 := t.order.Uint32(t.data[4:8])
+
+	exifIFD, hasExifIFD, err := t.lookup(ifd0, exifTagExifIFD)
+	if err != nil {
+		return nil, nil, err
+	}
+	gpsIFD, hasGPS, err := t.lookup(ifd0, exifTagGPSIFD)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	removed := []string{}
+	if policy.StripLocation && hasGPS {
+		if err := t.zeroIFD(gpsIFD); err != nil {
+			return nil, nil, err
+		}
+	}
+	names, err := t.filterIFD(ifd0, func(tag uint16) string {
+		if policy.StripLocation && tag == exifTagGPSIFD {
+			return "GPS"
+		}
+		if policy.StripDevice {
+			return exifDeviceTags[tag]
+		}
+		return ""
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	removed = append(removed, names...)
+
+	if policy.StripDevice && hasExifIFD {
+		names, err := t.filterIFD(exifIFD, func(tag uint16) string {
+			return exifDeviceTags[tag]
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		removed = append(removed, names...)
+	}
+
+	if resetOrientation {
+		if err := t.setOrientation(ifd0, 1); err != nil {
+			return nil, nil, err
+		}
+	}
+	return t.data, removed, nil
+}
+
+// entries returns the offset of the first entry of an IFD and the number
+// of entries
+func (t *tiffEditor) entries(offset uint32) (int, int, error) {
+	start := int(offset)
+	if offset == 0 || start+2 > len(t.data) {
+		return 0, 0, errBadMetadata
+	}
+	n := int(t.order.Uint16(t.data[start:]))
+	// The entries are followed by the offset of the next IFD
+	if start+2+12*n+4 > len(t.data) {
+		return 0, 0, errBadMetadata
+	}
+	return start + 2, n, nil
+}
+
+// lookup returns the value of a LONG tag such as an IFD pointer
+func (t *tiffEditor) lookup(offset uint32, tag uint16) (uint32, bool, error) {
+	first, n, err := t.entries(offset)
+	if err != nil {
+		return 0, false, err
+	}
+	for i := 0; i < n; i++ {
+		entry := t.data[first+12*i:]
+		if t.order.Uint16(entry) == tag {
+			return t.order.Uint32(entry[8:12]), true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// zeroValue zeroes the value of an entry stored outside of it
+func (t *tiffEditor) zeroValue(entry []byte) {
+	size := exifTypeSizes[t.order.Uint16(entry[2:])] * uint64(t.order.Uint32(entry[4:]))
+	if size <= 4 {
+		return
+	}
+	start := uint64(t.order.Uint32(entry[8:]))
+	if start+size > uint64(len(t.data)) {
+		return
+	}
+	clear(t.data[start : start+size])
+}
+
+// filterIFD removes the entries of an IFD that remove names, zeroing their
+// values, and returns the names of the removed entries
+func (t *tiffEditor) filterIFD(offset uint32, remove func(tag uint16) string) ([]string, error) {
+	first, n, err := t.entries(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	kept := make([]byte, 0, 12*n)
+	for i := 0; i < n; i++ {
+		entry := t.data[first+12*i : first+12*i+12]
+		if name := remove(t.order.Uint16(entry)); name != "" {
+			t.zeroValue(entry)
+			names = append(names, name)
+			continue
+		}
+		kept = append(kept, entry...)
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	// Move the remaining entries and the next IFD offset up
+	end := first + 12*n + 4
+	next := t.order.Uint32(t.data[end-4:])
+	t.order.PutUint16(t.data[first-2:], uint16(len(kept)/12))
+	copy(t.data[first:], kept)
+	t.order.PutUint32(t.data[first+len(kept):], next)
+	clear(t.data[first+len(kept)+4 : end])
+	return names, nil
+}
+
+// zeroIFD zeroes an IFD and the values of its entries
+func (t *tiffEditor) zeroIFD(offset uint32) error {
+	first, n, err := t.entries(offset)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		t.zeroValue(t.data[first+12*i : first+12*i+12])
+	}
+	clear(t.data[first-2 : first+12*n+4])
+	return nil
+}
+
+// setOrientation sets the orientation tag of an IFD if it is present
+func (t *tiffEditor) setOrientation(offset uint32, orientation uint16) error {
+	first, n, err := t.entries(offset)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		entry := t.data[first+12*i:]
+		if t.order.Uint16(entry) == exifTagOrientation && t.order.Uint16(entry[2:]) == 3 {
+			t.order.PutUint16(entry[8:], orientation)
+		}
+	}
+	return nil
+}
+
+// metadataBlock is a metadata segment or chunk of an image file
+type metadataBlock struct {
+	kind       string
+	start, end int
+	// exif is the raw EXIF data of EXIF blocks
+	exif []byte
+}
+
+// imageMetadataBlocks finds the metadata blocks of an image. It also
+// returns where an EXIF block is inserted if the image has none, or -1 if
+// the format does not allow adding one.
+func imageMetadataBlocks(data []byte, contentType string) ([]metadataBlock, int, error) {
+	switch contentType {
+	case "image/jpeg", "image/jpg":
+		return jpegMetadataBlocks(data)
+	case "image/png":
+		return pngMetadataBlocks(data)
+	case "image/webp":
+		return webpMetadataBlocks(data)
+	}
+	// GIF has no EXIF
+	return nil, -1, nil
+}
+
+// scanImageMetadata returns the raw EXIF data of an image, if it has any,
+// along with the kinds of all of its metadata blocks
+func scanImageMetadata(data []byte, contentType string) ([]byte, []string, error) {
+	blocks, _, err := imageMetadataBlocks(data, contentType)
+	if err != nil {
+		return nil, nil, err
+	}
+	var exif []byte
+	var kinds []string
+	for _, block := range blocks {
+		if block.kind == metadataExif && exif == nil {
+			exif = block.exif
+		}
+		kinds = append(kinds, block.kind)
+	}
+	return exif, kinds, nil
+}
+
+// rewriteImageMetadata replaces the EXIF data of an image with exif, or
+// removes it if exif is nil. XMP and IPTC blocks are removed as well when
+// dropOthers is set.
+func rewriteImageMetadata(data []byte, contentType string, exif []byte, dropOthers bool) ([]byte, error) {
+	blocks, insertAt, err := imageMetadataBlocks(data, contentType)
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range blocks {
+		if block.kind == metadataExif {
+			insertAt = block.start
+			break
+		}
+	}
+
+	var insert []byte
+	if exif != nil && insertAt >= 0 {
+		switch contentType {
+		case "image/png":
+			insert = pngChunk("eXIf", exif)
+		case "image/webp":
+			insert = webpChunk("EXIF", exif)
+		default:
+			if len(exif)+len(exifHeader)+2 > 0xFFFF {
+				return nil, errBadMetadata
+			}
+			insert = []byte{0xFF, 0xE1, 0, 0}
+			binary.BigEndian.PutUint16(insert[2:], uint16(len(exif)+len(exifHeader)+2))
+			insert = append(append(insert, exifHeader...), exif...)
+		}
+	}
+
+	out := make([]byte, 0, len(data)+len(insert))
+	pos := 0
+	inserted := false
+	for _, block := range blocks {
+		if block.kind != metadataExif && !dropOthers {
+			continue
+		}
+		if !inserted && insertAt <= block.start {
+			out = append(out, data[pos:insertAt]...)
+			out = append(out, insert...)
+			pos, inserted = insertAt, true
+		}
+		out = append(out, data[pos:block.start]...)
+		pos = block.end
+	}
+	if !inserted && insertAt >= 0 {
+		out = append(out, data[pos:insertAt]...)
+		out = append(out, insert...)
+		pos = insertAt
+	}
+	out = append(out, data[pos:]...)
+
+	if contentType == "image/webp" {
+		fixWebPHeader(out, insert != nil, dropOthers)
+	}
+	return out, nil
+}
+
+// jpegMetadataBlocks finds the APP1 EXIF and XMP segments and the APP13
+// IPTC segments of a JPEG image. Metadata precedes the image data, so the
+// search ends at the first scan.
+func jpegMetadataBlocks(data []byte) ([]metadataBlock, int, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, 0, errBadMetadata
+	}
+
+	var blocks []metadataBlock
+	for i := 2; ; {
+		if i+2 > len(data) || data[i] != 0xFF {
+			return nil, 0, errBadMetadata
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			// Fill byte
+			i++
+			continue
+		case marker == 0xDA || marker == 0xD9:
+			return blocks, 2, nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			i += 2
+			continue
+		}
+
+		if i+4 > len(data) {
+			return nil, 0, errBadMetadata
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end < i+4 || end > len(data) {
+			return nil, 0, errBadMetadata
+		}
+		payload := data[i+4 : end]
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(payload, exifHeader):
+			blocks = append(blocks, metadataBlock{kind: metadataExif, start: i, end: end, exif: payload[len(exifHeader):]})
+		case marker == 0xE1 && bytes.HasPrefix(payload, []byte("http://ns.adobe.com/")):
+			blocks = append(blocks, metadataBlock{kind: metadataXMP, start: i, end: end})
+		case marker == 0xED && bytes.HasPrefix(payload, []byte("Photoshop 3.0\x00")):
+			blocks = append(blocks, metadataBlock{kind: metadataIPTC, start: i, end: end})
+		}
+		i = end
+	}
+}
+
+// pngMetadataBlocks finds the eXIf chunk and the XMP text chunks of a PNG
+// image. EXIF is inserted before the image data.
+func pngMetadataBlocks(data []byte) ([]metadataBlock, int, error) {
+	if len(data) < 8 || string(data[:8]) != "\x89PNG\r\n\x1a\n" {
+		return nil, 0, errBadMetadata
+	}
+
+	var blocks []metadataBlock
+	insertAt := -1
+	for i := 8; i < len(data); {
+		if i+12 > len(data) {
+			return nil, 0, errBadMetadata
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) || end < i {
+			return nil, 0, errBadMetadata
+		}
+		chunkType := string(data[i+4 : i+8])
+		payload := data[i+8 : i+8+length]
+		switch chunkType {
+		case "eXIf":
+			blocks = append(blocks, metadataBlock{kind: metadataExif, start: i, end: end, exif: bytes.TrimPrefix(payload, exifHeader)})
+		case "iTXt", "tEXt", "zTXt":
+			if bytes.HasPrefix(payload, []byte("XML:com.adobe.xmp\x00")) {
+				blocks = append(blocks, metadataBlock{kind: metadataXMP, start: i, end: end})
+			}
+		case "IDAT":
+			if insertAt < 0 {
+				insertAt = i
+			}
+		}
+		i = end
+	}
+	return blocks, insertAt, nil
+}
+
+// pngChunk encodes a PNG chunk
+func pngChunk(chunkType string, payload []byte) []byte {
+	chunk := make([]byte, 8, 12+len(payload))
+	binary.BigEndian.PutUint32(chunk, uint32(len(payload)))
+	copy(chunk[4:], chunkType)
+	chunk = append(chunk, payload...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// webpMetadataBlocks finds the EXIF and XMP chunks of a WebP image. Only
+// extended (VP8X) files can hold metadata; EXIF is added after the image
+// data.
+func webpMetadataBlocks(data []byte) ([]metadataBlock, int, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, 0, errBadMetadata
+	}
+
+	var blocks []metadataBlock
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, 0, errBadMetadata
+		}
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2
+		if size < 0 || end > len(data) || end < i {
+			return nil, 0, errBadMetadata
+		}
+		switch string(data[i : i+4]) {
+		case "EXIF":
+			blocks = append(blocks, metadataBlock{kind: metadataExif, start: i, end: end, exif: bytes.TrimPrefix(data[i+8:i+8+size], exifHeader)})
+		case "XMP ":
+			blocks = append(blocks, metadataBlock{kind: metadataXMP, start: i, end: end})
+		}
+		i = end
+	}
+
+	if string(data[12:16]) != "VP8X" {
+		return blocks, -1, nil
+	}
+	return blocks, len(data), nil
+}
+
+// webpChunk encodes a WebP chunk, padded to an even size
+func webpChunk(fourCC string, payload []byte) []byte {
+	chunk := make([]byte, 8, 9+len(payload))
+	copy(chunk, fourCC)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(payload)))
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// fixWebPHeader updates the file size and the VP8X metadata flags of a
+// WebP image after its metadata chunks changed
+func fixWebPHeader(data []byte, hasExif, droppedXMP bool) {
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+	if len(data) < 21 || string(data[12:16]) != "VP8X" {
+		return
+	}
+	const exifFlag, xmpFlag = 0x08, 0x04
+	if hasExif {
+		data[20] |= exifFlag
+	} else {
+		data[20] &^= exifFlag
+	}
+	if droppedXMP {
+		data[20] &^= xmpFlag
+	}
+}
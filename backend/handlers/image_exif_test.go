@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"slices"
+	"testing"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// tiffEntry is an IFD entry of a test EXIF block. Entries with a pointer
+// point at the IFD with that index instead of holding value.
+type tiffEntry struct {
+	tag     uint16
+	typ     uint16
+	count   uint32
+	value   []byte
+	pointer int
+}
+
+// tiffOrder is a byte order that can also append
+type tiffOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// buildTIFF lays out IFDs one after another, each followed by the values
+// that do not fit in its entries. The first IFD is IFD0.
+func buildTIFF(order tiffOrder, ifds [][]tiffEntry) []byte {
+	offsets := make([]uint32, len(ifds))
+	next := uint32(8)
+	for i, entries := range ifds {
+		offsets[i] = next
+		next += uint32(2 + 12*len(entries) + 4)
+		for _, e := range entries {
+			if len(e.value) > 4 {
+				next += uint32(len(e.value))
+			}
+		}
+	}
+
+	data := make([]byte, 8, next)
+	if order == binary.LittleEndian {
+		copy(data, "II")
+	} else {
+		copy(data, "MM")
+	}
+	order.PutUint16(data[2:], 42)
+	order.PutUint32(data[4:], offsets[0])
+
+	for i, entries := range ifds {
+		values := offsets[i] + uint32(2+12*len(entries)+4)
+		var extra []byte
+		data = order.AppendUint16(data, uint16(len(entries)))
+		for _, e := range entries {
+			data = order.AppendUint16(data, e.tag)
+			data = order.AppendUint16(data, e.typ)
+			data = order.AppendUint32(data, e.count)
+			switch {
+			case e.pointer > 0:
+				data = order.AppendUint32(data, offsets[e.pointer])
+			case len(e.value) > 4:
+				data = order.AppendUint32(data, values+uint32(len(extra)))
+				extra = append(extra, e.value...)
+			default:
+				data = append(data, e.value...)
+				data = append(data, make([]byte, 4-len(e.value))...)
+			}
+		}
+		data = order.AppendUint32(data, 0)
+		data = append(data, extra...)
+	}
+	return data
+}
+
+// testExif returns EXIF data with a camera, an orientation, a serial
+// number in the Exif IFD and GPS coordinates
+func testExif(order tiffOrder) []byte {
+	short := func(v uint16) []byte { return order.AppendUint16(nil, v) }
+	rationals := func(values ...uint32) []byte {
+		var b []byte
+		for _, v := range values {
+			b = order.AppendUint32(b, v)
+			b = order.AppendUint32(b, 1)
+		}
+		return b
+	}
+	return buildTIFF(order, [][]tiffEntry{
+		{
+			{tag: 0x010F, typ: 2, count: 10, value: []byte("SecretCam\x00")},
+			{tag: 0x0110, typ: 2, count: 3, value: []byte("X1\x00")},
+			{tag: exifTagOrientation, typ: 3, count: 1, value: short(6)},
+			{tag: exifTagExifIFD, typ: 4, count: 1, pointer: 1},
+			{tag: exifTagGPSIFD, typ: 4, count: 1, pointer: 2},
+		},
+		{
+			{tag: 0x829A, typ: 5, count: 1, value: rationals(125)},
+			{tag: 0xA431, typ: 2, count: 8, value: []byte("SN12345\x00")},
+		},
+		{
+			{tag: 0x0001, typ: 2, count: 2, value: []byte("N\x00")},
+			{tag: 0x0002, typ: 5, count: 3, value: rationals(35, 39, 29)},
+			{tag: 0x0003, typ: 2, count: 2, value: []byte("E\x00")},
+			{tag: 0x0004, typ: 5, count: 3, value: rationals(139, 41, 31)},
+		},
+	})
+}
+
+func TestFilterExif(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  ImageMetadataPolicy
+		removed []string
+		gone    []string
+		kept    []exif.FieldName
+	}{
+		{
+			name:    "location and device",
+			policy:  ImageMetadataPolicy{StripLocation: true, StripDevice: true},
+			removed: []string{"Make", "Model", "GPS", "BodySerialNumber"},
+			gone:    []string{"SecretCam", "X1", "SN12345"},
+			kept:    []exif.FieldName{exif.ExposureTime, exif.Orientation},
+		},
+		{
+			name:    "location only",
+			policy:  ImageMetadataPolicy{StripLocation: true},
+			removed: []string{"GPS"},
+			kept:    []exif.FieldName{exif.Make, exif.Model, exif.ExposureTime},
+		},
+		{
+			name:    "device only",
+			policy:  ImageMetadataPolicy{StripDevice: true},
+			removed: []string{"Make", "Model", "BodySerialNumber"},
+			gone:    []string{"SecretCam", "SN12345"},
+			kept:    []exif.FieldName{exif.GPSLatitude, exif.GPSLongitude},
+		},
+		{
+			name:   "nothing",
+			policy: ImageMetadataPolicy{},
+			kept:   []exif.FieldName{exif.Make, exif.ExposureTime, exif.GPSLatitude},
+		},
+	}
+
+	for _, order := range []tiffOrder{binary.LittleEndian, binary.BigEndian} {
+		for _, tt := range tests {
+			t.Run(order.String()+"/"+tt.name, func(t *testing.T) {
+				data := testExif(order)
+				out, removed, err := filterExif(data, tt.policy, false)
+				if err != nil {
+					t.Fatalf("filterExif() error = %v", err)
+				}
+				if len(out) != len(data) {
+					t.Errorf("filterExif() changed the size from %d to %d", len(data), len(out))
+				}
+				if !slices.Equal(removed, tt.removed) && len(removed)+len(tt.removed) > 0 {
+					t.Errorf("filterExif() removed %v, want %v", removed, tt.removed)
+				}
+				for _, secret := range tt.gone {
+					if bytes.Contains(out, []byte(secret)) {
+						t.Errorf("output still contains %q", secret)
+					}
+				}
+
+				x, err := exif.Decode(bytes.NewReader(out))
+				if x == nil {
+					t.Fatalf("exif.Decode() error = %v", err)
+				}
+				for _, name := range tt.kept {
+					if _, err := x.Get(name); err != nil {
+						t.Errorf("%s was removed: %v", name, err)
+					}
+				}
+				if tt.policy.StripLocation {
+					if _, _, err := x.LatLong(); err == nil {
+						t.Error("GPS coordinates are still readable")
+					}
+				}
+				if tt.policy.StripDevice {
+					if _, err := x.Get(exif.Make); err == nil {
+						t.Error("Make is still readable")
+					}
+				}
+			})
+		}
+	}
+
+	// The input is left as it was
+	data := testExif(binary.LittleEndian)
+	original := append([]byte(nil), data...)
+	filterExif(data, ImageMetadataPolicy{StripLocation: true, StripDevice: true}, true)
+	if !bytes.Equal(data, original) {
+		t.Error("filterExif() modified its input")
+	}
+}
+
+func TestFilterExifResetsOrientation(t *testing.T) {
+	out, _, err := filterExif(testExif(binary.BigEndian), ImageMetadataPolicy{}, true)
+	if err != nil {
+		t.Fatalf("filterExif() error = %v", err)
+	}
+	x, err := exif.Decode(bytes.NewReader(out))
+	if x == nil {
+		t.Fatalf("exif.Decode() error = %v", err)
+	}
+	tag, err := x.Get(exif.Orientation)
+	if err != nil {
+		t.Fatalf("orientation missing: %v", err)
+	}
+	if orientation, _ := tag.Int(0); orientation != 1 {
+		t.Errorf("orientation = %d, want 1", orientation)
+	}
+}
+
+func TestFilterExifInvalid(t *testing.T) {
+	valid := testExif(binary.LittleEndian)
+	badOffset := append([]byte(nil), valid...)
+	binary.LittleEndian.PutUint32(badOffset[4:], uint32(len(badOffset)))
+
+	inputs := map[string][]byte{
+		"empty":          nil,
+		"short":          []byte("II*\x00"),
+		"bad byte order": append([]byte("XX"), valid[2:]...),
+		"IFD0 past end":  badOffset,
+		"truncated IFD":  valid[:20],
+	}
+	for name, data := range inputs {
+		if _, _, err := filterExif(data, ImageMetadataPolicy{StripLocation: true}, false); err == nil {
+			t.Errorf("%s: filterExif() error = nil", name)
+		}
+	}
+}
+
+func TestRewriteImageMetadataJPEG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	plain := buf.Bytes()
+
+	// Add EXIF and an XMP segment after SOI
+	withExif, err := rewriteImageMetadata(plain, "image/jpeg", testExif(binary.BigEndian), false)
+	if err != nil {
+		t.Fatalf("rewriteImageMetadata() error = %v", err)
+	}
+	xmp := append([]byte("http://ns.adobe.com/xap/1.0/\x00"), "<x:xmpmeta/>"...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(xmp)+2))
+	withXMP := slices.Concat(withExif[:2], segment, xmp, withExif[2:])
+
+	exifData, kinds, err := scanImageMetadata(withXMP, "image/jpeg")
+	if err != nil {
+		t.Fatalf("scanImageMetadata() error = %v", err)
+	}
+	if !bytes.Equal(exifData, testExif(binary.BigEndian)) {
+		t.Error("scanImageMetadata() did not return the EXIF data written")
+	}
+	if !slices.Contains(kinds, metadataExif) || !slices.Contains(kinds, metadataXMP) {
+		t.Errorf("scanImageMetadata() kinds = %v", kinds)
+	}
+
+	// Replacing the EXIF data keeps XMP unless others are dropped
+	filtered, _, err := filterExif(exifData, ImageMetadataPolicy{StripLocation: true, StripDevice: true}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dropOthers := range []bool{false, true} {
+		out, err := rewriteImageMetadata(withXMP, "image/jpeg", filtered, dropOthers)
+		if err != nil {
+			t.Fatalf("rewriteImageMetadata() error = %v", err)
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+			t.Fatalf("rewritten JPEG does not decode: %v", err)
+		}
+		gotExif, kinds, err := scanImageMetadata(out, "image/jpeg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(gotExif, filtered) {
+			t.Error("rewritten JPEG does not hold the filtered EXIF data")
+		}
+		if slices.Contains(kinds, metadataXMP) == dropOthers {
+			t.Errorf("dropOthers = %v: kinds = %v", dropOthers, kinds)
+		}
+	}
+
+	// Removing the EXIF data leaves the plain image
+	out, err := rewriteImageMetadata(withExif, "image/jpeg", nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, plain) {
+		t.Error("removing the EXIF data did not restore the original file")
+	}
+}
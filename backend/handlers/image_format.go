@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"image"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/disintegration/imaging"
+
+	// Register the WebP decoder so WebP uploads can be resized
+	_ "golang.org/x/image/webp"
+)
+
+// imageFormats maps the format query parameter to a content type
+var imageFormats = map[string]string{
+	"jpeg": "image/jpeg",
+	"jpg":  "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"webp": "image/webp",
+}
+
+// encodeImage encodes an image as contentType. WebP is always lossless, so
+// quality only applies to JPEG. Unknown types are encoded as JPEG.
+func encodeImage(w io.Writer, img image.Image, contentType string, quality int) error {
+	switch contentType {
+	case "image/png":
+		return imaging.Encode(w, img, imaging.PNG)
+	case "image/gif":
+		return imaging.Encode(w, img, imaging.GIF)
+	case "image/webp":
+		return nativewebp.Encode(w, img, nil)
+	default:
+		return imaging.Encode(w, img, imaging.JPEG, imaging.JPEGQuality(quality))
+	}
+}
+
+// imageExtension returns the file extension for an encoded content type
+func imageExtension(contentType string) string {
+	switch contentType {
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	default:
+		return ".jpg"
+	}
+}
+
+// negotiateImageFormat picks the content type of a resized image when no
+// format was requested. Lossless sources (PNG and WebP) are sent as WebP to
+// clients that accept it, as are rasterized SVGs; JPEG photos stay JPEG
+// since lossless WebP would be larger, and GIFs keep their format.
+func negotiateImageFormat(accept, sourceType string) string {
+	switch sourceType {
+	case "image/png", "image/webp", "image/svg+xml":
+		if acceptsType(accept, "image/webp") {
+			return "image/webp"
+		}
+		return "image/png"
+	case "image/gif":
+		return "image/gif"
+	default:
+		return "image/jpeg"
+	}
+}
+
+// acceptsType reports whether an Accept header explicitly allows a type
+// with a non-zero quality
+func acceptsType(accept, contentType string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || mediaType != contentType {
+			continue
+		}
+		if q, ok := params["q"]; ok {
+			if value, err := strconv.ParseFloat(q, 64); err != nil || value <= 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
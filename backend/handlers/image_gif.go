@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"os"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// maxGIFFrames and maxGIFPixels bound the work of processing an
+	// animated GIF: its frame count and the pixels of all frames at the
+	// size of the whole image. Larger GIFs are processed as a still of
+	// their first frame.
+	maxGIFFrames = 300
+	maxGIFPixels = 50_000_000
+)
+
+// decodeAnimatedGIF decodes all frames of a GIF with more than one frame
+// that fits the budget. ok is false for other data, which callers process
+// as a still image.
+func decodeAnimatedGIF(data []byte) (*gif.GIF, bool) {
+	frames, pixels, err := gifFrameStats(data)
+	if err != nil || frames < 2 || frames > maxGIFFrames || pixels > maxGIFPixels {
+		return nil, false
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil || len(g.Image) < 2 {
+		return nil, false
+	}
+	return g, true
+}
+
+// readAnimatedGIF is decodeAnimatedGIF for a file
+func readAnimatedGIF(path string) (*gif.GIF, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	return decodeAnimatedGIF(data)
+}
+
+// gifFrameStats counts the frames of a GIF and the pixels they cover at
+// the size of the whole image, reading only the block structure so that
+// nothing is decompressed
+func gifFrameStats(data []byte) (int, int64, error) {
+	errFormat := errors.New("gif: invalid format")
+	if len(data) < 13 || (string(data[:6]) != "GIF87a" && string(data[:6]) != "GIF89a") {
+		return 0, 0, errFormat
+	}
+	area := int64(binary.LittleEndian.Uint16(data[6:8])) * int64(binary.LittleEndian.Uint16(data[8:10]))
+	pos := 13
+	if data[10]&0x80 != 0 {
+		pos += 3 << (data[10]&0x07 + 1)
+	}
+
+	// skipSubBlocks moves past a sequence of data sub-blocks
+	skipSubBlocks := func() error {
+		for {
+			if pos >= len(data) {
+				return errFormat
+			}
+			n := int(data[pos])
+			pos += 1 + n
+			if n == 0 {
+				return nil
+			}
+		}
+	}
+
+	frames := 0
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // extension
+			pos += 2
+			if err := skipSubBlocks(); err != nil {
+				return 0, 0, err
+			}
+		case 0x2C: // image descriptor
+			if pos+10 > len(data) {
+				return 0, 0, errFormat
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1)
+			}
+			pos++ // LZW minimum code size
+			if err := skipSubBlocks(); err != nil {
+				return 0, 0, err
+			}
+			frames++
+		case 0x3B: // trailer
+			return frames, int64(frames) * area, nil
+		default:
+			return 0, 0, errFormat
+		}
+	}
+	return frames, int64(frames) * area, nil
+}
+
+// transformGIF applies fn to every frame of an animation as it is shown,
+// composed with the frames before it, and returns the resulting animation
+// with the same delays and loop count. Every output frame covers the whole
+// image, so fn may change its size.
+func transformGIF(g *gif.GIF, fn func(image.Image) (image.Image, error)) (*gif.GIF, error) {
+	canvas := image.NewNRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	out := &gif.GIF{
+		Image:     make([]*image.Paletted, 0, len(g.Image)),
+		Delay:     g.Delay,
+		Disposal:  make([]byte, 0, len(g.Image)),
+		LoopCount: g.LoopCount,
+	}
+
+	for i, frame := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var previous *image.NRGBA
+		if disposal == gif.DisposalPrevious {
+			previous = imaging.Clone(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		img, err := fn(canvas)
+		if err != nil {
+			return nil, err
+		}
+		paletted := image.NewPaletted(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()), framePalette(frame.Palette))
+		draw.Draw(paletted, paletted.Bounds(), img, img.Bounds().Min, draw.Src)
+		out.Image = append(out.Image, paletted)
+		// Frames are complete, so each one replaces the last
+		out.Disposal = append(out.Disposal, gif.DisposalBackground)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return out, nil
+}
+
+// resizeGIF resizes every frame of an animation like resizeFrame
+func resizeGIF(g *gif.GIF, width, height int) *gif.GIF {
+	resized, _ := transformGIF(g, func(img image.Image) (image.Image, error) {
+		return resizeFrame(img, width, height), nil
+	})
+	return resized
+}
+
+// resizeFrame fits an image within width and height when both are given,
+// and otherwise resizes it to the one given keeping its aspect ratio
+func resizeFrame(img image.Image, width, height int) *image.NRGBA {
+	if width > 0 && height > 0 {
+		return imaging.Fit(img, width, height, imaging.Lanczos)
+	}
+	return imaging.Resize(img, width, height, imaging.Lanczos)
+}
+
+// framePalette returns the palette of a frame with a transparent color,
+// which resized edges and disposed areas need, added if there is room
+func framePalette(p color.Palette) color.Palette {
+	for _, c := range p {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			return p
+		}
+	}
+	if len(p) >= 256 {
+		return p
+	}
+	return append(append(color.Palette{}, p...), color.RGBA{})
+}
+
+// saveGIF writes an animation to path
+func saveGIF(g *gif.GIF, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := gif.EncodeAll(file, g); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"simultaneous-memo-app/backend/models"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// ImageMetadataPolicy selects the metadata removed from uploaded images
+type ImageMetadataPolicy struct {
+	// StripLocation removes GPS coordinates
+	StripLocation bool
+	// StripDevice removes the camera make and model, serial numbers and
+	// other data identifying the device
+	StripDevice bool
+}
+
+// imageMetadataPolicy applies to all uploads; both kinds of data are
+// stripped unless configured otherwise
+var imageMetadataPolicy = ImageMetadataPolicy{StripLocation: true, StripDevice: true}
+
+// SetImageMetadataPolicy sets the metadata removed from uploaded images
+func SetImageMetadataPolicy(policy ImageMetadataPolicy) {
+	imageMetadataPolicy = policy
+}
+
+// applyExif fills the EXIF fields of an image record from raw EXIF data
+func applyExif(image *models.Image, data []byte) {
+	x, err := exif.Decode(bytes.NewReader(data))
+	if x == nil {
+		fmt.Printf("EXIF読み取りエラー: %v\n", err)
+		return
+	}
+
+	image.CameraMake = exifString(x, exif.Make)
+	image.CameraModel = exifString(x, exif.Model)
+	if takenAt, err := x.DateTime(); err == nil {
+		image.TakenAt = &takenAt
+	}
+	if tag, err := x.Get(exif.Orientation); err == nil {
+		if orientation, err := tag.Int(0); err == nil {
+			image.Orientation = orientation
+		}
+	}
+	if lat, long, err := x.LatLong(); err == nil {
+		image.GPSLatitude = &lat
+		image.GPSLongitude = &long
+	}
+}
+
+// exifString returns a string tag without padding
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	value, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(value, "\x00"))
+}
+
+// stripImageMetadata removes the data the policy strips from the image
+// file at path and returns the names of what was removed. exif and kinds
+// describe the metadata of the upload as received; processed tells whether
+// the file was re-encoded since, which drops all metadata and applies the
+// orientation. The remaining EXIF data is written back in either case.
+// XMP and IPTC blocks cannot be filtered, so they are dropped whenever
+// anything is stripped.
+func stripImageMetadata(path string, exifData []byte, kinds []string, processed bool, policy ImageMetadataPolicy) ([]string, error) {
+	removed := []string{}
+	var kept []byte
+	if exifData != nil {
+		var names []string
+		var err error
+		kept, names, err = filterExif(exifData, policy, processed)
+		if err != nil {
+			// Data that cannot be parsed cannot be filtered either
+			fmt.Printf("EXIF解析エラー: %v\n", err)
+			kept = nil
+			if policy.StripLocation || policy.StripDevice || processed {
+				names = []string{metadataExif}
+			}
+		}
+		removed = append(removed, names...)
+	}
+
+	dropOthers := policy.StripLocation || policy.StripDevice
+	if dropOthers || processed {
+		for _, kind := range kinds {
+			if kind != metadataExif && !slices.Contains(removed, kind) {
+				removed = append(removed, kind)
+			}
+		}
+	}
+	if exifData == nil && !dropOthers {
+		return removed, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// Processing may have changed the format if the extension did not
+	// match the content
+	rewritten, err := rewriteImageMetadata(data, http.DetectContentType(data), kept, dropOthers)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(rewritten, data) {
+		return removed, nil
+	}
+	return removed, os.WriteFile(path, rewritten, 0644)
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	"simultaneous-memo-app/backend/models"
+
+	"github.com/buckket/go-blurhash"
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// placeholderSampleSize bounds the copy placeholders are computed from;
+	// blurhash and colors need very little detail
+	placeholderSampleSize = 64
+	// previewSize bounds the inline preview image
+	previewSize = 16
+)
+
+// applyPlaceholders computes the blurhash, inline preview and dominant
+// color shown while the image at path loads and sets them on the record
+func applyPlaceholders(image *models.Image, path string) error {
+	src, err := openPlaceholderSource(path)
+	if err != nil {
+		return fmt.Errorf("画像を開けませんでした: %w", err)
+	}
+	sample := imaging.Fit(src, placeholderSampleSize, placeholderSampleSize, imaging.Box)
+
+	// More components along the longer side keep the blur proportional
+	xComponents, yComponents := 4, 3
+	if sample.Bounds().Dy() > sample.Bounds().Dx() {
+		xComponents, yComponents = 3, 4
+	}
+	hash, err := blurhash.Encode(xComponents, yComponents, sample)
+	if err != nil {
+		return fmt.Errorf("blurhashの計算に失敗しました: %w", err)
+	}
+
+	preview, err := previewDataURL(imaging.Fit(sample, previewSize, previewSize, imaging.Box))
+	if err != nil {
+		return fmt.Errorf("プレビューの作成に失敗しました: %w", err)
+	}
+
+	image.BlurHash = hash
+	image.Preview = preview
+	image.DominantColor = dominantColor(sample)
+	return nil
+}
+
+// openPlaceholderSource opens the image placeholders are computed from.
+// SVGs are drawn at the sample size.
+func openPlaceholderSource(path string) (image.Image, error) {
+	if isSVGPath(path) {
+		return rasterizeSVGFile(path, placeholderSampleSize, placeholderSampleSize)
+	}
+	return imaging.Open(path, imaging.AutoOrientation(true))
+}
+
+// previewDataURL encodes a tiny image as a data URL, using PNG only when
+// transparency has to be kept
+func previewDataURL(img *image.NRGBA) (string, error) {
+	var buf bytes.Buffer
+	contentType := "image/jpeg"
+	var err error
+	if img.Opaque() {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 70})
+	} else {
+		contentType = "image/png"
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return "", err
+	}
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// dominantColor returns the most common color of an image as "#rrggbb".
+// Colors are grouped at 4 bits per channel and the average of the largest
+// group is returned; mostly transparent pixels are ignored.
+func dominantColor(img *image.NRGBA) string {
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+	var buckets [4096]bucket
+	best := -1
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := img.NRGBAAt(x, y)
+			if c.A < 128 {
+				continue
+			}
+			i := int(c.R>>4)<<8 | int(c.G>>4)<<4 | int(c.B>>4)
+			buckets[i].count++
+			buckets[i].r += int(c.R)
+			buckets[i].g += int(c.G)
+			buckets[i].b += int(c.B)
+			if best < 0 || buckets[i].count > buckets[best].count {
+				best = i
+			}
+		}
+	}
+
+	if best < 0 {
+		return ""
+	}
+	b := buckets[best]
+	return fmt.Sprintf("#%02x%02x%02x", b.r/b.count, b.g/b.count, b.b/b.count)
+}
@@ -5,6 +5,7 @@ import (
 	"image"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/disintegration/imaging"
 )
@@ -27,8 +28,21 @@ func DefaultImageConfig() ImageConfig {
 	}
 }
 
-// ProcessImage resizes and optimizes an image
+// ProcessImage resizes and optimizes an image. Animated GIFs keep their
+// frames.
 func ProcessImage(srcPath, dstPath string, config ImageConfig) error {
+	if strings.ToLower(filepath.Ext(dstPath)) == ".gif" {
+		if g, ok := readAnimatedGIF(srcPath); ok {
+			if g.Config.Width > config.MaxWidth || g.Config.Height > config.MaxHeight {
+				g = resizeGIF(g, config.MaxWidth, config.MaxHeight)
+			}
+			if err := saveGIF(g, dstPath); err != nil {
+				return fmt.Errorf("画像の保存に失敗しました: %w", err)
+			}
+			return nil
+		}
+	}
+
 	// Open the source image with auto-orientation
 	src, err := imaging.Open(srcPath, imaging.AutoOrientation(true))
 	if err != nil {
@@ -46,16 +60,15 @@ func ProcessImage(srcPath, dstPath string, config ImageConfig) error {
 	}
 
 	// Determine output format based on file extension
-	ext := filepath.Ext(dstPath)
+	ext := strings.ToLower(filepath.Ext(dstPath))
 	switch ext {
 	case ".png":
 		err = imaging.Save(src, dstPath, imaging.PNGCompressionLevel(6))
 	case ".gif":
 		err = imaging.Save(src, dstPath)
 	case ".webp":
-		// WebP is not directly supported by imaging library, save as JPEG
-		dstPath = dstPath[:len(dstPath)-5] + ".jpg"
-		err = imaging.Save(src, dstPath, imaging.JPEGQuality(config.Quality))
+		// The imaging library cannot encode WebP
+		err = saveWebP(src, dstPath)
 	default:
 		// Default to JPEG
 		err = imaging.Save(src, dstPath, imaging.JPEGQuality(config.Quality))
@@ -68,10 +81,32 @@ func ProcessImage(srcPath, dstPath string, config ImageConfig) error {
 	return nil
 }
 
-// CreateThumbnail creates a thumbnail version of the image
+// CreateThumbnail creates a thumbnail version of the image. Thumbnails of
+// animated GIFs are animated too.
 func CreateThumbnail(srcPath, thumbPath string, config ImageConfig) error {
-	// Open the source image
-	src, err := imaging.Open(srcPath)
+	// Ensure thumbnail directory exists
+	thumbDir := filepath.Dir(thumbPath)
+	if err := os.MkdirAll(thumbDir, 0755); err != nil {
+		return fmt.Errorf("サムネイルディレクトリの作成に失敗しました: %w", err)
+	}
+
+	if strings.ToLower(filepath.Ext(thumbPath)) == ".gif" {
+		if g, ok := readAnimatedGIF(srcPath); ok {
+			if err := saveGIF(resizeGIF(g, config.ThumbnailWidth, 0), thumbPath); err != nil {
+				return fmt.Errorf("サムネイルの保存に失敗しました: %w", err)
+			}
+			return nil
+		}
+	}
+
+	// Open the source image. SVGs are drawn at the thumbnail width.
+	var src image.Image
+	var err error
+	if isSVGPath(srcPath) {
+		src, err = rasterizeSVGFile(srcPath, config.ThumbnailWidth, 0)
+	} else {
+		src, err = imaging.Open(srcPath)
+	}
 	if err != nil {
 		return fmt.Errorf("画像を開けませんでした: %w", err)
 	}
@@ -79,14 +114,12 @@ func CreateThumbnail(srcPath, thumbPath string, config ImageConfig) error {
 	// Create thumbnail with fixed width
 	thumbnail := imaging.Resize(src, config.ThumbnailWidth, 0, imaging.Lanczos)
 
-	// Ensure thumbnail directory exists
-	thumbDir := filepath.Dir(thumbPath)
-	if err := os.MkdirAll(thumbDir, 0755); err != nil {
-		return fmt.Errorf("サムネイルディレクトリの作成に失敗しました: %w", err)
-	}
-
 	// Save thumbnail
-	err = imaging.Save(thumbnail, thumbPath, imaging.JPEGQuality(config.Quality))
+	if strings.ToLower(filepath.Ext(thumbPath)) == ".webp" {
+		err = saveWebP(thumbnail, thumbPath)
+	} else {
+		err = imaging.Save(thumbnail, thumbPath, imaging.JPEGQuality(config.Quality))
+	}
 	if err != nil {
 		return fmt.Errorf("サムネイルの保存に失敗しました: %w", err)
 	}
@@ -94,8 +127,26 @@ func CreateThumbnail(srcPath, thumbPath string, config ImageConfig) error {
 	return nil
 }
 
-// GetImageDimensions returns the dimensions of an image
+// saveWebP writes an image to path as lossless WebP
+func saveWebP(img image.Image, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := encodeImage(file, img, "image/webp", 0); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// GetImageDimensions returns the dimensions of an image. For SVGs this is
+// their intrinsic size.
 func GetImageDimensions(imagePath string) (int, int, error) {
+	if isSVGPath(imagePath) {
+		return svgDimensions(imagePath)
+	}
+
 	file, err := os.Open(imagePath)
 	if err != nil {
 		return 0, 0, err
@@ -1,13 +1,17 @@
 package handlers
 
 import (
+	"bytes"
 	"fmt"
+	"image/gif"
+	"io"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 
+	"simultaneous-memo-app/backend/storage"
+
 	"github.com/disintegration/imaging"
 	"github.com/labstack/echo/v4"
 )
@@ -16,7 +20,6 @@ import (
 func (h *Handler) ServeImage(c echo.Context) error {
 	// Get image path
 	path := c.Param("*")
-	imagePath := filepath.Join("../uploads", path)
 
 	// Security check
 	if strings.Contains(path, "..") {
@@ -30,13 +33,22 @@ func (h *Handler) ServeImage(c echo.Context) error {
 	heightStr := c.QueryParam("h")
 	quality := c.QueryParam("q")
 	format := c.QueryParam("format")
+	// poster serves a still of the first frame of an animated GIF
+	poster := c.QueryParam("poster") == "1"
 
 	// Check if original file exists
-	if _, err := os.Stat(imagePath); os.IsNotExist(err) {
+	ctx := c.Request().Context()
+	info, err := h.store.Stat(ctx, path)
+	if err == storage.ErrNotExist {
 		return c.JSON(http.StatusNotFound, map[string]string{
 			"error": "画像が見つかりません",
 		})
 	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "ファイル情報の取得に失敗しました",
+		})
+	}
 
 	// Initialize dimensions
 	width := 0
@@ -45,22 +57,28 @@ func (h *Handler) ServeImage(c echo.Context) error {
 	// Check for size parameter (thumbnail)
 	size := c.QueryParam("size")
 	if size == "thumbnail" {
-		// Try to find thumbnail version first
-		dir := filepath.Dir(imagePath)
-		filename := filepath.Base(imagePath)
-		thumbPath := filepath.Join(dir, "thumb_"+filename)
+		// Try to find thumbnail version first. Stored thumbnails of
+		// animated GIFs are animated, so posters are always generated.
+		base := filepath.Base(path)
+		ext := filepath.Ext(base)
+		thumbKey := filepath.ToSlash(filepath.Join(filepath.Dir(path), "thumb_"+strings.TrimSuffix(base, ext)+thumbnailExtension(ext)))
 		
-		if _, err := os.Stat(thumbPath); err == nil {
+		if thumbInfo, err := h.store.Stat(ctx, thumbKey); err == nil && !poster {
 			// Serve existing thumbnail
-			return serveStaticFile(c, thumbPath)
+			return h.serveStaticFile(c, thumbInfo)
 		}
 		// Fall through to generate thumbnail dynamically
 		width = 300
 		height = 300
 	}
 
-	// If no resizing parameters, serve original
-	if widthStr == "" && heightStr == "" && quality == "" && format == "" && size == "" {
+	// If no resizing parameters, serve original. SVGs scale by themselves,
+	// so they are only rasterized for thumbnails and raster formats.
+	sourceType := GetMIMEType(path)
+	if widthStr == "" && heightStr == "" && quality == "" && format == "" && size == "" && !poster {
+		return h.GetFile(c)
+	}
+	if sourceType == "image/svg+xml" && size == "" && imageFormats[strings.ToLower(format)] == "" {
 		return h.GetFile(c)
 	}
 
@@ -86,71 +104,94 @@ func (h *Handler) ServeImage(c echo.Context) error {
 		}
 	}
 
-	// Generate cache key
-	cacheKey := fmt.Sprintf("%s_w%d_h%d_q%d", path, width, height, qualityInt)
-	if format != "" {
-		cacheKey += "_" + format
+	// Set appropriate content type. Without a known format, it is chosen
+	// from the Accept header.
+	contentType, ok := imageFormats[strings.ToLower(format)]
+	negotiated := !ok
+	if negotiated {
+		contentType = negotiateImageFormat(c.Request().Header.Get("Accept"), sourceType)
+	}
+	// WebP is encoded losslessly, so a quality cannot be honoured and an
+	// explicit request for one is refused rather than silently ignored
+	if !negotiated && contentType == "image/webp" && quality != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "WebPはロスレスで出力するためqは指定できません",
+		})
 	}
 
-	// TODO: Implement caching mechanism here
+	// Generate cache key. The modification time makes variants of a
+	// replaced file miss even if invalidation was skipped.
+	cacheKey := fmt.Sprintf("%s_w%d_h%d_q%d_%d_%s_p%t", path, width, height, qualityInt, info.ModTime.UnixNano(), contentType, poster)
 
-	// Open and resize image
-	img, err := imaging.Open(imagePath)
+	file, err := h.variants.Get(path, cacheKey, imageExtension(contentType), func(w io.Writer) error {
+		src, err := h.store.Get(ctx, path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		return renderVariant(w, src, sourceType, width, height, qualityInt, contentType, poster)
+	})
 	if err != nil {
+		fmt.Printf("画像変換エラー: %v\n", err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "画像を開けませんでした",
 		})
 	}
+	defer file.Close()
 
-	// Resize if dimensions are specified
-	if width > 0 || height > 0 {
-		if width > 0 && height > 0 {
-			// Fit within bounds
-			img = imaging.Fit(img, width, height, imaging.Lanczos)
-		} else if width > 0 {
-			// Resize by width
-			img = imaging.Resize(img, width, 0, imaging.Lanczos)
-		} else {
-			// Resize by height
-			img = imaging.Resize(img, 0, height, imaging.Lanczos)
-		}
+	// Set headers
+	c.Response().Header().Set("Content-Type", contentType)
+	c.Response().Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	c.Response().Header().Set("X-Content-Type-Options", "nosniff")
+	if negotiated {
+		c.Response().Header().Set("Vary", "Accept")
 	}
 
-	// Set appropriate content type
-	contentType := GetMIMEType(imagePath)
-	if format != "" {
-		switch format {
-		case "jpeg", "jpg":
-			contentType = "image/jpeg"
-		case "png":
-			contentType = "image/png"
-		case "webp":
-			contentType = "image/webp"
+	return c.Stream(http.StatusOK, contentType, file)
+}
+
+// renderVariant resizes an image of sourceType and encodes it as
+// contentType. Animated GIFs stay animated when served as GIF unless
+// poster asks for a still of the first frame; SVGs are drawn at the size.
+func renderVariant(w io.Writer, src io.Reader, sourceType string, width, height, quality int, contentType string, poster bool) error {
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return err
+	}
+	if sourceType == "image/svg+xml" {
+		img, err := rasterizeSVG(data, width, height)
+		if err != nil {
+			return err
+		}
+		return encodeImage(w, img, contentType, quality)
+	}
+	if contentType == "image/gif" && !poster {
+		if g, ok := decodeAnimatedGIF(data); ok {
+			if width > 0 || height > 0 {
+				g = resizeGIF(g, width, height)
+			}
+			return gif.EncodeAll(w, g)
 		}
 	}
 
-	// Set headers
-	c.Response().Header().Set("Content-Type", contentType)
-	c.Response().Header().Set("Cache-Control", "public, max-age=31536000, immutable")
-	c.Response().Header().Set("X-Content-Type-Options", "nosniff")
+	// Decode and resize image
+	img, err := imaging.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
 
-	// Encode and send image
-	switch contentType {
-	case "image/jpeg":
-		return imaging.Encode(c.Response().Writer, img, imaging.JPEG, imaging.JPEGQuality(qualityInt))
-	case "image/png":
-		return imaging.Encode(c.Response().Writer, img, imaging.PNG)
-	case "image/gif":
-		return imaging.Encode(c.Response().Writer, img, imaging.GIF)
-	default:
-		// Default to JPEG
-		return imaging.Encode(c.Response().Writer, img, imaging.JPEG, imaging.JPEGQuality(qualityInt))
+	// Resize if dimensions are specified, fitting within both if given
+	if width > 0 || height > 0 {
+		img = resizeFrame(img, width, height)
 	}
+
+	return encodeImage(w, img, contentType, quality)
 }
 
-// serveStaticFile serves a static file with appropriate headers
-func serveStaticFile(c echo.Context, filePath string) error {
-	file, err := os.Open(filePath)
+
+// serveStaticFile serves a stored file with appropriate headers
+func (h *Handler) serveStaticFile(c echo.Context, info *storage.ObjectInfo) error {
+	file, err := h.store.Get(c.Request().Context(), info.Key)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "ファイルを開けませんでした",
@@ -158,19 +199,12 @@ func serveStaticFile(c echo.Context, filePath string) error {
 	}
 	defer file.Close()
 
-	fileInfo, err := os.Stat(filePath)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "ファイル情報の取得に失敗しました",
-		})
-	}
-
 	// Set headers
-	contentType := GetMIMEType(filePath)
+	contentType := GetMIMEType(info.Key)
 	c.Response().Header().Set("Content-Type", contentType)
 	c.Response().Header().Set("Cache-Control", "public, max-age=31536000, immutable")
-	c.Response().Header().Set("Last-Modified", fileInfo.ModTime().Format(http.TimeFormat))
+	c.Response().Header().Set("Last-Modified", info.ModTime.Format(http.TimeFormat))
 	c.Response().Header().Set("X-Content-Type-Options", "nosniff")
 
 	return c.Stream(http.StatusOK, contentType, file)
-}
\ No newline at end of file
+}
@@ -0,0 +1,467 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/disintegration/imaging"
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+)
+
+const (
+	// svgDefaultWidth and svgDefaultHeight are the size browsers give an
+	// SVG that does not declare one
+	svgDefaultWidth  = 300
+	svgDefaultHeight = 150
+	// maxSVGRasterSize bounds the longer side of rasterized SVGs
+	maxSVGRasterSize = 4096
+)
+
+var errNotSVG = errors.New("svg: root element is not svg")
+
+// svgBlockedElements are removed from uploaded SVGs along with everything
+// inside them. Metadata is removed like XMP is from other images.
+var svgBlockedElements = map[string]bool{
+	"script":        true,
+	"foreignobject": true,
+	"iframe":        true,
+	"embed":         true,
+	"object":        true,
+	"handler":       true,
+	"listener":      true,
+	"metadata":      true,
+}
+
+// svgAnimationElements can set attributes, so they are removed when they
+// target a link or an event handler
+var svgAnimationElements = map[string]bool{
+	"set":              true,
+	"animate":          true,
+	"animatemotion":    true,
+	"animatetransform": true,
+}
+
+// svgURLPattern matches CSS url() references and captures the target
+var svgURLPattern = regexp.MustCompile(`(?i)url\(\s*['"]?([^'")]*)`)
+
+// svgSafeDataURL matches the raster images SVGs may embed
+var svgSafeDataURL = regexp.MustCompile(`(?i)^data:image/(png|jpeg|gif|webp);`)
+
+// svgTextEscaper escapes text content, keeping line breaks that
+// xml.EscapeText would encode
+var svgTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// isSVGPath reports whether a path names an SVG file
+func isSVGPath(path string) bool {
+	return strings.ToLower(filepath.Ext(path)) == ".svg"
+}
+
+// thumbnailExtension returns the extension of the thumbnail of an image
+// with the given extension. SVG thumbnails are rasterized to PNG.
+func thumbnailExtension(ext string) string {
+	if strings.ToLower(ext) == ".svg" {
+		return ".png"
+	}
+	return ext
+}
+
+// sanitizeSVGFile rewrites the SVG file at path with sanitizeSVG
+func sanitizeSVGFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	clean, err := sanitizeSVG(data)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, clean, 0644)
+}
+
+// sanitizeSVG rewrites an SVG document without scripts, event handlers,
+// foreignObject and references to anything outside the document, so it
+// can be shown inline without running code or loading other resources.
+// Comments, processing instructions and DOCTYPEs are dropped; entities
+// they would declare make the document invalid.
+func sanitizeSVG(data []byte) ([]byte, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	var out bytes.Buffer
+	var open []xml.Name
+	rootSeen := false
+
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			local := strings.ToLower(t.Name.Local)
+			if !rootSeen {
+				if local != "svg" {
+					return nil, errNotSVG
+				}
+				rootSeen = true
+			} else if len(open) == 0 {
+				return nil, errors.New("svg: content after the root element")
+			}
+
+			if svgBlockedElements[local] || (svgAnimationElements[local] && animatesUnsafeAttr(t.Attr)) {
+				if err := skipSVGElement(d); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if local == "style" {
+				css, err := readSVGText(d)
+				if err != nil {
+					return nil, err
+				}
+				if safeSVGStyle(css) {
+					writeSVGStart(&out, t)
+					svgTextEscaper.WriteString(&out, css)
+					out.WriteString("</" + svgName(t.Name) + ">")
+				}
+				continue
+			}
+
+			writeSVGStart(&out, t)
+			open = append(open, t.Name)
+		case xml.EndElement:
+			// RawToken does not match end tags to start tags
+			if len(open) == 0 || open[len(open)-1] != t.Name {
+				return nil, fmt.Errorf("svg: unexpected end element %s", svgName(t.Name))
+			}
+			open = open[:len(open)-1]
+			out.WriteString("</" + svgName(t.Name) + ">")
+		case xml.CharData:
+			if len(open) > 0 {
+				svgTextEscaper.WriteString(&out, string(t))
+			}
+		}
+	}
+
+	if !rootSeen {
+		return nil, errNotSVG
+	}
+	if len(open) > 0 {
+		return nil, errors.New("svg: unclosed elements")
+	}
+	return out.Bytes(), nil
+}
+
+// writeSVGStart writes a start element with its safe attributes
+func writeSVGStart(out *bytes.Buffer, t xml.StartElement) {
+	out.WriteString("<" + svgName(t.Name))
+	for _, attr := range t.Attr {
+		if !safeSVGAttr(attr) {
+			continue
+		}
+		out.WriteString(" " + svgName(attr.Name) + `="`)
+		xml.EscapeText(out, []byte(attr.Value))
+		out.WriteString(`"`)
+	}
+	out.WriteString(">")
+}
+
+// safeSVGAttr reports whether an attribute can be kept: event handlers,
+// xml:base and links or CSS referencing anything but the document itself
+// are not
+func safeSVGAttr(attr xml.Attr) bool {
+	name := strings.ToLower(attr.Name.Local)
+	value := strings.TrimSpace(attr.Value)
+	switch {
+	case strings.HasPrefix(name, "on"):
+		return false
+	case name == "base" && attr.Name.Space == "xml":
+		return false
+	case name == "href":
+		return strings.HasPrefix(value, "#") || svgSafeDataURL.MatchString(value)
+	}
+	return safeSVGStyle(value)
+}
+
+// safeSVGStyle reports whether CSS, or an attribute value that may hold
+// CSS, only references the document itself. Escapes are decoded first, so
+// "\75 rl(" is checked as "url(".
+func safeSVGStyle(css string) bool {
+	css = decodeCSSEscapes(css)
+	lower := strings.ToLower(css)
+	for _, unsafe := range []string{"@import", "expression(", "javascript:", "image-set("} {
+		if strings.Contains(lower, unsafe) {
+			return false
+		}
+	}
+	for _, m := range svgURLPattern.FindAllStringSubmatch(css, -1) {
+		if target := strings.TrimSpace(m[1]); !strings.HasPrefix(target, "#") && !svgSafeDataURL.MatchString(target) {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeCSSEscapes replaces CSS escapes with the characters they stand for:
+// a backslash followed by up to six hex digits and an optional space, or
+// by any other character. Escaped line breaks are removed.
+func decodeCSSEscapes(css string) string {
+	if !strings.Contains(css, "\\") {
+		return css
+	}
+	var out strings.Builder
+	for i := 0; i < len(css); i++ {
+		if css[i] != '\\' {
+			out.WriteByte(css[i])
+			continue
+		}
+		i++
+		if i == len(css) {
+			break
+		}
+		hex := 0
+		for hex < 6 && i+hex < len(css) && isHexDigit(css[i+hex]) {
+			hex++
+		}
+		if hex == 0 {
+			switch css[i] {
+			case '\n', '\f':
+			case '\r':
+				if i+1 < len(css) && css[i+1] == '\n' {
+					i++
+				}
+			default:
+				out.WriteByte(css[i])
+			}
+			continue
+		}
+		code, _ := strconv.ParseUint(css[i:i+hex], 16, 32)
+		r := rune(code)
+		if r == 0 || !utf8.ValidRune(r) {
+			r = utf8.RuneError
+		}
+		out.WriteRune(r)
+		i += hex
+		// A single whitespace ends the escape and is part of it
+		if i < len(css) && strings.IndexByte(" \t\n\r\f", css[i]) >= 0 {
+			if css[i] == '\r' && i+1 < len(css) && css[i+1] == '\n' {
+				i++
+			}
+		} else {
+			i--
+		}
+	}
+	return out.String()
+}
+
+// isHexDigit reports whether c is a hexadecimal digit
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// animatesUnsafeAttr reports whether an animation element targets a link
+// or an event handler
+func animatesUnsafeAttr(attrs []xml.Attr) bool {
+	for _, attr := range attrs {
+		if strings.ToLower(attr.Name.Local) != "attributename" {
+			continue
+		}
+		target := strings.ToLower(strings.TrimSpace(attr.Value))
+		if i := strings.LastIndex(target, ":"); i >= 0 {
+			target = target[i+1:]
+		}
+		if target == "href" || strings.HasPrefix(target, "on") {
+			return true
+		}
+	}
+	return false
+}
+
+// skipSVGElement consumes the rest of the element whose start was just read
+func skipSVGElement(d *xml.Decoder) error {
+	for depth := 1; depth > 0; {
+		tok, err := d.RawToken()
+		if err != nil {
+			return err
+		}
+		switch tok.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+	return nil
+}
+
+// readSVGText returns the text of the element whose start was just read,
+// consuming the element. Nested elements are dropped.
+func readSVGText(d *xml.Decoder) (string, error) {
+	var text strings.Builder
+	for depth := 1; depth > 0; {
+		tok, err := d.RawToken()
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+			if depth == 1 {
+				text.Write(t)
+			}
+		}
+	}
+	return text.String(), nil
+}
+
+// svgName returns a name as written in the document, with its prefix
+func svgName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// svgSize returns the intrinsic size of an SVG from the width and height of
+// its root element, falling back to the proportions of its viewBox and
+// then to the size browsers use
+func svgSize(data []byte) (int, int, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := d.RawToken()
+		if err != nil {
+			return 0, 0, err
+		}
+		t, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if strings.ToLower(t.Name.Local) != "svg" {
+			return 0, 0, errNotSVG
+		}
+
+		var width, height, boxWidth, boxHeight float64
+		for _, attr := range t.Attr {
+			switch attr.Name.Local {
+			case "width":
+				width = svgLength(attr.Value)
+			case "height":
+				height = svgLength(attr.Value)
+			case "viewBox":
+				box := strings.FieldsFunc(attr.Value, func(r rune) bool { return r == ',' || r == ' ' })
+				if len(box) == 4 {
+					boxWidth, _ = strconv.ParseFloat(box[2], 64)
+					boxHeight, _ = strconv.ParseFloat(box[3], 64)
+				}
+			}
+		}
+
+		hasBox := boxWidth > 0 && boxHeight > 0
+		switch {
+		case width > 0 && height > 0:
+		case width > 0 && hasBox:
+			height = width * boxHeight / boxWidth
+		case height > 0 && hasBox:
+			width = height * boxWidth / boxHeight
+		case hasBox:
+			width, height = boxWidth, boxHeight
+		default:
+			width, height = svgDefaultWidth, svgDefaultHeight
+		}
+		return int(math.Max(1, math.Round(width))), int(math.Max(1, math.Round(height))), nil
+	}
+}
+
+// svgLength parses a length in user units or pixels, returning 0 for
+// relative lengths such as percentages
+func svgLength(value string) float64 {
+	value = strings.TrimSuffix(strings.TrimSpace(value), "px")
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return n
+}
+
+// svgDimensions returns the intrinsic size of the SVG file at path
+func svgDimensions(path string) (int, int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	return svgSize(data)
+}
+
+// rasterizeSVGFile is rasterizeSVG for a file
+func rasterizeSVGFile(path string, width, height int) (*image.NRGBA, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return rasterizeSVG(data, width, height)
+}
+
+// rasterizeSVG draws an SVG at a size chosen like resizeFrame chooses
+// one, or at its intrinsic size when neither width nor height is given.
+// SVGs are vectors, so they may be drawn larger than their intrinsic size.
+// Only the shapes, styles and gradients oksvg supports are drawn.
+func rasterizeSVG(data []byte, width, height int) (img *image.NRGBA, err error) {
+	srcWidth, srcHeight, err := svgSize(data)
+	if err != nil {
+		return nil, err
+	}
+	width, height = svgRasterSize(srcWidth, srcHeight, width, height)
+
+	// The parser is not hardened against arbitrary input
+	defer func() {
+		if r := recover(); r != nil {
+			img, err = nil, fmt.Errorf("svg: %v", r)
+		}
+	}()
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	icon.SetTarget(0, 0, float64(width), float64(height))
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	scanner := rasterx.NewScannerGV(width, height, dst, dst.Bounds())
+	icon.Draw(rasterx.NewDasher(width, height, scanner), 1)
+	return imaging.Clone(dst), nil
+}
+
+// svgRasterSize returns the size to rasterize an SVG of the given intrinsic
+// size at, bounded by maxSVGRasterSize
+func svgRasterSize(srcWidth, srcHeight, width, height int) (int, int) {
+	scale := 1.0
+	switch {
+	case width > 0 && height > 0:
+		scale = math.Min(float64(width)/float64(srcWidth), float64(height)/float64(srcHeight))
+	case width > 0:
+		scale = float64(width) / float64(srcWidth)
+	case height > 0:
+		scale = float64(height) / float64(srcHeight)
+	}
+	if longer := float64(max(srcWidth, srcHeight)) * scale; longer > maxSVGRasterSize {
+		scale *= maxSVGRasterSize / longer
+	}
+	w := int(math.Max(1, math.Round(float64(srcWidth)*scale)))
+	h := int(math.Max(1, math.Round(float64(srcHeight)*scale)))
+	return w, h
+}
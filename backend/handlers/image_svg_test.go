@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeSVG(t *testing.T) {
+	const xlink = `xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink"`
+	tests := []struct {
+		name   string
+		input  string
+		keep   []string
+		remove []string
+	}{
+		{
+			name:   "script",
+			input:  `<svg><script>alert(1)</script><rect/></svg>`,
+			keep:   []string{"<rect>"},
+			remove: []string{"script", "alert"},
+		},
+		{
+			name:   "event handlers",
+			input:  `<svg onload="alert(1)"><rect onClick="alert(2)" width="1"/></svg>`,
+			keep:   []string{`width="1"`},
+			remove: []string{"onload", "onClick", "alert"},
+		},
+		{
+			name:   "foreignObject",
+			input:  `<svg><foreignObject><iframe src="https://example.com"></iframe></foreignObject></svg>`,
+			remove: []string{"foreignObject", "iframe", "example.com"},
+		},
+		{
+			name:   "external links",
+			input:  `<svg ` + xlink + `><a href="https://example.com"><use xlink:href="https://example.com/s.svg#x"/></a><image href="javascript:alert(1)"/></svg>`,
+			keep:   []string{"<a>", "<use>", "<image>"},
+			remove: []string{"example.com", "javascript"},
+		},
+		{
+			name:  "internal and data links",
+			input: `<svg ` + xlink + `><use xlink:href="#icon"/><image href="data:image/png;base64,iVBORw0KGgo="/></svg>`,
+			keep:  []string{`xlink:href="#icon"`, `href="data:image/png;base64,iVBORw0KGgo="`},
+		},
+		{
+			name:   "svg data link",
+			input:  `<svg><image href="data:image/svg+xml;base64,PHN2Zz4="/></svg>`,
+			remove: []string{"data:"},
+		},
+		{
+			name:   "xml:base",
+			input:  `<svg xml:base="https://example.com/"><use href="#a"/></svg>`,
+			remove: []string{"xml:base"},
+		},
+		{
+			name:   "external url in attributes",
+			input:  `<svg><rect fill="url(https://example.com/p.svg#g)" stroke="url(#g)" style="fill: url('//example.com/x')"/></svg>`,
+			keep:   []string{`stroke="url(#g)"`},
+			remove: []string{"example.com"},
+		},
+		{
+			name:   "style element",
+			input:  `<svg><style>@import url(https://example.com/a.css); rect { fill: red }</style><style>circle { fill: url(#g) }</style></svg>`,
+			keep:   []string{"circle { fill: url(#g) }"},
+			remove: []string{"@import", "example.com"},
+		},
+		{
+			name:   "escaped url",
+			input:  `<svg><style>rect { background: \75 rl(https://example.com/a.png) }</style><rect style="fill: u\72l(https://example.com/b)"/></svg>`,
+			remove: []string{"example.com"},
+		},
+		{
+			name:   "escaped import",
+			input:  `<svg><style>@\69mport "https://example.com/a.css";</style></svg>`,
+			remove: []string{"example.com"},
+		},
+		{
+			name:   "escaped line break in url",
+			input:  "<svg><style>rect { fill: u\\\nrl(https://example.com/a) }</style></svg>",
+			remove: []string{"example.com"},
+		},
+		{
+			name:   "image-set",
+			input:  `<svg><rect style="background-image: image-set('https://example.com/a.png' 1x)"/></svg>`,
+			keep:   []string{"<rect>"},
+			remove: []string{"example.com"},
+		},
+		{
+			name:   "animation of links and handlers",
+			input:  `<svg><a><set attributeName="href" to="javascript:alert(1)"/><animate attributeName="xlink:href" values="https://example.com"/><set attributeName="onclick" to="alert(1)"/><animate attributeName="opacity" from="0" to="1"/></a></svg>`,
+			keep:   []string{`attributeName="opacity"`},
+			remove: []string{"javascript", "example.com", "onclick"},
+		},
+		{
+			name:   "comments, doctype and metadata",
+			input:  `<?xml version="1.0"?><!DOCTYPE svg><!-- secret --><svg><metadata><rdf:RDF>author</rdf:RDF></metadata><?pi data?><rect/></svg>`,
+			keep:   []string{"<svg><rect></rect></svg>"},
+			remove: []string{"secret", "DOCTYPE", "author", "<?"},
+		},
+		{
+			name:  "text",
+			input: "<svg><text>a &lt; b &amp; c\nnext</text></svg>",
+			keep:  []string{"a &lt; b &amp; c\nnext"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := sanitizeSVG([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("sanitizeSVG() error = %v", err)
+			}
+			for _, s := range tt.keep {
+				if !strings.Contains(string(out), s) {
+					t.Errorf("sanitizeSVG() = %s, want it to contain %s", out, s)
+				}
+			}
+			for _, s := range tt.remove {
+				if strings.Contains(string(out), s) {
+					t.Errorf("sanitizeSVG() = %s, want %s removed", out, s)
+				}
+			}
+			// The output is sanitized already
+			again, err := sanitizeSVG(out)
+			if err != nil || string(again) != string(out) {
+				t.Errorf("sanitizeSVG() is not stable: %s, %v", again, err)
+			}
+		})
+	}
+}
+
+func TestSanitizeSVGErrors(t *testing.T) {
+	tests := map[string]string{
+		"html root":     `<html><svg/></html>`,
+		"no root":       `<?xml version="1.0"?>`,
+		"empty":         ``,
+		"unclosed":      `<svg><g></svg>`,
+		"mismatched":    `<svg><g></rect></svg>`,
+		"second root":   `<svg></svg><svg></svg>`,
+		"entity":        `<!DOCTYPE svg [<!ENTITY x "y">]><svg>&x;</svg>`,
+		"unclosed text": `<svg><text>`,
+	}
+	for name, input := range tests {
+		if _, err := sanitizeSVG([]byte(input)); err == nil {
+			t.Errorf("%s: sanitizeSVG() error = nil", name)
+		}
+	}
+	if _, err := sanitizeSVG([]byte(`<html/>`)); !errors.Is(err, errNotSVG) {
+		t.Errorf("sanitizeSVG() error = %v, want errNotSVG", err)
+	}
+}
+
+func TestDecodeCSSEscapes(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`url(#a)`, `url(#a)`},
+		{`\75 rl(`, `url(`},
+		{`\000075rl(`, `url(`},
+		{`\55RL(`, `URL(`},
+		{`@\69mport`, `@import`},
+		{`\@import`, `@import`},
+		{`u\rl(`, `url(`},
+		{"u\\\nrl(", `url(`},
+		{"\\75\r\nrl(", `url(`},
+		{`a\\b`, `a\b`},
+		{`\0`, "\uFFFD"},
+		{`\110000`, "\uFFFD"},
+		{`\`, ``},
+	}
+	for _, tt := range tests {
+		if got := decodeCSSEscapes(tt.input); got != tt.want {
+			t.Errorf("decodeCSSEscapes(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestSVGSize(t *testing.T) {
+	tests := []struct {
+		input         string
+		width, height int
+	}{
+		{`<svg width="120" height="80"/>`, 120, 80},
+		{`<svg width="120px" height="80px" viewBox="0 0 10 10"/>`, 120, 80},
+		{`<svg width="200" viewBox="0 0 100 50"/>`, 200, 100},
+		{`<svg height="100" viewBox="0,0,100,50"/>`, 200, 100},
+		{`<svg viewBox="0 0 64 32"/>`, 64, 32},
+		{`<svg width="100%" height="100%"/>`, svgDefaultWidth, svgDefaultHeight},
+		{`<?xml version="1.0"?><!-- c --><svg/>`, svgDefaultWidth, svgDefaultHeight},
+	}
+	for _, tt := range tests {
+		width, height, err := svgSize([]byte(tt.input))
+		if err != nil {
+			t.Errorf("svgSize(%s) error = %v", tt.input, err)
+			continue
+		}
+		if width != tt.width || height != tt.height {
+			t.Errorf("svgSize(%s) = %dx%d, want %dx%d", tt.input, width, height, tt.width, tt.height)
+		}
+	}
+	if _, _, err := svgSize([]byte(`<html/>`)); !errors.Is(err, errNotSVG) {
+		t.Errorf("svgSize() error = %v, want errNotSVG", err)
+	}
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/gif"
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"simultaneous-memo-app/backend/models"
+	"simultaneous-memo-app/backend/storage"
+
+	"github.com/disintegration/imaging"
+)
+
+// imageVariantWidths are the widths of the resized versions generated for
+// every uploaded image
+var imageVariantWidths = []int{320, 640, 1280, 1920}
+
+// SetImageVariantWidths sets the widths of the resized versions generated
+// for uploaded images. Images uploaded earlier keep their variants.
+func SetImageVariantWidths(widths []int) {
+	sorted := make([]int, 0, len(widths))
+	for _, width := range widths {
+		if width > 0 {
+			sorted = append(sorted, width)
+		}
+	}
+	sort.Ints(sorted)
+	imageVariantWidths = sorted
+}
+
+// variantPathPattern matches variant paths and captures the path of the
+// image they belong to
+var variantPathPattern = regexp.MustCompile(`^(.+)_w[0-9]+(\.[A-Za-z0-9]+)$`)
+
+// variantPath returns the path of a variant of the image at path, e.g.
+// "/images/sha256/ab/<digest>_w640.jpg"
+func variantPath(path string, width int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s_w%d%s", strings.TrimSuffix(path, ext), width, ext)
+}
+
+// variantSource returns the path of the image a variant path belongs to
+func variantSource(path string) (string, bool) {
+	m := variantPathPattern.FindStringSubmatch(path)
+	if m == nil {
+		return "", false
+	}
+	return m[1] + m[2], true
+}
+
+// variantHeight returns the height of an image resized to width, rounded
+// the way imaging.Resize rounds it
+func variantHeight(width, srcWidth, srcHeight int) int {
+	return int(math.Max(1, math.Floor(float64(srcHeight)*float64(width)/float64(srcWidth)+0.5)))
+}
+
+// putVariantsIfMissing stores the variants of the image at relPath that are
+// narrower than it, creating the missing ones from the local file at path.
+// Variants of animated GIFs are animated too. SVGs scale by themselves and
+// get none.
+func putVariantsIfMissing(ctx context.Context, store storage.Storage, relPath, path, contentType string) ([]models.ImageVariant, error) {
+	if contentType == "image/svg+xml" {
+		return nil, nil
+	}
+	srcWidth, srcHeight, err := GetImageDimensions(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var src image.Image
+	var anim *gif.GIF
+	variants := []models.ImageVariant{}
+	for _, width := range imageVariantWidths {
+		if width >= srcWidth {
+			break
+		}
+		variant := models.ImageVariant{
+			Path:   variantPath(relPath, width),
+			Width:  width,
+			Height: variantHeight(width, srcWidth, srcHeight),
+			Format: strings.TrimPrefix(contentType, "image/"),
+		}
+		variant.URL = "/api/img" + variant.Path
+		key := models.ImageKey(variant.Path)
+
+		info, err := store.Stat(ctx, key)
+		if err == storage.ErrNotExist {
+			if src == nil && anim == nil {
+				if contentType == "image/gif" {
+					anim, _ = readAnimatedGIF(path)
+				}
+				if anim == nil {
+					if src, err = imaging.Open(path); err != nil {
+						return variants, err
+					}
+				}
+			}
+			var buf bytes.Buffer
+			if anim != nil {
+				err = gif.EncodeAll(&buf, resizeGIF(anim, width, 0))
+			} else {
+				err = encodeImage(&buf, imaging.Resize(src, width, 0, imaging.Lanczos), contentType, DefaultImageConfig().Quality)
+			}
+			if err != nil {
+				return variants, err
+			}
+			size := int64(buf.Len())
+			if err := store.Put(ctx, key, &buf, size, contentType); err != nil {
+				return variants, err
+			}
+			info = &storage.ObjectInfo{Key: key, Size: size}
+		} else if err != nil {
+			return variants, err
+		}
+
+		variant.Size = info.Size
+		variants = append(variants, variant)
+	}
+	return variants, nil
+}
+
+// removeImageVariants deletes the stored variants of the image at path
+func removeImageVariants(ctx context.Context, store storage.Storage, path string) error {
+	ext := filepath.Ext(path)
+	prefix := models.ImageKey(strings.TrimSuffix(path, ext) + "_w")
+	var keys []string
+	err := store.List(ctx, prefix, func(obj storage.ObjectInfo) error {
+		// Content stored under another extension has its own variants
+		if source, ok := variantSource("/" + obj.Key); ok && source == path {
+			keys = append(keys, obj.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := store.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// variantSrcset lists the variants of an image followed by the image
+// itself, or returns "" if it has no variants
+func variantSrcset(image *models.Image, variants []models.ImageVariant) string {
+	if len(variants) == 0 {
+		return ""
+	}
+	var srcset string
+	for _, variant := range variants {
+		srcset += fmt.Sprintf("%s %dw, ", variant.URL, variant.Width)
+	}
+	return srcset + fmt.Sprintf("/api/img%s %dw", image.Path, image.Width)
+}
@@ -0,0 +1,481 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// markdownExporter converts TipTap documents into CommonMark with GFM
+// extensions (tables, task lists and strikethrough)
+type markdownExporter struct {
+	// imageURL returns the URL to use for an image node
+	imageURL func(attrs map[string]interface{}) string
+	// linkURL returns the URL to use for a link mark
+	linkURL func(href string) string
+}
+
+// markdownEscaper escapes characters with inline meaning in Markdown
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"`", "\\`",
+	`*`, `\*`,
+	`_`, `\_`,
+	`[`, `\[`,
+	`]`, `\]`,
+	`<`, `\<`,
+	`>`, `\>`,
+	`~`, `\~`,
+	`|`, `\|`,
+)
+
+// blockStartPattern matches text that would be parsed as a block marker
+// when it appears at the start of a line
+var blockStartPattern = regexp.MustCompile(`^(\s*)(#{1,6}(\s|$)|[-+](\s|$)|\d+[.)](\s|$)|=+\s*$)`)
+
+// Convert renders a doc node as Markdown
+func (m *markdownExporter) Convert(doc map[string]interface{}) string {
+	out := m.blocks(nodeChildren(doc))
+	return strings.TrimRight(out, "\n") + "\n"
+}
+
+// blocks renders a sequence of block nodes separated by blank lines
+func (m *markdownExporter) blocks(nodes []map[string]interface{}) string {
+	parts := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if rendered := m.block(node); rendered != "" {
+			parts = append(parts, rendered)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+func (m *markdownExporter) block(node map[string]interface{}) string {
+	attrs := nodeAttrs(node)
+
+	switch nodeType(node) {
+	case "paragraph":
+		return escapeBlockStart(m.inline(nodeChildren(node)))
+	case "heading":
+		level := intAttr(attrs, "level", 1)
+		if level < 1 || level > 6 {
+			level = 1
+		}
+		return strings.Repeat("#", level) + " " + strings.ReplaceAll(m.inline(nodeChildren(node)), "\n", " ")
+	case "blockquote":
+		return prefixLines(m.blocks(nodeChildren(node)), "> ", "> ")
+	case "bulletList":
+		return m.list(node, func(int, map[string]interface{}) string { return "- " })
+	case "orderedList":
+		start := intAttr(attrs, "start", 1)
+		return m.list(node, func(i int, _ map[string]interface{}) string { return fmt.Sprintf("%d. ", start+i) })
+	case "taskList":
+		return m.list(node, func(_ int, item map[string]interface{}) string {
+			if checked, _ := nodeAttrs(item)["checked"].(bool); checked {
+				return "- [x] "
+			}
+			return "- [ ] "
+		})
+	case "codeBlock":
+		return codeFence(nodeText(node), stringAttr(attrs, "language"))
+	case "horizontalRule":
+		return "---"
+	case "image", "resizableImage":
+		return m.image(node)
+	case "table":
+		return m.table(node)
+	default:
+		// Unknown containers still contribute their children
+		if children := nodeChildren(node); len(children) > 0 {
+			return m.blocks(children)
+		}
+		return ""
+	}
+}
+
+// list renders list items with the marker returned by marker(i, item)
+func (m *markdownExporter) list(node map[string]interface{}, marker func(int, map[string]interface{}) string) string {
+	items := make([]string, 0)
+	for i, item := range nodeChildren(node) {
+		prefix := marker(i, item)
+		body := m.listItemBody(nodeChildren(item))
+		if body == "" {
+			items = append(items, strings.TrimRight(prefix, " "))
+			continue
+		}
+		// Continuation lines align with the item content; a task checkbox is
+		// part of that content, so it does not widen the indent
+		indent := strings.Repeat(" ", len(prefix))
+		if nodeType(node) == "taskList" {
+			indent = "  "
+		}
+		items = append(items, prefixLines(body, prefix, indent))
+	}
+	return strings.Join(items, "\n")
+}
+
+// listItemBody renders the blocks of a list item, keeping nested lists
+// directly under their parent paragraph so the list stays tight
+func (m *markdownExporter) listItemBody(nodes []map[string]interface{}) string {
+	var b strings.Builder
+	for i, node := range nodes {
+		rendered := m.block(node)
+		if rendered == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			switch nodeType(nodes[i]) {
+			case "bulletList", "orderedList", "taskList":
+				b.WriteString("\n")
+			default:
+				b.WriteString("\n\n")
+			}
+		}
+		b.WriteString(rendered)
+	}
+	return b.String()
+}
+
+func (m *markdownExporter) image(node map[string]interface{}) string {
+	attrs := nodeAttrs(node)
+	src := stringAttr(attrs, "src")
+	if m.imageURL != nil {
+		src = m.imageURL(attrs)
+	}
+	if src == "" {
+		return ""
+	}
+
+	out := fmt.Sprintf("![%s](%s", markdownEscaper.Replace(stringAttr(attrs, "alt")), markdownDestination(src))
+	if title := stringAttr(attrs, "title"); title != "" {
+		out += fmt.Sprintf(` "%s"`, strings.ReplaceAll(title, `"`, `\"`))
+	}
+	return out + ")"
+}
+
+// table renders a GFM table; the first row is always used as the header
+func (m *markdownExporter) table(node map[string]interface{}) string {
+	var rows [][]string
+	columns := 0
+	for _, row := range nodeChildren(node) {
+		var cells []string
+		for _, cell := range nodeChildren(row) {
+			text := m.cellText(cell)
+			cells = append(cells, text)
+			for span := intAttr(nodeAttrs(cell), "colspan", 1); span > 1; span-- {
+				cells = append(cells, "")
+			}
+		}
+		if len(cells) > columns {
+			columns = len(cells)
+		}
+		rows = append(rows, cells)
+	}
+	if len(rows) == 0 || columns == 0 {
+		return ""
+	}
+
+	formatRow := func(cells []string) string {
+		for len(cells) < columns {
+			cells = append(cells, "")
+		}
+		return "| " + strings.Join(cells, " | ") + " |"
+	}
+
+	lines := []string{formatRow(rows[0])}
+	separator := make([]string, columns)
+	for i := range separator {
+		separator[i] = "---"
+	}
+	lines = append(lines, formatRow(separator))
+	for _, row := range rows[1:] {
+		lines = append(lines, formatRow(row))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// cellText flattens a table cell into a single line
+func (m *markdownExporter) cellText(cell map[string]interface{}) string {
+	var parts []string
+	for _, child := range nodeChildren(cell) {
+		var rendered string
+		switch nodeType(child) {
+		case "image", "resizableImage":
+			rendered = m.image(child)
+		default:
+			rendered = m.inline(flattenInline(child))
+		}
+		if rendered != "" {
+			parts = append(parts, rendered)
+		}
+	}
+	return strings.ReplaceAll(strings.Join(parts, "<br>"), "\n", "<br>")
+}
+
+// inline renders text nodes with their marks. Consecutive nodes that share
+// the same link are grouped so the link is emitted once.
+func (m *markdownExporter) inline(nodes []map[string]interface{}) string {
+	var b strings.Builder
+	for i := 0; i < len(nodes); {
+		node := nodes[i]
+		href, hasLink := linkHref(node)
+		if !hasLink {
+			b.WriteString(m.inlineNode(node))
+			i++
+			continue
+		}
+
+		j := i
+		var inner strings.Builder
+		for j < len(nodes) {
+			other, ok := linkHref(nodes[j])
+			if !ok || other != href {
+				break
+			}
+			inner.WriteString(m.inlineNode(nodes[j]))
+			j++
+		}
+		if m.linkURL != nil {
+			href = m.linkURL(href)
+		}
+		fmt.Fprintf(&b, "[%s](%s)", inner.String(), markdownDestination(href))
+		i = j
+	}
+	return b.String()
+}
+
+func (m *markdownExporter) inlineNode(node map[string]interface{}) string {
+	switch nodeType(node) {
+	case "text":
+		return formatMarks(nodeTextValue(node), nodeMarks(node))
+	case "hardBreak":
+		return "\\\n"
+	case "mention":
+		attrs := nodeAttrs(node)
+		label := stringAttr(attrs, "label")
+		if label == "" {
+			label = fmt.Sprint(attrs["id"])
+		}
+		return "@" + markdownEscaper.Replace(label)
+	case "image", "resizableImage":
+		return m.image(node)
+	default:
+		return m.inline(nodeChildren(node))
+	}
+}
+
+// formatMarks wraps text in Markdown delimiters for its marks. Leading and
+// trailing whitespace is moved outside the delimiters, which CommonMark
+// requires for emphasis to be recognized.
+func formatMarks(text string, marks []string) string {
+	has := make(map[string]bool, len(marks))
+	for _, mark := range marks {
+		has[mark] = true
+	}
+
+	if has["code"] {
+		return codeSpan(text)
+	}
+
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return markdownEscaper.Replace(text)
+	}
+	lead := text[:strings.Index(text, trimmed)]
+	trail := text[len(lead)+len(trimmed):]
+
+	out := markdownEscaper.Replace(trimmed)
+	if has["strike"] {
+		out = "~~" + out + "~~"
+	}
+	if has["italic"] {
+		out = "*" + out + "*"
+	}
+	if has["bold"] {
+		out = "**" + out + "**"
+	}
+	if has["underline"] {
+		out = "<u>" + out + "</u>"
+	}
+	return lead + out + trail
+}
+
+// codeSpan wraps text in enough backticks to contain any it already has
+func codeSpan(text string) string {
+	fence := strings.Repeat("`", longestRun(text, '`')+1)
+	if strings.HasPrefix(text, "`") || strings.HasSuffix(text, "`") {
+		return fence + " " + text + " " + fence
+	}
+	return fence + text + fence
+}
+
+// codeFence renders a fenced code block longer than any fence in code
+func codeFence(code, language string) string {
+	fenceLen := longestRun(code, '`') + 1
+	if fenceLen < 3 {
+		fenceLen = 3
+	}
+	fence := strings.Repeat("`", fenceLen)
+	return fence + language + "\n" + strings.TrimRight(code, "\n") + "\n" + fence
+}
+
+func longestRun(s string, r byte) int {
+	longest, current := 0, 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == r {
+			current++
+			if current > longest {
+				longest = current
+			}
+		} else {
+			current = 0
+		}
+	}
+	return longest
+}
+
+// markdownDestination formats a link destination, wrapping it in angle
+// brackets when it contains characters that would end it early
+func markdownDestination(url string) string {
+	if strings.ContainsAny(url, " ()<>") {
+		return "<" + strings.NewReplacer("<", "%3C", ">", "%3E").Replace(url) + ">"
+	}
+	return url
+}
+
+// escapeBlockStart escapes text that would otherwise start a heading, list
+// or setext underline
+func escapeBlockStart(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if loc := blockStartPattern.FindStringSubmatchIndex(line); loc != nil {
+			indent := line[loc[2]:loc[3]]
+			rest := line[loc[3]:]
+			if n := strings.IndexAny(rest, ".)"); n > 0 && rest[0] >= '0' && rest[0] <= '9' {
+				lines[i] = indent + rest[:n] + `\` + rest[n:]
+			} else {
+				lines[i] = indent + `\` + rest
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// prefixLines prefixes the first line with first and the remaining
+// non-empty lines with rest
+func prefixLines(text, first, rest string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		switch {
+		case i == 0:
+			lines[i] = first + line
+		case line == "":
+			lines[i] = strings.TrimRight(rest, " ")
+		default:
+			lines[i] = rest + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// flattenInline returns the inline descendants of a node, inserting hard
+// breaks between blocks
+func flattenInline(node map[string]interface{}) []map[string]interface{} {
+	switch nodeType(node) {
+	case "text", "hardBreak", "mention":
+		return []map[string]interface{}{node}
+	}
+	var out []map[string]interface{}
+	for i, child := range nodeChildren(node) {
+		if i > 0 && nodeType(child) != "text" && nodeType(child) != "hardBreak" && nodeType(child) != "mention" {
+			out = append(out, map[string]interface{}{"type": "hardBreak"})
+		}
+		out = append(out, flattenInline(child)...)
+	}
+	return out
+}
+
+func linkHref(node map[string]interface{}) (string, bool) {
+	if nodeType(node) != "text" {
+		return "", false
+	}
+	marks, _ := node["marks"].([]interface{})
+	for _, raw := range marks {
+		mark, _ := raw.(map[string]interface{})
+		if t, _ := mark["type"].(string); t == "link" {
+			attrs, _ := mark["attrs"].(map[string]interface{})
+			href, _ := attrs["href"].(string)
+			return href, href != ""
+		}
+	}
+	return "", false
+}
+
+func nodeType(node map[string]interface{}) string {
+	t, _ := node["type"].(string)
+	return t
+}
+
+func nodeAttrs(node map[string]interface{}) map[string]interface{} {
+	attrs, _ := node["attrs"].(map[string]interface{})
+	return attrs
+}
+
+func nodeChildren(node map[string]interface{}) []map[string]interface{} {
+	raw, _ := node["content"].([]interface{})
+	children := make([]map[string]interface{}, 0, len(raw))
+	for _, child := range raw {
+		if m, ok := child.(map[string]interface{}); ok {
+			children = append(children, m)
+		}
+	}
+	return children
+}
+
+func nodeMarks(node map[string]interface{}) []string {
+	raw, _ := node["marks"].([]interface{})
+	marks := make([]string, 0, len(raw))
+	for _, m := range raw {
+		if mark, ok := m.(map[string]interface{}); ok {
+			if t, ok := mark["type"].(string); ok {
+				marks = append(marks, t)
+			}
+		}
+	}
+	return marks
+}
+
+func nodeTextValue(node map[string]interface{}) string {
+	text, _ := node["text"].(string)
+	return text
+}
+
+// nodeText returns the concatenated text of a node and its descendants
+func nodeText(node map[string]interface{}) string {
+	if nodeType(node) == "text" {
+		return nodeTextValue(node)
+	}
+	if nodeType(node) == "hardBreak" {
+		return "\n"
+	}
+	var b strings.Builder
+	for _, child := range nodeChildren(node) {
+		b.WriteString(nodeText(child))
+	}
+	return b.String()
+}
+
+func stringAttr(attrs map[string]interface{}, name string) string {
+	s, _ := attrs[name].(string)
+	return s
+}
+
+func intAttr(attrs map[string]interface{}, name string, fallback int) int {
+	switch v := attrs[name].(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	}
+	return fallback
+}
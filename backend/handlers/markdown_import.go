@@ -0,0 +1,393 @@
+package handlers
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// markdownImporter converts GFM documents into TipTap JSON
+type markdownImporter struct {
+	source []byte
+	// resolveImage is called for each image destination and may return
+	// attributes (such as an uploaded image's src and image_id) that
+	// replace the defaults
+	resolveImage func(destination string) map[string]interface{}
+}
+
+// markdownParser parses CommonMark with the GFM extensions
+var markdownParser = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// Convert parses Markdown and returns a doc node. A leading level 1
+// heading is removed from the document and returned as the title.
+func (m *markdownImporter) Convert(source []byte) (string, map[string]interface{}) {
+	m.source = source
+	root := markdownParser.Parser().Parse(text.NewReader(source))
+
+	title := ""
+	if heading, ok := root.FirstChild().(*ast.Heading); ok && heading.Level == 1 {
+		title = strings.TrimSpace(m.plainText(heading))
+		root.RemoveChild(root, heading)
+	}
+
+	doc := map[string]interface{}{
+		"type":    "doc",
+		"content": m.blocks(root),
+	}
+	return title, doc
+}
+
+// blocks converts the block children of a node
+func (m *markdownImporter) blocks(parent ast.Node) []interface{} {
+	out := make([]interface{}, 0)
+	for n := parent.FirstChild(); n != nil; n = n.NextSibling() {
+		out = append(out, m.block(n)...)
+	}
+	return out
+}
+
+func (m *markdownImporter) block(n ast.Node) []interface{} {
+	switch node := n.(type) {
+	case *ast.Heading:
+		return []interface{}{tiptapNode("heading", map[string]interface{}{"level": node.Level}, m.inlines(node, nil))}
+	case *ast.Paragraph, *ast.TextBlock:
+		return m.paragraphs(node)
+	case *ast.List:
+		return []interface{}{m.list(node)}
+	case *ast.Blockquote:
+		content := m.blocks(node)
+		if len(content) == 0 {
+			content = []interface{}{tiptapNode("paragraph", nil, nil)}
+		}
+		return []interface{}{tiptapNode("blockquote", nil, content)}
+	case *ast.FencedCodeBlock:
+		var attrs map[string]interface{}
+		if lang := string(node.Language(m.source)); lang != "" {
+			attrs = map[string]interface{}{"language": lang}
+		}
+		return []interface{}{tiptapNode("codeBlock", attrs, textContent(m.lines(node), nil))}
+	case *ast.CodeBlock:
+		return []interface{}{tiptapNode("codeBlock", nil, textContent(m.lines(node), nil))}
+	case *ast.HTMLBlock:
+		// Raw HTML is kept as visible text rather than interpreted
+		raw := strings.TrimRight(m.lines(node), "\n")
+		if raw == "" {
+			return nil
+		}
+		return []interface{}{tiptapNode("paragraph", nil, textContent(raw, nil))}
+	case *ast.ThematicBreak:
+		return []interface{}{tiptapNode("horizontalRule", nil, nil)}
+	case *east.Table:
+		return []interface{}{m.table(node)}
+	default:
+		return m.blocks(node)
+	}
+}
+
+// paragraphs converts a paragraph, splitting it around images because
+// images are block nodes in the editor
+func (m *markdownImporter) paragraphs(n ast.Node) []interface{} {
+	var out []interface{}
+	var current []interface{}
+	flush := func() {
+		if len(current) > 0 && !onlyWhitespace(current) {
+			out = append(out, tiptapNode("paragraph", nil, trimInline(current)))
+		}
+		current = nil
+	}
+
+	for _, child := range m.inlines(n, nil) {
+		node := child.(map[string]interface{})
+		if nodeType(node) == "resizableImage" {
+			flush()
+			out = append(out, node)
+			continue
+		}
+		current = append(current, node)
+	}
+	flush()
+
+	if len(out) == 0 {
+		return []interface{}{tiptapNode("paragraph", nil, nil)}
+	}
+	return out
+}
+
+func (m *markdownImporter) list(node *ast.List) map[string]interface{} {
+	isTask := false
+	for item := node.FirstChild(); item != nil; item = item.NextSibling() {
+		if taskCheckBox(item) != nil {
+			isTask = true
+			break
+		}
+	}
+
+	items := make([]interface{}, 0)
+	for item := node.FirstChild(); item != nil; item = item.NextSibling() {
+		content := m.blocks(item)
+		if len(content) == 0 {
+			content = []interface{}{tiptapNode("paragraph", nil, nil)}
+		}
+		if isTask {
+			checked := false
+			if box := taskCheckBox(item); box != nil {
+				checked = box.IsChecked
+			}
+			items = append(items, tiptapNode("taskItem", map[string]interface{}{"checked": checked}, content))
+		} else {
+			items = append(items, tiptapNode("listItem", nil, content))
+		}
+	}
+
+	switch {
+	case isTask:
+		return tiptapNode("taskList", nil, items)
+	case node.IsOrdered():
+		var attrs map[string]interface{}
+		if node.Start != 1 {
+			attrs = map[string]interface{}{"start": node.Start}
+		}
+		return tiptapNode("orderedList", attrs, items)
+	default:
+		return tiptapNode("bulletList", nil, items)
+	}
+}
+
+func (m *markdownImporter) table(node *east.Table) map[string]interface{} {
+	rows := make([]interface{}, 0)
+	for row := node.FirstChild(); row != nil; row = row.NextSibling() {
+		cellType := "tableCell"
+		if _, ok := row.(*east.TableHeader); ok {
+			cellType = "tableHeader"
+		}
+
+		cells := make([]interface{}, 0)
+		for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			paragraph := tiptapNode("paragraph", nil, trimInline(m.inlines(cell, nil)))
+			cells = append(cells, tiptapNode(cellType, nil, []interface{}{paragraph}))
+		}
+		rows = append(rows, tiptapNode("tableRow", nil, cells))
+	}
+	return tiptapNode("table", nil, rows)
+}
+
+// inlines converts inline children, carrying the marks of enclosing
+// emphasis, strikethrough and link nodes
+func (m *markdownImporter) inlines(parent ast.Node, marks []interface{}) []interface{} {
+	out := make([]interface{}, 0)
+	for c := parent.FirstChild(); c != nil; c = c.NextSibling() {
+		switch node := c.(type) {
+		case *ast.Text:
+			value := node.Segment.Value(m.source)
+			if !node.IsRaw() {
+				value = unescapeMarkdown(value)
+			}
+			out = append(out, textContent(string(value), marks)...)
+			if node.HardLineBreak() {
+				out = append(out, tiptapNode("hardBreak", nil, nil))
+			} else if node.SoftLineBreak() {
+				out = append(out, textContent(" ", marks)...)
+			}
+		case *ast.String:
+			out = append(out, textContent(string(node.Value), marks)...)
+		case *ast.CodeSpan:
+			out = append(out, textContent(m.plainText(node), withMark(marks, map[string]interface{}{"type": "code"}))...)
+		case *ast.Emphasis:
+			markType := "italic"
+			if node.Level >= 2 {
+				markType = "bold"
+			}
+			out = append(out, m.inlines(node, withMark(marks, map[string]interface{}{"type": markType}))...)
+		case *east.Strikethrough:
+			out = append(out, m.inlines(node, withMark(marks, map[string]interface{}{"type": "strike"}))...)
+		case *ast.Link:
+			out = append(out, m.inlines(node, withMark(marks, linkMark(string(node.Destination))))...)
+		case *ast.AutoLink:
+			href := string(node.URL(m.source))
+			if node.AutoLinkType == ast.AutoLinkEmail && !strings.HasPrefix(strings.ToLower(href), "mailto:") {
+				href = "mailto:" + href
+			}
+			out = append(out, textContent(string(node.Label(m.source)), withMark(marks, linkMark(href)))...)
+		case *ast.Image:
+			out = append(out, m.image(node))
+		case *ast.RawHTML:
+			var b strings.Builder
+			for i := 0; i < node.Segments.Len(); i++ {
+				segment := node.Segments.At(i)
+				b.Write(segment.Value(m.source))
+			}
+			out = append(out, textContent(b.String(), marks)...)
+		case *east.TaskCheckBox:
+			// Rendered as the taskItem's checked attribute instead
+		default:
+			out = append(out, m.inlines(node, marks)...)
+		}
+	}
+	return mergeText(out)
+}
+
+func (m *markdownImporter) image(node *ast.Image) map[string]interface{} {
+	destination := string(node.Destination)
+	attrs := map[string]interface{}{"src": destination}
+	if alt := m.plainText(node); alt != "" {
+		attrs["alt"] = alt
+	}
+	if len(node.Title) > 0 {
+		attrs["title"] = string(unescapeMarkdown(node.Title))
+	}
+	if m.resolveImage != nil {
+		for key, value := range m.resolveImage(destination) {
+			attrs[key] = value
+		}
+	}
+	return tiptapNode("resizableImage", attrs, nil)
+}
+
+// lines returns the raw source lines of a block, such as a code block
+func (m *markdownImporter) lines(n ast.Node) string {
+	var b strings.Builder
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		segment := lines.At(i)
+		b.Write(segment.Value(m.source))
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// plainText returns the text content of an inline node, with escapes
+// resolved outside of code spans
+func (m *markdownImporter) plainText(n ast.Node) string {
+	var b strings.Builder
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		switch node := c.(type) {
+		case *ast.Text:
+			value := node.Segment.Value(m.source)
+			if !node.IsRaw() {
+				value = unescapeMarkdown(value)
+			}
+			b.Write(value)
+			if node.SoftLineBreak() || node.HardLineBreak() {
+				b.WriteString(" ")
+			}
+		case *ast.String:
+			b.Write(node.Value)
+		default:
+			b.WriteString(m.plainText(node))
+		}
+	}
+	return b.String()
+}
+
+// taskCheckBox returns the checkbox at the start of a GFM task list item
+func taskCheckBox(item ast.Node) *east.TaskCheckBox {
+	first := item.FirstChild()
+	if first == nil {
+		return nil
+	}
+	box, _ := first.FirstChild().(*east.TaskCheckBox)
+	return box
+}
+
+// unescapeMarkdown resolves backslash escapes and character references
+func unescapeMarkdown(value []byte) []byte {
+	value = util.UnescapePunctuations(value)
+	value = util.ResolveNumericReferences(value)
+	return util.ResolveEntityNames(value)
+}
+
+func tiptapNode(nodeType string, attrs map[string]interface{}, content []interface{}) map[string]interface{} {
+	node := map[string]interface{}{"type": nodeType}
+	if len(attrs) > 0 {
+		node["attrs"] = attrs
+	}
+	if len(content) > 0 {
+		node["content"] = content
+	}
+	return node
+}
+
+// textContent returns a text node, or nothing for empty text which
+// ProseMirror does not allow
+func textContent(value string, marks []interface{}) []interface{} {
+	if value == "" {
+		return nil
+	}
+	node := map[string]interface{}{"type": "text", "text": value}
+	if len(marks) > 0 {
+		node["marks"] = marks
+	}
+	return []interface{}{node}
+}
+
+// mergeText joins adjacent text nodes that carry the same marks
+func mergeText(nodes []interface{}) []interface{} {
+	out := make([]interface{}, 0, len(nodes))
+	for _, n := range nodes {
+		node := n.(map[string]interface{})
+		if len(out) > 0 {
+			prev := out[len(out)-1].(map[string]interface{})
+			if nodeType(prev) == "text" && nodeType(node) == "text" && reflect.DeepEqual(prev["marks"], node["marks"]) {
+				prev["text"] = nodeTextValue(prev) + nodeTextValue(node)
+				continue
+			}
+		}
+		out = append(out, node)
+	}
+	return out
+}
+
+func withMark(marks []interface{}, mark map[string]interface{}) []interface{} {
+	out := make([]interface{}, 0, len(marks)+1)
+	out = append(out, marks...)
+	return append(out, mark)
+}
+
+func linkMark(href string) map[string]interface{} {
+	return map[string]interface{}{"type": "link", "attrs": map[string]interface{}{"href": href}}
+}
+
+// trimInline removes whitespace left at the edges of a paragraph after
+// images are split out of it
+func trimInline(nodes []interface{}) []interface{} {
+	for len(nodes) > 0 {
+		first := nodes[0].(map[string]interface{})
+		if nodeType(first) != "text" {
+			break
+		}
+		trimmed := strings.TrimLeft(nodeTextValue(first), " \t")
+		if trimmed != "" {
+			first["text"] = trimmed
+			break
+		}
+		nodes = nodes[1:]
+	}
+	for len(nodes) > 0 {
+		last := nodes[len(nodes)-1].(map[string]interface{})
+		if nodeType(last) != "text" {
+			break
+		}
+		trimmed := strings.TrimRight(nodeTextValue(last), " \t")
+		if trimmed != "" {
+			last["text"] = trimmed
+			break
+		}
+		nodes = nodes[:len(nodes)-1]
+	}
+	return nodes
+}
+
+func onlyWhitespace(nodes []interface{}) bool {
+	for _, n := range nodes {
+		node := n.(map[string]interface{})
+		if nodeType(node) != "text" || strings.TrimSpace(nodeTextValue(node)) != "" {
+			return false
+		}
+	}
+	return true
+}
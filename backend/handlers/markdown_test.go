@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// markdownRoundTrip exports a document to Markdown and imports it again
+func markdownRoundTrip(t *testing.T, doc string) (string, string) {
+	t.Helper()
+	var node map[string]interface{}
+	if err := json.Unmarshal([]byte(doc), &node); err != nil {
+		t.Fatalf("invalid test document: %v", err)
+	}
+	markdown := (&markdownExporter{}).Convert(node)
+	_, imported := (&markdownImporter{}).Convert([]byte(markdown))
+	got, err := json.Marshal(imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return markdown, string(got)
+}
+
+// canonicalJSON re-encodes JSON with sorted keys
+func canonicalJSON(t *testing.T, doc string) string {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(doc), &v); err != nil {
+		t.Fatalf("invalid test document: %v", err)
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestMarkdownRoundTrip(t *testing.T) {
+	tests := map[string]string{
+		"headings and paragraphs": `{"type":"doc","content":[
+			{"type":"heading","attrs":{"level":2},"content":[{"type":"text","text":"Section"}]},
+			{"type":"paragraph","content":[{"type":"text","text":"Plain text"}]}]}`,
+		"marks": `{"type":"doc","content":[{"type":"paragraph","content":[
+			{"type":"text","text":"bold","marks":[{"type":"bold"}]},
+			{"type":"text","text":" and "},
+			{"type":"text","text":"italic","marks":[{"type":"italic"}]},
+			{"type":"text","text":" and "},
+			{"type":"text","text":"struck","marks":[{"type":"strike"}]},
+			{"type":"text","text":" and "},
+			{"type":"text","text":"a ` + "`" + `tick","marks":[{"type":"code"}]}]}]}`,
+		"escaped text": `{"type":"doc","content":[
+			{"type":"paragraph","content":[{"type":"text","text":"# not a heading *or* [link](x) <b> a|b ~c~ \\ _u_"}]},
+			{"type":"paragraph","content":[{"type":"text","text":"1. not a list"}]},
+			{"type":"paragraph","content":[{"type":"text","text":"- not a bullet"}]}]}`,
+		"link": `{"type":"doc","content":[{"type":"paragraph","content":[
+			{"type":"text","text":"see "},
+			{"type":"text","text":"the docs","marks":[{"type":"link","attrs":{"href":"https://example.com/a b?x=(1)"}}]}]}]}`,
+		"lists": `{"type":"doc","content":[
+			{"type":"bulletList","content":[
+				{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"one"}]},
+					{"type":"bulletList","content":[{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"nested"}]}]}]}]},
+				{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"two"}]}]}]},
+			{"type":"orderedList","attrs":{"start":3},"content":[
+				{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"three"}]}]}]}]}`,
+		"tasks": `{"type":"doc","content":[{"type":"taskList","content":[
+			{"type":"taskItem","attrs":{"checked":true},"content":[{"type":"paragraph","content":[{"type":"text","text":"done"}]}]},
+			{"type":"taskItem","attrs":{"checked":false},"content":[{"type":"paragraph","content":[{"type":"text","text":"todo"}]}]}]}]}`,
+		"code block": `{"type":"doc","content":[{"type":"codeBlock","attrs":{"language":"go"},"content":[{"type":"text","text":"x := 1\n` + "```" + `\nfmt.Println(x)"}]}]}`,
+		"blockquote and rule": `{"type":"doc","content":[
+			{"type":"blockquote","content":[{"type":"paragraph","content":[{"type":"text","text":"quoted"}]}]},
+			{"type":"horizontalRule"},
+			{"type":"paragraph","content":[{"type":"text","text":"after"}]}]}`,
+		"code with escapes": `{"type":"doc","content":[{"type":"paragraph","content":[
+			{"type":"text","text":"C:\\dir\\*.md","marks":[{"type":"code"}]}]}]}`,
+		"image": `{"type":"doc","content":[{"type":"resizableImage","attrs":{"src":"/api/img/images/a b.png","alt":"A [diagram]","title":"Say \"hi\""}}]}`,
+	}
+
+	for name, doc := range tests {
+		t.Run(name, func(t *testing.T) {
+			markdown, got := markdownRoundTrip(t, doc)
+			if want := canonicalJSON(t, doc); got != want {
+				t.Errorf("round trip through\n%s\ngot  %s\nwant %s", markdown, got, want)
+			}
+		})
+	}
+}
+
+func TestMarkdownImportTitle(t *testing.T) {
+	title, doc := (&markdownImporter{}).Convert([]byte("# Notes on \\*stars\\* & `a\\b`\n\nBody\n"))
+	if want := `Notes on *stars* & a\b`; title != want {
+		t.Errorf("title = %q, want %q", title, want)
+	}
+	if content, _ := doc["content"].([]interface{}); len(content) != 1 {
+		t.Errorf("doc has %d blocks, want the heading removed", len(content))
+	}
+}
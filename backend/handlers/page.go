@@ -1,25 +1,202 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"simultaneous-memo-app/backend/models"
 
 	"github.com/labstack/echo/v4"
+	"gorm.io/datatypes"
 )
 
-// GetPages retrieves all pages
+// pageListParams are the query parameters that switch GetPages from the
+// legacy full listing to a paginated one
+var pageListParams = []string{"limit", "cursor", "sort", "order", "from", "to", "date_field", "fields", "template", "tag"}
+
+// GetPages retrieves pages. Without query parameters every page is returned
+// with its content. Otherwise pages are paginated with a cursor and can be
+// sorted, filtered by date and reduced to summaries with fields=summary.
 func (h *Handler) GetPages(c echo.Context) error {
-	pages, err := models.GetAllPages(h.db)
+	paginated := false
+	for _, name := range pageListParams {
+		if c.QueryParam(name) != "" {
+			paginated = true
+			break
+		}
+	}
+
+	if !paginated {
+		pages, err := models.GetAllPages(h.db)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to retrieve pages",
+			})
+		}
+
+		return c.JSON(http.StatusOK, pages)
+	}
+
+	opts := models.PageListOptions{
+		Limit:     50,
+		Sort:      models.PageSortUpdated,
+		DateField: models.PageSortUpdated,
+		Cursor:    c.QueryParam("cursor"),
+	}
+	if l := c.QueryParam("limit"); l != "" {
+		if limitNum, err := strconv.Atoi(l); err == nil && limitNum > 0 && limitNum <= 100 {
+			opts.Limit = limitNum
+		}
+	}
+	if sort := c.QueryParam("sort"); sort != "" {
+		if !models.IsValidPageSort(sort) {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid sort, expected updated, created or title",
+			})
+		}
+		opts.Sort = sort
+	}
+
+	// Dates default to newest first, titles to alphabetical order
+	opts.Desc = opts.Sort != models.PageSortTitle
+	switch c.QueryParam("order") {
+	case "":
+	case "asc":
+		opts.Desc = false
+	case "desc":
+		opts.Desc = true
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid order, expected asc or desc",
+		})
+	}
+
+	switch dateField := c.QueryParam("date_field"); dateField {
+	case "", models.PageSortUpdated:
+	case models.PageSortCreated:
+		opts.DateField = dateField
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid date_field, expected updated or created",
+		})
+	}
+
+	var err error
+	if opts.From, err = parseListDate(c.QueryParam("from"), false); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid from date",
+		})
+	}
+	if opts.To, err = parseListDate(c.QueryParam("to"), true); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid to date",
+		})
+	}
+
+	switch c.QueryParam("template") {
+	case "":
+	case "true", "false":
+		isTemplate := c.QueryParam("template") == "true"
+		opts.IsTemplate = &isTemplate
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid template, expected true or false",
+		})
+	}
+
+	// Tags may be repeated or comma separated
+	for _, value := range c.QueryParams()["tag"] {
+		for _, name := range strings.Split(value, ",") {
+			if strings.TrimSpace(name) == "" {
+				continue
+			}
+			tag, err := models.NormalizeTagName(name)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"error": "Invalid tag",
+				})
+			}
+			if !slices.Contains(opts.Tags, tag) {
+				opts.Tags = append(opts.Tags, tag)
+			}
+		}
+	}
+	switch c.QueryParam("tag_mode") {
+	case "", "and":
+	case "or":
+		opts.TagsAny = true
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid tag_mode, expected and or or",
+		})
+	}
+
+	summary := false
+	switch c.QueryParam("fields") {
+	case "", "full":
+	case "summary":
+		summary = true
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid fields, expected full or summary",
+		})
+	}
+
+	opts.Summary = summary
+	pages, nextCursor, err := models.ListPages(h.db, opts)
+	if errors.Is(err, models.ErrInvalidCursor) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid cursor",
+		})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to retrieve pages",
 		})
 	}
 
-	return c.JSON(http.StatusOK, pages)
+	var items interface{} = pages
+	if summary {
+		summaries := make([]models.PageSummary, len(pages))
+		for i := range pages {
+			summaries[i] = pages[i].ToSummary()
+		}
+		items = summaries
+	} else if pages == nil {
+		items = []models.Page{}
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"pages":       items,
+		"limit":       opts.Limit,
+		"next_cursor": nextCursor,
+		"has_more":    nextCursor != "",
+	})
+}
+
+// parseListDate parses a date filter given as RFC 3339 or YYYY-MM-DD. A
+// date-only upper bound covers the whole day.
+func parseListDate(value string, endOfDay bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
 }
 
 // GetPage retrieves a single page by ID
@@ -50,22 +227,35 @@ func (h *Handler) CreatePage(c echo.Context) error {
 		})
 	}
 
+	// Pages are published through the publish endpoint
+	page.PublishSlug = nil
+	page.PublishedAt = nil
+
 	// Set default content if not provided
 	if page.Content == nil {
 		page.Content = []byte(`{"doc":{"type":"doc","content":[]}}`)
 	}
 
+	// Validate and sanitize the document before storing it
+	content, err := models.ValidatePageContent(page.Content)
+	if err != nil {
+		return invalidContentResponse(c, err)
+	}
+	page.Content = content
+
+	// Validate properties against the collection's schema
+	if page.Properties, err = h.validatePageProperties(page.CollectionID, page.Properties); err != nil {
+		return invalidPropertiesResponse(c, err)
+	}
+
 	if err := models.CreatePage(h.db, &page); err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to create page",
 		})
 	}
 
-	// Update image references
-	if err := models.UpdateImageReferences(h.db, page.ID, page.Content); err != nil {
-		// Log error but don't fail the request
-		fmt.Printf("画像参照の更新エラー: %v\n", err)
-	}
+	// Update image references and page links
+	h.updateContentReferences(page.ID, page.Content)
 
 	return c.JSON(http.StatusCreated, page)
 }
@@ -86,20 +276,87 @@ func (h *Handler) UpdatePage(c echo.Context) error {
 		})
 	}
 
+	// Publishing is only changed through the publish endpoints
+	delete(updates, "publish_slug")
+	delete(updates, "published_at")
+
+	// The editor of the request is recorded, and an update without one
+	// clears the previous editor so it is not credited to them
+	editor, _ := updates["editor"].(string)
+	delete(updates, "editor")
+	updates["updated_by"] = strings.TrimSpace(editor)
+
+	// Validate and sanitize the document if content is being replaced
+	var contentJSON datatypes.JSON
+	if rawContent, ok := updates["content"]; ok {
+		encoded, err := json.Marshal(rawContent)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+		}
+		contentJSON, err = models.ValidatePageContent(encoded)
+		if err != nil {
+			return invalidContentResponse(c, err)
+		}
+		updates["content"] = contentJSON
+	}
+
+	// Validate properties if they or the page's collection are changing
+	_, hasProperties := updates["properties"]
+	_, hasCollection := updates["collection_id"]
+	if hasProperties || hasCollection {
+		current, err := models.GetPageByID(h.db, uint(id))
+		if err != nil {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": "Page not found",
+			})
+		}
+
+		collectionID := current.CollectionID
+		properties := current.Properties
+		if hasCollection {
+			collectionID = nil
+			if raw, ok := updates["collection_id"].(float64); ok && raw > 0 {
+				cid := uint(raw)
+				collectionID = &cid
+			} else if updates["collection_id"] != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"error": "Invalid collection_id",
+				})
+			}
+			// Values from another collection's schema do not carry over
+			properties = nil
+			if collectionID == nil {
+				updates["collection_id"] = nil
+			} else {
+				updates["collection_id"] = *collectionID
+			}
+		}
+		if hasProperties {
+			if properties, err = json.Marshal(updates["properties"]); err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"error": "Invalid request body",
+				})
+			}
+		}
+
+		validated, err := h.validatePageProperties(collectionID, properties)
+		if err != nil {
+			return invalidPropertiesResponse(c, err)
+		}
+		updates["properties"] = validated
+	}
+
 	if err := models.UpdatePage(h.db, uint(id), updates); err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to update page",
 		})
 	}
 
-	// Update image references if content was updated
-	if content, ok := updates["content"]; ok {
-		if contentJSON, ok := content.([]byte); ok {
-			if err := models.UpdateImageReferences(h.db, uint(id), contentJSON); err != nil {
-				// Log error but don't fail the request
-				fmt.Printf("画像参照の更新エラー: %v\n", err)
-			}
-		}
+	// Update image references and page links if content was updated
+	if contentJSON != nil {
+		h.updateContentReferences(uint(id), contentJSON)
 	}
 
 	page, err := models.GetPageByID(h.db, uint(id))
@@ -122,7 +379,7 @@ func (h *Handler) DeletePage(c echo.Context) error {
 	}
 
 	// Delete associated images first
-	if err := DeleteImagesByPageID(h.db, uint(id)); err != nil {
+	if err := DeleteImagesByPageID(h.db, h.store, uint(id)); err != nil {
 		// Log error but continue with page deletion
 		fmt.Printf("ページ %d の画像削除エラー: %v\n", id, err)
 	}
@@ -137,4 +394,64 @@ func (h *Handler) DeletePage(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{
 		"message": "ページと関連画像を削除しました",
 	})
-}
\ No newline at end of file
+}
+
+// validatePageProperties checks property values against the schema of the
+// page's collection, which must exist
+func (h *Handler) validatePageProperties(collectionID *uint, properties datatypes.JSON) (datatypes.JSON, error) {
+	var collection *models.Collection
+	if collectionID != nil {
+		var err error
+		if collection, err = models.GetCollectionByID(h.db, *collectionID); err != nil {
+			return nil, &models.PropertyValidationError{Property: "collection_id", Message: "collection not found"}
+		}
+	}
+	return models.ValidateProperties(collection, properties)
+}
+
+// invalidPropertiesResponse reports a property validation failure
+func invalidPropertiesResponse(c echo.Context, err error) error {
+	var validationErr *models.PropertyValidationError
+	if errors.As(err, &validationErr) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":    "Invalid page properties",
+			"property": validationErr.Property,
+			"details":  validationErr.Message,
+		})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{
+		"error": "Failed to validate page properties",
+	})
+}
+
+// updateContentReferences refreshes the data derived from a page's content.
+// Errors are logged rather than failing the request, since the page itself
+// has already been saved.
+func (h *Handler) updateContentReferences(pageID uint, content datatypes.JSON) {
+	if err := models.UpdateContentIndexes(h.db, pageID, content); err != nil {
+		fmt.Printf("コンテンツ索引の更新エラー: %v\n", err)
+	}
+	moved, err := models.ReanchorComments(h.db, pageID, content)
+	if err != nil {
+		fmt.Printf("コメント位置の更新エラー: %v\n", err)
+	}
+	for i := range moved {
+		h.notifyComment("comment-updated", &moved[i])
+	}
+}
+
+// invalidContentResponse reports a rejected page document, including the
+// path of the offending node when it is known
+func invalidContentResponse(c echo.Context, err error) error {
+	var validationErr *models.ContentValidationError
+	if errors.As(err, &validationErr) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":   "Invalid page content",
+			"path":    validationErr.Path,
+			"details": validationErr.Message,
+		})
+	}
+	return c.JSON(http.StatusBadRequest, map[string]string{
+		"error": "Invalid page content",
+	})
+}
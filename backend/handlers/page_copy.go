@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"simultaneous-memo-app/backend/models"
+	"simultaneous-memo-app/backend/storage"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// pageContentCopier rewrites content for a new page created from an
+// existing one. Image and File records are copied so that the new page
+// owns its own records; the copies share deduplicated blobs, which are
+// reference counted, so deleting either page cannot break the other's
+// images or attachments.
+type pageContentCopier struct {
+	db    *gorm.DB
+	store storage.Storage
+	// pageID is the new page, which copied files are attached to
+	pageID uint
+	// text optionally rewrites every text node, e.g. to fill in placeholders
+	text func(string) string
+
+	images map[uint]*models.Image
+	files  map[uint]*models.File
+}
+
+func newPageContentCopier(db *gorm.DB, store storage.Storage, pageID uint) *pageContentCopier {
+	return &pageContentCopier{
+		db:     db,
+		store:  store,
+		pageID: pageID,
+		images: make(map[uint]*models.Image),
+		files:  make(map[uint]*models.File),
+	}
+}
+
+// Copy returns a copy of content that references copies of its images.
+// The copied Image records are created without a page; they are claimed by
+// UpdateImageReferences once the new page has been saved.
+func (pc *pageContentCopier) Copy(content datatypes.JSON) (datatypes.JSON, error) {
+	if len(content) == 0 {
+		return content, nil
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, err
+	}
+	root := data
+	if doc, ok := data["doc"].(map[string]interface{}); ok {
+		root = doc
+	}
+
+	if err := pc.walk(root); err != nil {
+		return nil, err
+	}
+	return json.Marshal(data)
+}
+
+// RemoveFiles deletes files copied from images and files stored before
+// deduplication. It is used when the transaction that created their
+// records was rolled back; blob references are rolled back with it.
+func (pc *pageContentCopier) RemoveFiles() {
+	for _, image := range pc.images {
+		if image.Checksum == "" {
+			removeImageFiles(pc.store, image.Path, image.ThumbnailPath)
+		}
+	}
+	for _, file := range pc.files {
+		if file.Checksum == "" {
+			removeFileContent(pc.store, file.Path)
+		}
+	}
+}
+
+func (pc *pageContentCopier) walk(node map[string]interface{}) error {
+	switch nodeType(node) {
+	case "text":
+		if pc.text != nil {
+			text, _ := node["text"].(string)
+			node["text"] = pc.text(text)
+		}
+		return pc.copyFileLinks(node)
+	case "image", "resizableImage":
+		return pc.copyImageNode(node)
+	}
+
+	raw, ok := node["content"].([]interface{})
+	if !ok {
+		return nil
+	}
+	children := make([]interface{}, 0, len(raw))
+	for _, item := range raw {
+		child, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if err := pc.walk(child); err != nil {
+			return err
+		}
+		// Text nodes may not be empty, which a placeholder can leave behind
+		if nodeType(child) == "text" && child["text"] == "" {
+			continue
+		}
+		children = append(children, child)
+	}
+	node["content"] = children
+	return nil
+}
+
+// copyImageNode points an image node at a copy of its uploaded image.
+// External images are left untouched.
+func (pc *pageContentCopier) copyImageNode(node map[string]interface{}) error {
+	attrs := nodeAttrs(node)
+	if attrs == nil {
+		return nil
+	}
+	src := stringAttr(attrs, "src")
+	image := findContentImage(pc.db, attrs, src)
+	if image == nil {
+		return nil
+	}
+
+	imageCopy, ok := pc.images[image.ID]
+	if !ok {
+		var err error
+		imageCopy, err = copyImage(pc.db, pc.store, image)
+		if err != nil {
+			return err
+		}
+		if err := models.CreateImage(pc.db, imageCopy); err != nil {
+			if imageCopy.Checksum == "" {
+				removeImageFiles(pc.store, imageCopy.Path, imageCopy.ThumbnailPath)
+			}
+			return err
+		}
+		pc.images[image.ID] = imageCopy
+	}
+
+	if src != "" {
+		attrs["src"] = strings.Replace(src, image.Path, imageCopy.Path, 1)
+	}
+	if _, ok := attrs["data-image-id"]; ok {
+		attrs["data-image-id"] = strconv.FormatUint(uint64(imageCopy.ID), 10)
+	}
+	// Always record the ID so the new page claims the copy and it is not
+	// removed as an orphan
+	if _, ok := attrs["data-image-id"]; !ok || attrs["image_id"] != nil {
+		attrs["image_id"] = float64(imageCopy.ID)
+	}
+	return nil
+}
+
+// copyFileLinks points link marks at copies of the uploaded files they
+// link to
+func (pc *pageContentCopier) copyFileLinks(node map[string]interface{}) error {
+	marks, _ := node["marks"].([]interface{})
+	for _, raw := range marks {
+		mark, _ := raw.(map[string]interface{})
+		if nodeType(mark) != "link" {
+			continue
+		}
+		attrs := nodeAttrs(mark)
+		href := stringAttr(attrs, "href")
+		filename, ok := uploadedFilename(href, "/api/file/")
+		if !ok {
+			continue
+		}
+
+		var file models.File
+		if err := pc.db.Where("filename = ?", filename).First(&file).Error; err != nil {
+			continue
+		}
+
+		fileCopy, ok := pc.files[file.ID]
+		if !ok {
+			var err error
+			fileCopy, err = copyGeneralFile(pc.db, pc.store, &file)
+			if err != nil {
+				return err
+			}
+			pageID := pc.pageID
+			fileCopy.PageID = &pageID
+			if err := pc.db.Create(fileCopy).Error; err != nil {
+				if fileCopy.Checksum == "" {
+					removeFileContent(pc.store, fileCopy.Path)
+				}
+				return err
+			}
+			pc.files[file.ID] = fileCopy
+		}
+		attrs["href"] = strings.Replace(href, "/api/file/"+file.Filename, "/api/file/"+fileCopy.Filename, 1)
+	}
+	return nil
+}
+
+// remapPageLinks points links and page mentions at other pages copied in
+// the same operation, using a map from original to copied page IDs
+func remapPageLinks(content datatypes.JSON, pageIDs map[uint]uint) (datatypes.JSON, error) {
+	var data map[string]interface{}
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, err
+	}
+	root := data
+	if doc, ok := data["doc"].(map[string]interface{}); ok {
+		root = doc
+	}
+
+	var walk func(node map[string]interface{})
+	walk = func(node map[string]interface{}) {
+		switch nodeType(node) {
+		case "text":
+			marks, _ := node["marks"].([]interface{})
+			for _, raw := range marks {
+				mark, _ := raw.(map[string]interface{})
+				if nodeType(mark) != "link" {
+					continue
+				}
+				attrs := nodeAttrs(mark)
+				href := stringAttr(attrs, "href")
+				if id, ok := models.ParsePageURL(href); ok {
+					if newID, ok := pageIDs[id]; ok {
+						// Keep any fragment pointing into the page
+						u, _ := url.Parse(strings.TrimSpace(href))
+						u.Path = models.PageURL(newID)
+						attrs["href"] = u.String()
+					}
+				}
+			}
+		case "mention":
+			attrs := nodeAttrs(node)
+			if stringAttr(attrs, "type") != "page" {
+				return
+			}
+			switch id := attrs["id"].(type) {
+			case float64:
+				if newID, ok := pageIDs[uint(id)]; ok {
+					attrs["id"] = float64(newID)
+				}
+			case string:
+				oldID, _ := strconv.ParseUint(id, 10, 32)
+				if newID, ok := pageIDs[uint(oldID)]; ok {
+					attrs["id"] = strconv.FormatUint(uint64(newID), 10)
+				}
+			}
+		}
+		for _, child := range nodeChildren(node) {
+			walk(child)
+		}
+	}
+	walk(root)
+
+	return json.Marshal(data)
+}
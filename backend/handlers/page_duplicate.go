@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"simultaneous-memo-app/backend/models"
+	"simultaneous-memo-app/backend/storage"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// DuplicateRequest is the optional body of DuplicatePage
+type DuplicateRequest struct {
+	Title    string `json:"title"`
+	Subpages bool   `json:"subpages"`
+}
+
+// pageDuplicator copies a page and optionally its subpages
+type pageDuplicator struct {
+	tx       *gorm.DB
+	store    storage.Storage
+	subpages bool
+	pages    []*models.Page
+	copiers  []*pageContentCopier
+	// pageIDs maps original page IDs to their copies
+	pageIDs map[uint]uint
+}
+
+// DuplicatePage copies a page's title and content into a new page next to
+// it, optionally together with its subpages. Images and files are copied so
+// that deleting either page leaves the other intact, and links between the
+// copied pages are pointed at the copies.
+func (h *Handler) DuplicatePage(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid page ID",
+		})
+	}
+
+	var req DuplicateRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	source, err := models.GetPageByID(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Page not found",
+		})
+	}
+
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		title = source.Title + " (コピー)"
+	}
+
+	d := &pageDuplicator{store: h.store, subpages: req.Subpages, pageIDs: make(map[uint]uint)}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		d.tx = tx
+		if err := d.duplicate(source, source.ParentID, title); err != nil {
+			return err
+		}
+		return d.finish()
+	})
+	if err != nil {
+		for _, copier := range d.copiers {
+			copier.RemoveFiles()
+		}
+
+		var validationErr *models.ContentValidationError
+		if errors.As(err, &validationErr) {
+			return invalidContentResponse(c, err)
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to duplicate page",
+		})
+	}
+
+	return c.JSON(http.StatusCreated, d.pages[0])
+}
+
+// duplicate copies a page under parentID and then, if requested, its
+// subpages under the copy
+func (d *pageDuplicator) duplicate(source *models.Page, parentID *uint, title string) error {
+	page := &models.Page{
+		Title:        title,
+		ParentID:     parentID,
+		IsTemplate:   source.IsTemplate,
+		CollectionID: source.CollectionID,
+		Properties:   source.Properties,
+	}
+	if err := models.CreatePage(d.tx, page); err != nil {
+		return err
+	}
+	d.pageIDs[source.ID] = page.ID
+
+	copier := newPageContentCopier(d.tx, d.store, page.ID)
+	d.copiers = append(d.copiers, copier)
+	content, err := copier.Copy(source.Content)
+	if err != nil {
+		return err
+	}
+	page.Content = content
+	d.pages = append(d.pages, page)
+
+	if !d.subpages {
+		return nil
+	}
+
+	children, err := models.GetChildPages(d.tx, source.ID)
+	if err != nil {
+		return err
+	}
+	for i := range children {
+		// Guard against parent cycles copying a page twice
+		if _, copied := d.pageIDs[children[i].ID]; copied {
+			continue
+		}
+		if err := d.duplicate(&children[i], &page.ID, children[i].Title); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finish rewrites links between the copied pages, then stores each page's
+// content and the references derived from it
+func (d *pageDuplicator) finish() error {
+	for _, page := range d.pages {
+		if len(page.Content) > 0 {
+			content, err := remapPageLinks(page.Content, d.pageIDs)
+			if err != nil {
+				return err
+			}
+			if page.Content, err = models.ValidatePageContent(content); err != nil {
+				return err
+			}
+		}
+
+		if err := models.UpdatePage(d.tx, page.ID, map[string]interface{}{"content": page.Content}); err != nil {
+			return err
+		}
+		if err := models.UpdateContentIndexes(d.tx, page.ID, page.Content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"simultaneous-memo-app/backend/models"
+	"simultaneous-memo-app/backend/storage"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// exportAsset is a binary included in an export bundle
+type exportAsset struct {
+	archivePath string
+	// source identifies the stored content in logs and deduplication
+	source string
+	open   func() (storage.Object, error)
+}
+
+// exportBundle resolves image and file references while a page is being
+// converted, and records which binaries need to be bundled
+type exportBundle struct {
+	h       *Handler
+	baseURL string
+	bundle  bool
+	assets  []exportAsset
+	names   map[string]string
+}
+
+// ExportPage exports a page as Markdown, optionally bundled with its
+// images and files in a zip archive
+func (h *Handler) ExportPage(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid page ID",
+		})
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "markdown"
+	}
+	if format != "markdown" && format != "md" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Unsupported export format",
+		})
+	}
+
+	bundleMode := c.QueryParam("bundle")
+	if bundleMode != "" && bundleMode != "zip" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Unsupported bundle type",
+		})
+	}
+
+	page, err := models.GetPageByID(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Page not found",
+		})
+	}
+
+	doc, err := models.ParseDocument(page.Content)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to parse page content",
+		})
+	}
+
+	bundle := &exportBundle{
+		h:       h,
+		baseURL: getBaseURL(c),
+		bundle:  bundleMode == "zip",
+		names:   make(map[string]string),
+	}
+	exporter := &markdownExporter{
+		imageURL: bundle.imageURL,
+		linkURL:  bundle.linkURL,
+	}
+
+	markdown := exporter.Convert(doc)
+	if page.Title != "" {
+		markdown = "# " + strings.ReplaceAll(page.Title, "\n", " ") + "\n\n" + markdown
+	}
+
+	baseName := exportFilename(page.Title, page.ID)
+	if !bundle.bundle {
+		c.Response().Header().Set("Content-Disposition", contentDisposition("attachment", baseName+".md"))
+		return c.Blob(http.StatusOK, "text/markdown; charset=utf-8", []byte(markdown))
+	}
+
+	c.Response().Header().Set("Content-Type", "application/zip")
+	c.Response().Header().Set("Content-Disposition", contentDisposition("attachment", baseName+".zip"))
+	c.Response().WriteHeader(http.StatusOK)
+
+	zw := zip.NewWriter(c.Response())
+	w, err := zw.Create(baseName + ".md")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, markdown); err != nil {
+		return err
+	}
+	for _, asset := range bundle.assets {
+		if err := addFileToZip(zw, asset.archivePath, asset.open); err != nil {
+			// The response has already started, so the best we can do is log
+			fmt.Printf("エクスポートへのファイル追加エラー %s: %v\n", asset.source, err)
+		}
+	}
+	return zw.Close()
+}
+
+// imageURL resolves an image node to an uploaded Image. When bundling, the
+// image is added to the archive and a relative path is returned.
+func (b *exportBundle) imageURL(attrs map[string]interface{}) string {
+	src := stringAttr(attrs, "src")
+	image := b.findImage(attrs, src)
+	if image == nil || !b.bundle {
+		return b.absoluteURL(src)
+	}
+
+	key := models.ImageKey(image.Path)
+	return b.addAsset("images", image.Filename, key, func() (storage.Object, error) {
+		return b.h.store.Get(context.Background(), key)
+	}, src)
+}
+
+// linkURL resolves links to uploaded files. When bundling, the file is added
+// to the archive and a relative path is returned.
+func (b *exportBundle) linkURL(href string) string {
+	if !b.bundle {
+		return b.absoluteURL(href)
+	}
+
+	filename, ok := uploadedFilename(href, "/api/file/")
+	if !ok {
+		return b.absoluteURL(href)
+	}
+
+	var file models.File
+	if err := b.h.db.Where("filename = ?", filename).First(&file).Error; err != nil {
+		return b.absoluteURL(href)
+	}
+	return b.addAsset("files", file.Filename, file.Path, func() (storage.Object, error) {
+		return openFileContent(context.Background(), b.h.store, file.Path)
+	}, href)
+}
+
+// addAsset registers a binary for the archive and returns its relative
+// path, falling back to the original URL when the file is missing from
+// storage
+func (b *exportBundle) addAsset(dir, filename, source string, open func() (storage.Object, error), original string) string {
+	if archivePath, ok := b.names[source]; ok {
+		return archivePath
+	}
+	content, err := open()
+	if err != nil {
+		return b.absoluteURL(original)
+	}
+	content.Close()
+
+	archivePath := dir + "/" + filename
+	for i := 2; b.archivePathTaken(archivePath); i++ {
+		ext := filepath.Ext(filename)
+		archivePath = fmt.Sprintf("%s/%s_%d%s", dir, strings.TrimSuffix(filename, ext), i, ext)
+	}
+
+	b.names[source] = archivePath
+	b.assets = append(b.assets, exportAsset{archivePath: archivePath, source: source, open: open})
+	return archivePath
+}
+
+func (b *exportBundle) archivePathTaken(archivePath string) bool {
+	for _, existing := range b.names {
+		if existing == archivePath {
+			return true
+		}
+	}
+	return false
+}
+
+// findImage looks up the Image behind an image node
+func (b *exportBundle) findImage(attrs map[string]interface{}, src string) *models.Image {
+	return findContentImage(b.h.db, attrs, src)
+}
+
+// absoluteURL turns server-relative URLs into absolute ones so exported
+// Markdown keeps working outside the app
+func (b *exportBundle) absoluteURL(u string) string {
+	if strings.HasPrefix(u, "/") && !strings.HasPrefix(u, "//") {
+		return b.baseURL + u
+	}
+	return u
+}
+
+// findContentImage looks up the Image behind an image node, first by its
+// ID attribute and then by the /api/img path in its src
+func findContentImage(db *gorm.DB, attrs map[string]interface{}, src string) *models.Image {
+	if id := imageIDAttr(attrs); id > 0 {
+		if image, err := models.GetImageByID(db, id); err == nil {
+			return image
+		}
+	}
+	if path, ok := uploadedFilename(src, "/api/img"); ok {
+		if image, err := models.GetImageByPath(db, path); err == nil {
+			return image
+		}
+	}
+	return nil
+}
+
+// imageIDAttr reads the image ID stored on an image node, which may be the
+// numeric image_id or the string data-image-id set by the editor
+func imageIDAttr(attrs map[string]interface{}) uint {
+	for _, name := range []string{"image_id", "data-image-id"} {
+		switch v := attrs[name].(type) {
+		case float64:
+			if v > 0 {
+				return uint(v)
+			}
+		case string:
+			if id, err := strconv.ParseUint(v, 10, 32); err == nil && id > 0 {
+				return uint(id)
+			}
+		}
+	}
+	return 0
+}
+
+// uploadedFilename extracts the part of a URL following prefix, ignoring
+// the host, query string and fragment
+func uploadedFilename(rawURL, prefix string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+	idx := strings.Index(u.Path, prefix)
+	if idx < 0 {
+		return "", false
+	}
+	rest := u.Path[idx+len(prefix):]
+	if rest == "" || strings.Contains(rest, "..") {
+		return "", false
+	}
+	return rest, true
+}
+
+// addFileToZip copies stored content into the archive
+func addFileToZip(zw *zip.Writer, archivePath string, open func() (storage.Object, error)) error {
+	src, err := open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	w, err := zw.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, src)
+	return err
+}
+
+// exportFilename builds a safe download name from a page title, keeping
+// non-ASCII characters so Japanese titles stay readable
+func exportFilename(title string, id uint) string {
+	name := strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(title))
+
+	if len([]rune(name)) > 100 {
+		name = string([]rune(name)[:100])
+	}
+	if strings.Trim(name, "_. ") == "" {
+		return fmt.Sprintf("page-%d", id)
+	}
+	return name
+}
+
+// contentDisposition builds a Content-Disposition header with an ASCII
+// fallback and an RFC 5987 encoded UTF-8 filename
+func contentDisposition(disposition, filename string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r > '~' || r < ' ' || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, filename)
+	return fmt.Sprintf(`%s; filename="%s"; filename*=UTF-8''%s`, disposition, fallback, url.PathEscape(filename))
+}
@@ -0,0 +1,458 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"simultaneous-memo-app/backend/models"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxImportFiles limits how many Markdown files one import may create
+	MaxImportFiles = 500
+	// MaxImportSize limits the total uncompressed size of an import archive (200MB)
+	MaxImportSize = 200 * 1024 * 1024
+)
+
+// importedPage is a page to be created from a Markdown file or a folder
+type importedPage struct {
+	key    string // folder or file path inside the import, without extension
+	source string // Markdown file the content came from, if any
+	title  string
+	doc    map[string]interface{}
+	page   *models.Page
+}
+
+// markdownImport holds the state of a single import request
+type markdownImport struct {
+	h        *Handler
+	baseURL  string
+	files    map[string][]byte
+	images   map[string]map[string]interface{}
+	imageIDs []uint
+	warnings []string
+}
+
+// ImportPages imports a Markdown file, or a zip archive of Markdown files,
+// as pages. Folders in the archive become parent pages, local images are
+// uploaded like UploadFile and links between the files are rewritten to
+// point at the created pages.
+func (h *Handler) ImportPages(c echo.Context) error {
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "No file provided"})
+	}
+	if file.Size > MaxGeneralFileSize {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("File size exceeds %dMB limit", MaxGeneralFileSize/(1024*1024))})
+	}
+
+	var parentID *uint
+	if parentIDStr := c.FormValue("parent_id"); parentIDStr != "" {
+		id, err := strconv.ParseUint(parentIDStr, 10, 32)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid parent page ID"})
+		}
+		if _, err := models.GetPageByID(h.db, uint(id)); err != nil {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Parent page not found"})
+		}
+		parentIDUint := uint(id)
+		parentID = &parentIDUint
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to open file"})
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to read file"})
+	}
+
+	imp := &markdownImport{
+		h:       h,
+		baseURL: getBaseURL(c),
+		images:  make(map[string]map[string]interface{}),
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(file.Filename)); ext {
+	case ".md", ".markdown":
+		imp.files = map[string][]byte{path.Base(filepath.ToSlash(file.Filename)): data}
+	case ".zip":
+		imp.files, err = readImportArchive(data)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Only .md files and .zip archives can be imported"})
+	}
+
+	pages, err := imp.buildPages()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if err := imp.createPages(pages, parentID); err != nil {
+		imp.discardImages()
+		var validationErr *models.ContentValidationError
+		if errors.As(err, &validationErr) {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error":   "Imported content is not valid",
+				"details": err.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create pages"})
+	}
+
+	created := make([]map[string]interface{}, 0, len(pages))
+	for _, p := range pages {
+		created = append(created, map[string]interface{}{
+			"id":        p.page.ID,
+			"title":     p.page.Title,
+			"parent_id": p.page.ParentID,
+			"source":    p.source,
+		})
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"pages":    created,
+		"images":   len(imp.imageIDs),
+		"warnings": imp.warnings,
+	})
+}
+
+// readImportArchive reads every regular file in a zip archive into memory,
+// enforcing the import size and file count limits
+func readImportArchive(data []byte) (map[string][]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, errors.New("Invalid zip archive")
+	}
+
+	files := make(map[string][]byte)
+	var total int64
+	markdownCount := 0
+	for _, entry := range zr.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+
+		name := path.Clean(strings.ReplaceAll(entry.Name, "\\", "/"))
+		if strings.HasPrefix(name, "../") || strings.HasPrefix(name, "/") || name == ".." {
+			return nil, fmt.Errorf("Invalid path in archive: %s", entry.Name)
+		}
+		if isIgnoredImportPath(name) {
+			continue
+		}
+
+		if isMarkdownPath(name) {
+			markdownCount++
+			if markdownCount > MaxImportFiles {
+				return nil, fmt.Errorf("Archive contains more than %d Markdown files", MaxImportFiles)
+			}
+		}
+
+		total += int64(entry.UncompressedSize64)
+		if total > MaxImportSize {
+			return nil, fmt.Errorf("Archive exceeds %dMB when extracted", MaxImportSize/(1024*1024))
+		}
+
+		rc, err := entry.Open()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read %s from archive", entry.Name)
+		}
+		// Read at most one byte more than declared so a forged header
+		// cannot be used to bypass the size limit
+		content, err := io.ReadAll(io.LimitReader(rc, int64(entry.UncompressedSize64)+1))
+		rc.Close()
+		if err != nil || int64(len(content)) > int64(entry.UncompressedSize64) {
+			return nil, fmt.Errorf("Failed to read %s from archive", entry.Name)
+		}
+		files[name] = content
+	}
+
+	if markdownCount == 0 {
+		return nil, errors.New("Archive does not contain any Markdown files")
+	}
+	return files, nil
+}
+
+// buildPages converts every Markdown file and creates a page for each
+// folder. A folder takes its content from index.md, README.md or a
+// sibling file with the same name, when one exists.
+func (imp *markdownImport) buildPages() ([]*importedPage, error) {
+	var markdownPaths []string
+	for name := range imp.files {
+		if isMarkdownPath(name) {
+			markdownPaths = append(markdownPaths, name)
+		}
+	}
+	if len(markdownPaths) == 0 {
+		return nil, errors.New("No Markdown files to import")
+	}
+	sort.Strings(markdownPaths)
+
+	// Collect folders that contain Markdown files
+	folders := make(map[string]bool)
+	for _, p := range markdownPaths {
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			folders[dir] = true
+		}
+	}
+
+	pages := make(map[string]*importedPage)
+	for dir := range folders {
+		pages[dir] = &importedPage{key: dir, title: path.Base(dir)}
+	}
+
+	for _, p := range markdownPaths {
+		key := strings.TrimSuffix(p, path.Ext(p))
+		if base := strings.ToLower(path.Base(key)); (base == "index" || base == "readme") && folders[path.Dir(p)] {
+			key = path.Dir(p)
+		}
+
+		target, ok := pages[key]
+		if !ok {
+			target = &importedPage{key: key, title: path.Base(key)}
+			pages[key] = target
+		} else if target.source != "" {
+			imp.warnings = append(imp.warnings, fmt.Sprintf("%s was skipped because %s is used for the same page", p, target.source))
+			continue
+		}
+
+		title, doc := imp.convert(p)
+		if title != "" {
+			target.title = title
+		}
+		target.source = p
+		target.doc = doc
+	}
+
+	ordered := make([]*importedPage, 0, len(pages))
+	for _, p := range pages {
+		if p.doc == nil {
+			p.doc = map[string]interface{}{"type": "doc", "content": []interface{}{}}
+		}
+		ordered = append(ordered, p)
+	}
+	// Parents are created before their children
+	sort.Slice(ordered, func(i, j int) bool {
+		di, dj := strings.Count(ordered[i].key, "/"), strings.Count(ordered[j].key, "/")
+		if di != dj {
+			return di < dj
+		}
+		return ordered[i].key < ordered[j].key
+	})
+	return ordered, nil
+}
+
+// convert parses one Markdown file, uploading the local images it references
+func (imp *markdownImport) convert(mdPath string) (string, map[string]interface{}) {
+	importer := &markdownImporter{
+		resolveImage: func(destination string) map[string]interface{} {
+			return imp.uploadImage(mdPath, destination)
+		},
+	}
+	return importer.Convert(imp.files[mdPath])
+}
+
+// uploadImage stores an image referenced by a relative path through the
+// same pipeline as UploadFile, returning the attributes that point at it
+func (imp *markdownImport) uploadImage(mdPath, destination string) map[string]interface{} {
+	name, ok := resolveImportPath(mdPath, destination)
+	if !ok {
+		return nil
+	}
+	if attrs, ok := imp.images[name]; ok {
+		return attrs
+	}
+
+	data, ok := imp.files[name]
+	if !ok {
+		imp.warnings = append(imp.warnings, fmt.Sprintf("%s: image %s was not found in the import", mdPath, destination))
+		return nil
+	}
+
+	header := data
+	if len(header) > 512 {
+		header = header[:512]
+	}
+	contentType, err := validateImageUpload(path.Base(name), int64(len(data)), header)
+	if err != nil {
+		imp.warnings = append(imp.warnings, fmt.Sprintf("%s: %s", name, err.Error()))
+		return nil
+	}
+
+	image, err := saveImage(imp.h.db, imp.h.store, bytes.NewReader(data), path.Base(name), contentType)
+	if err == nil {
+		if err = models.CreateImage(imp.h.db, image); err != nil {
+			releaseBlob(imp.h.db, imp.h.store, models.BlobKindImage, image.Checksum)
+		}
+	}
+	if err != nil {
+		imp.warnings = append(imp.warnings, fmt.Sprintf("%s: %s", name, err.Error()))
+		return nil
+	}
+
+	attrs := map[string]interface{}{
+		"src":           imp.baseURL + "/api/img" + image.Path,
+		"image_id":      image.ID,
+		"data-image-id": strconv.FormatUint(uint64(image.ID), 10),
+	}
+	if image.Width > 0 && image.Height > 0 {
+		attrs["width"] = image.Width
+		attrs["height"] = image.Height
+	}
+	imp.images[name] = attrs
+	imp.imageIDs = append(imp.imageIDs, image.ID)
+	return attrs
+}
+
+// createPages creates the pages in a single transaction, then rewrites
+// links between imported files to the new page URLs
+func (imp *markdownImport) createPages(pages []*importedPage, rootParentID *uint) error {
+	byKey := make(map[string]*importedPage, len(pages))
+	bySource := make(map[string]*importedPage, len(pages))
+	for _, p := range pages {
+		byKey[p.key] = p
+		if p.source != "" {
+			bySource[p.source] = p
+		}
+	}
+
+	return imp.h.db.Transaction(func(tx *gorm.DB) error {
+		for _, p := range pages {
+			parentID := rootParentID
+			if parent, ok := byKey[path.Dir(p.key)]; ok && path.Dir(p.key) != "." {
+				parentID = &parent.page.ID
+			}
+
+			title := p.title
+			if title == "" {
+				title = "Untitled"
+			}
+			p.page = &models.Page{
+				Title:    title,
+				Content:  datatypes.JSON(`{"type":"doc","content":[]}`),
+				ParentID: parentID,
+			}
+			if err := models.CreatePage(tx, p.page); err != nil {
+				return err
+			}
+		}
+
+		for _, p := range pages {
+			if p.source != "" {
+				rewriteImportLinks(p.doc, p.source, bySource)
+			}
+
+			encoded, err := json.Marshal(p.doc)
+			if err != nil {
+				return err
+			}
+			content, err := models.ValidatePageContent(encoded)
+			if err != nil {
+				return fmt.Errorf("%s: %w", p.source, err)
+			}
+			p.page.Content = content
+			if err := models.UpdatePage(tx, p.page.ID, map[string]interface{}{"content": content}); err != nil {
+				return err
+			}
+			if err := models.UpdateContentIndexes(tx, p.page.ID, content); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// discardImages deletes the images stored for an import whose pages could
+// not be created, so they are not left behind until the next cleanup
+func (imp *markdownImport) discardImages() {
+	for _, id := range imp.imageIDs {
+		image, err := models.GetImageByID(imp.h.db, id)
+		if err != nil {
+			fmt.Printf("インポート画像 %d の削除エラー: %v\n", id, err)
+			continue
+		}
+		fileErrors, err := deleteImageRecord(imp.h.db, imp.h.store, image)
+		if err != nil {
+			fmt.Printf("インポート画像 %d の削除エラー: %v\n", id, err)
+			continue
+		}
+		for _, err := range fileErrors {
+			fmt.Printf("画像ファイル削除エラー: %v\n", err)
+		}
+	}
+	imp.imageIDs = nil
+}
+
+// rewriteImportLinks points links to other imported Markdown files at the
+// pages created from them
+func rewriteImportLinks(node map[string]interface{}, source string, bySource map[string]*importedPage) {
+	marks, _ := node["marks"].([]interface{})
+	for _, raw := range marks {
+		mark, _ := raw.(map[string]interface{})
+		if t, _ := mark["type"].(string); t != "link" {
+			continue
+		}
+		attrs, _ := mark["attrs"].(map[string]interface{})
+		href, _ := attrs["href"].(string)
+		target, ok := resolveImportPath(source, href)
+		if !ok {
+			continue
+		}
+		if p, ok := bySource[target]; ok {
+			attrs["href"] = models.PageURL(p.page.ID)
+		}
+	}
+
+	for _, child := range nodeChildren(node) {
+		rewriteImportLinks(child, source, bySource)
+	}
+}
+
+// resolveImportPath resolves a relative reference from a Markdown file to
+// a path inside the import. Absolute URLs and fragments are not resolved.
+func resolveImportPath(from, reference string) (string, bool) {
+	u, err := url.Parse(reference)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+		return "", false
+	}
+	resolved := path.Clean(path.Join(path.Dir(from), u.Path))
+	if strings.HasPrefix(resolved, "../") || resolved == ".." {
+		return "", false
+	}
+	return resolved, true
+}
+
+func isMarkdownPath(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".md" || ext == ".markdown"
+}
+
+// isIgnoredImportPath skips hidden files and metadata added by archivers
+func isIgnoredImportPath(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
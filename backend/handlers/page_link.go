@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"simultaneous-memo-app/backend/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// GetBacklinks returns the pages linking to a page, along with the page's
+// own outgoing links. Links whose target page was deleted are flagged as
+// dangling.
+func (h *Handler) GetBacklinks(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid page ID",
+		})
+	}
+
+	backlinks, err := models.GetBacklinks(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to retrieve backlinks",
+		})
+	}
+
+	// A deleted page can still be the target of links from other pages;
+	// those are returned so the dangling links can be found and fixed
+	_, pageErr := models.GetPageByID(h.db, uint(id))
+	if pageErr != nil && len(backlinks) == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Page not found",
+		})
+	}
+
+	outgoing, err := models.GetOutgoingLinks(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to retrieve links",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"page_id":   uint(id),
+		"dangling":  pageErr != nil,
+		"backlinks": backlinks,
+		"links":     outgoing,
+	})
+}
+
+// GetPageGraph returns every page and the links between them
+func (h *Handler) GetPageGraph(c echo.Context) error {
+	graph, err := models.GetPageGraph(h.db)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to build page graph",
+		})
+	}
+
+	return c.JSON(http.StatusOK, graph)
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"simultaneous-memo-app/backend/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// publishImageSizes tells browsers how wide images are shown on the page
+const publishImageSizes = "(max-width: 760px) 100vw, 720px"
+
+// publishedPageCSP only allows images and inline styles on published pages
+const publishedPageCSP = "default-src 'none'; img-src 'self' http: https:; style-src 'unsafe-inline'; base-uri 'none'; form-action 'none'"
+
+var publishedPageTemplate = template.Must(template.New("published").Parse(`<!DOCTYPE html>
+<html lang="ja">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<meta name="description" content="{{.Excerpt}}">
+<style>
+body { max-width: 720px; margin: 2rem auto; padding: 0 1rem; font-family: system-ui, sans-serif; line-height: 1.7; color: #222; }
+img { max-width: 100%; height: auto; }
+pre { background: #f5f5f5; padding: 1rem; overflow-x: auto; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ddd; padding: 0.25rem 0.5rem; }
+blockquote { border-left: 3px solid #ddd; margin-left: 0; padding-left: 1rem; color: #555; }
+.task-list { list-style: none; padding-left: 0; }
+.task-item > p { display: inline; }
+.mention { color: #2563eb; }
+footer { margin-top: 3rem; color: #888; font-size: 0.875rem; }
+</style>
+</head>
+<body>
+<article>
+<h1>{{.Title}}</h1>
+{{.Body}}
+</article>
+<footer>最終更新: {{.UpdatedAt.Format "2006-01-02 15:04"}}</footer>
+</body>
+</html>
+`))
+
+// PublishPage makes a page readable by anyone at /p/:slug
+func (h *Handler) PublishPage(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid page ID",
+		})
+	}
+
+	page, err := models.GetPageByID(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Page not found",
+		})
+	}
+
+	if err := models.PublishPage(h.db, page); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to publish page",
+		})
+	}
+
+	page, err = models.GetPageByID(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Page not found",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"publish_slug": page.PublishSlug,
+		"published_at": page.PublishedAt,
+		"url":          getBaseURL(c) + "/p/" + *page.PublishSlug,
+	})
+}
+
+// UnpublishPage revokes public access to a page. The slug is kept for when
+// the page is published again.
+func (h *Handler) UnpublishPage(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid page ID",
+		})
+	}
+
+	if err := models.UnpublishPage(h.db, uint(id)); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to unpublish page",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Page unpublished successfully",
+	})
+}
+
+// ServePublishedPage renders a published page as HTML. Responses may be
+// cached but must be revalidated, so unpublishing takes effect at once.
+func (h *Handler) ServePublishedPage(c echo.Context) error {
+	page, err := models.GetPublishedPage(h.db, c.Param("slug"))
+	if err != nil {
+		c.Response().Header().Set("Cache-Control", "no-store")
+		return c.HTML(http.StatusNotFound, "<!DOCTYPE html><title>Not Found</title><p>ページが見つかりません</p>")
+	}
+
+	etag := fmt.Sprintf(`"%d-%d-%d"`, page.ID, page.UpdatedAt.UnixNano(), page.PublishedAt.UnixNano())
+	header := c.Response().Header()
+	header.Set("Cache-Control", "public, no-cache")
+	header.Set("ETag", etag)
+	header.Set("Last-Modified", page.UpdatedAt.UTC().Format(http.TimeFormat))
+	header.Set("Content-Security-Policy", publishedPageCSP)
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Referrer-Policy", "no-referrer")
+	if c.Request().Header.Get("If-None-Match") == etag {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	doc, err := models.ParseDocument(page.Content)
+	if err != nil {
+		return c.HTML(http.StatusInternalServerError, "<!DOCTYPE html><title>Error</title><p>ページを表示できませんでした</p>")
+	}
+
+	// Only links to other published pages are kept
+	var linked []uint
+	if refs, err := models.ExtractPageLinks(page.Content); err == nil {
+		for _, ref := range refs {
+			linked = append(linked, ref.TargetPageID)
+		}
+	}
+	slugs, err := models.GetPublishedSlugs(h.db, linked)
+	if err != nil {
+		slugs = map[uint]string{}
+	}
+
+	renderer := &htmlRenderer{
+		image: h.publishedImageAttrs,
+		pageURL: func(id uint) (string, bool) {
+			slug, ok := slugs[id]
+			return "/p/" + slug, ok
+		},
+	}
+
+	var out bytes.Buffer
+	err = publishedPageTemplate.Execute(&out, map[string]interface{}{
+		"Title":     page.Title,
+		"Excerpt":   models.Excerpt(page.Content, models.PageExcerptLength),
+		"Body":      template.HTML(renderer.Render(doc)),
+		"UpdatedAt": page.UpdatedAt,
+	})
+	if err != nil {
+		return c.HTML(http.StatusInternalServerError, "<!DOCTYPE html><title>Error</title><p>ページを表示できませんでした</p>")
+	}
+
+	return c.HTMLBlob(http.StatusOK, out.Bytes())
+}
+
+// publishedImageAttrs serves uploaded images through /api/img with a
+// srcset of resized versions. Other images are kept when their URL is safe.
+func (h *Handler) publishedImageAttrs(attrs map[string]interface{}) map[string]string {
+	src := stringAttr(attrs, "src")
+	imgAttrs := map[string]string{
+		"alt":   stringAttr(attrs, "alt"),
+		"title": stringAttr(attrs, "title"),
+	}
+
+	image := findContentImage(h.db, attrs, src)
+	if image == nil {
+		if src == "" || !models.IsSafeImageURL(src) {
+			return nil
+		}
+		imgAttrs["src"] = src
+		return imgAttrs
+	}
+
+	imgAttrs["src"] = "/api/img" + image.Path
+	if image.Width > 0 && image.Height > 0 {
+		imgAttrs["width"] = strconv.Itoa(image.Width)
+		imgAttrs["height"] = strconv.Itoa(image.Height)
+		if srcset := imageSrcset(image); srcset != "" {
+			imgAttrs["srcset"] = srcset
+			imgAttrs["sizes"] = publishImageSizes
+		}
+	}
+	return imgAttrs
+}
+
+// imageSrcset lists resized versions of an image narrower than the
+// original, followed by the original itself. Images uploaded before
+// variants were generated at upload are resized by /api/img on request.
+func imageSrcset(image *models.Image) string {
+	if image.Srcset != "" || image.ContentType == "image/svg+xml" {
+		return image.Srcset
+	}
+	var srcset string
+	for _, width := range imageVariantWidths {
+		if width >= image.Width {
+			break
+		}
+		srcset += fmt.Sprintf("/api/img%s?w=%d %dw, ", image.Path, width, width)
+	}
+	if srcset == "" {
+		return ""
+	}
+	return srcset + fmt.Sprintf("/api/img%s %dw", image.Path, image.Width)
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"simultaneous-memo-app/backend/models"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// templatePlaceholder matches placeholders such as {{date}} or {{ author }}
+var templatePlaceholder = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_.-]*)\s*\}\}`)
+
+// TemplateRequest is the optional body of CreatePageFromTemplate
+type TemplateRequest struct {
+	Title     string            `json:"title"`
+	Author    string            `json:"author"`
+	ParentID  *uint             `json:"parent_id"`
+	Variables map[string]string `json:"variables"`
+}
+
+// CreatePageFromTemplate creates a new page from a template page. The
+// placeholders {{date}}, {{time}}, {{datetime}}, {{title}} and {{author}},
+// plus any custom variables from the request, are filled in in the title
+// and text. Unknown placeholders are left as they are. Images and files in
+// the template are copied so the new page does not share them.
+func (h *Handler) CreatePageFromTemplate(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid page ID",
+		})
+	}
+
+	var req TemplateRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	template, err := models.GetPageByID(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Template not found",
+		})
+	}
+	if !template.IsTemplate {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Page is not a template",
+		})
+	}
+
+	if req.ParentID != nil {
+		if _, err := models.GetPageByID(h.db, *req.ParentID); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Parent page not found",
+			})
+		}
+	}
+
+	now := time.Now()
+	vars := map[string]string{
+		"date":     now.Format("2006-01-02"),
+		"time":     now.Format("15:04"),
+		"datetime": now.Format("2006-01-02 15:04"),
+		"author":   req.Author,
+	}
+	for name, value := range req.Variables {
+		vars[name] = value
+	}
+
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		title = substitutePlaceholders(template.Title, vars)
+	}
+	vars["title"] = title
+
+	var copier *pageContentCopier
+	// Pages created from a template join its collection with its property
+	// values as defaults
+	page := models.Page{
+		Title:        title,
+		ParentID:     req.ParentID,
+		CollectionID: template.CollectionID,
+		Properties:   template.Properties,
+		Author:       req.Author,
+	}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := models.CreatePage(tx, &page); err != nil {
+			return err
+		}
+
+		copier = newPageContentCopier(tx, h.store, page.ID)
+		copier.text = func(text string) string {
+			return substitutePlaceholders(text, vars)
+		}
+		content, err := copier.Copy(template.Content)
+		if err != nil {
+			return err
+		}
+		if page.Content, err = models.ValidatePageContent(content); err != nil {
+			return err
+		}
+
+		if err := models.UpdatePage(tx, page.ID, map[string]interface{}{"content": page.Content}); err != nil {
+			return err
+		}
+		return models.UpdateContentIndexes(tx, page.ID, page.Content)
+	})
+	if err != nil {
+		if copier != nil {
+			copier.RemoveFiles()
+		}
+
+		var validationErr *models.ContentValidationError
+		if errors.As(err, &validationErr) {
+			return invalidContentResponse(c, err)
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to create page from template",
+		})
+	}
+
+	return c.JSON(http.StatusCreated, page)
+}
+
+// substitutePlaceholders replaces {{name}} placeholders with their values,
+// leaving unknown placeholders untouched
+func substitutePlaceholders(text string, vars map[string]string) string {
+	if !strings.Contains(text, "{{") {
+		return text
+	}
+	return templatePlaceholder.ReplaceAllStringFunc(text, func(match string) string {
+		name := templatePlaceholder.FindStringSubmatch(match)[1]
+		if value, ok := vars[name]; ok {
+			return value
+		}
+		return match
+	})
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"simultaneous-memo-app/backend/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// TagRequest is the body for creating, attaching or updating a tag
+type TagRequest struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+	TagID uint   `json:"tag_id"`
+}
+
+// ListTags returns tags for autocomplete, matching the optional q
+// parameter against tag names
+func (h *Handler) ListTags(c echo.Context) error {
+	limit := 20
+	if l := c.QueryParam("limit"); l != "" {
+		if limitNum, err := strconv.Atoi(l); err == nil && limitNum > 0 && limitNum <= 100 {
+			limit = limitNum
+		}
+	}
+
+	tags, err := models.SearchTags(h.db, c.QueryParam("q"), limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to retrieve tags",
+		})
+	}
+
+	return c.JSON(http.StatusOK, tags)
+}
+
+// UpdateTag renames a tag or changes its color
+func (h *Handler) UpdateTag(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid tag ID",
+		})
+	}
+
+	var req TagRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	if _, err := models.GetTagByID(h.db, uint(id)); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Tag not found",
+		})
+	}
+
+	updates := map[string]interface{}{}
+	if req.Name != "" {
+		name, err := models.NormalizeTagName(req.Name)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid tag name",
+			})
+		}
+		var count int64
+		h.db.Model(&models.Tag{}).Where("name = ? AND id <> ?", name, id).Count(&count)
+		if count > 0 {
+			return c.JSON(http.StatusConflict, map[string]string{
+				"error": "A tag with this name already exists",
+			})
+		}
+		updates["name"] = name
+	}
+	if req.Color != "" {
+		if err := models.ValidateTagColor(req.Color); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid tag color, expected #rrggbb",
+			})
+		}
+		updates["color"] = req.Color
+	}
+
+	if len(updates) > 0 {
+		if err := models.UpdateTag(h.db, uint(id), updates); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to update tag",
+			})
+		}
+	}
+
+	tag, err := models.GetTagByID(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Tag not found",
+		})
+	}
+
+	return c.JSON(http.StatusOK, tag)
+}
+
+// DeleteTag deletes a tag and removes it from every page
+func (h *Handler) DeleteTag(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid tag ID",
+		})
+	}
+
+	if err := models.DeleteTag(h.db, uint(id)); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to delete tag",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Tag deleted successfully",
+	})
+}
+
+// GetPageTags returns the tags attached to a page
+func (h *Handler) GetPageTags(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid page ID",
+		})
+	}
+
+	if _, err := models.GetPageByID(h.db, uint(id)); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Page not found",
+		})
+	}
+
+	tags, err := models.GetPageTags(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to retrieve tags",
+		})
+	}
+
+	return c.JSON(http.StatusOK, tags)
+}
+
+// AddPageTag attaches a tag to a page, either an existing one by tag_id or
+// by name, creating the tag if it does not exist yet
+func (h *Handler) AddPageTag(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid page ID",
+		})
+	}
+
+	var req TagRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	if _, err := models.GetPageByID(h.db, uint(id)); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Page not found",
+		})
+	}
+
+	var tag *models.Tag
+	if req.TagID != 0 {
+		tag, err = models.GetTagByID(h.db, req.TagID)
+		if err != nil {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": "Tag not found",
+			})
+		}
+	} else {
+		tag, err = models.FindOrCreateTag(h.db, req.Name, req.Color)
+		if errors.Is(err, models.ErrInvalidTagName) || errors.Is(err, models.ErrInvalidTagColor) {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to create tag",
+			})
+		}
+	}
+
+	if err := models.AddPageTag(h.db, uint(id), tag.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to add tag",
+		})
+	}
+
+	tags, err := models.GetPageTags(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to retrieve tags",
+		})
+	}
+
+	return c.JSON(http.StatusOK, tags)
+}
+
+// RemovePageTag detaches a tag from a page. Tags extracted from a #hashtag
+// come back on the next save unless the hashtag is removed as well.
+func (h *Handler) RemovePageTag(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid page ID",
+		})
+	}
+	tagID, err := strconv.ParseUint(c.Param("tagId"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid tag ID",
+		})
+	}
+
+	if err := models.RemovePageTag(h.db, uint(id), uint(tagID)); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to remove tag",
+		})
+	}
+
+	tags, err := models.GetPageTags(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to retrieve tags",
+		})
+	}
+
+	return c.JSON(http.StatusOK, tags)
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"simultaneous-memo-app/backend/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// TaskRequest is the body for changing a task. Without checked the task is
+// toggled.
+type TaskRequest struct {
+	Checked *bool  `json:"checked"`
+	Editor  string `json:"editor"`
+}
+
+// ListTasks returns the task items of all pages. Tasks can be filtered by
+// status (open, done or all), page_id, assignee and a due_from/due_to range.
+func (h *Handler) ListTasks(c echo.Context) error {
+	opts := models.TaskListOptions{
+		Status:   models.TaskStatusOpen,
+		Assignee: c.QueryParam("assignee"),
+		Limit:    200,
+	}
+
+	switch status := c.QueryParam("status"); status {
+	case "":
+	case models.TaskStatusOpen, models.TaskStatusDone, models.TaskStatusAll:
+		opts.Status = status
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid status, expected open, done or all",
+		})
+	}
+
+	if p := c.QueryParam("page_id"); p != "" {
+		pageID, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid page ID",
+			})
+		}
+		opts.PageID = uint(pageID)
+	}
+
+	var err error
+	if opts.DueFrom, err = parseListDate(c.QueryParam("due_from"), false); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid due_from date",
+		})
+	}
+	if opts.DueTo, err = parseListDate(c.QueryParam("due_to"), true); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid due_to date",
+		})
+	}
+
+	if l := c.QueryParam("limit"); l != "" {
+		if limitNum, err := strconv.Atoi(l); err == nil && limitNum > 0 && limitNum <= 1000 {
+			opts.Limit = limitNum
+		}
+	}
+
+	tasks, err := models.ListTasks(h.db, opts)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to retrieve tasks",
+		})
+	}
+
+	return c.JSON(http.StatusOK, tasks)
+}
+
+// UpdateTask checks or unchecks a task by writing the change back into its
+// page's content. Open editors are told through the page's events so they
+// apply the change to the shared document instead of saving over it.
+func (h *Handler) UpdateTask(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid task ID",
+		})
+	}
+
+	var req TaskRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	task, err := models.GetTaskByID(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Task not found",
+		})
+	}
+	page, err := models.GetPageByID(h.db, task.PageID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Page not found",
+		})
+	}
+
+	checked := !task.Checked
+	if req.Checked != nil {
+		checked = *req.Checked
+	}
+
+	content, err := models.SetTaskChecked(page.Content, task, checked)
+	if errors.Is(err, models.ErrTaskChanged) {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": "The task was changed in the page, reload and try again",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to update task",
+		})
+	}
+	if content, err = models.ValidatePageContent(content); err != nil {
+		return invalidContentResponse(c, err)
+	}
+
+	updates := map[string]interface{}{
+		"content":    content,
+		"updated_by": strings.TrimSpace(req.Editor),
+	}
+	if err := models.UpdatePage(h.db, page.ID, updates); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to update page",
+		})
+	}
+	h.updateContentReferences(page.ID, content)
+
+	updated, err := models.GetTaskByID(h.db, task.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to retrieve task",
+		})
+	}
+
+	// Open editors hold the document in Yjs and need to apply the change
+	h.notifyPage(page.ID, "task-updated", map[string]interface{}{
+		"task":    updated,
+		"content": content,
+	})
+
+	return c.JSON(http.StatusOK, updated)
+}
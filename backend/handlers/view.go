@@ -0,0 +1,422 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"simultaneous-memo-app/backend/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ViewRequest is the body for creating or updating a view
+type ViewRequest struct {
+	Name    *string                  `json:"name"`
+	Type    *string                  `json:"type"`
+	Filters *[]models.PropertyFilter `json:"filters"`
+	Sorts   *[]models.PropertySort   `json:"sorts"`
+	GroupBy *string                  `json:"group_by"`
+	Columns *[]string                `json:"columns"`
+}
+
+// apply copies the fields present in the request onto view
+func (r *ViewRequest) apply(view *models.View) error {
+	if r.Name != nil {
+		view.Name = strings.TrimSpace(*r.Name)
+	}
+	if r.Type != nil {
+		view.Type = *r.Type
+	}
+	if r.GroupBy != nil {
+		view.GroupBy = *r.GroupBy
+	}
+	if r.Filters != nil {
+		encoded, err := json.Marshal(*r.Filters)
+		if err != nil {
+			return err
+		}
+		view.Filters = encoded
+	}
+	if r.Sorts != nil {
+		encoded, err := json.Marshal(*r.Sorts)
+		if err != nil {
+			return err
+		}
+		view.Sorts = encoded
+	}
+	if r.Columns != nil {
+		encoded, err := json.Marshal(*r.Columns)
+		if err != nil {
+			return err
+		}
+		view.Columns = encoded
+	}
+	return nil
+}
+
+// ListViews returns the views of a collection
+func (h *Handler) ListViews(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid collection ID",
+		})
+	}
+
+	views, err := models.GetCollectionViews(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to retrieve views",
+		})
+	}
+
+	return c.JSON(http.StatusOK, views)
+}
+
+// CreateView saves a new view of a collection
+func (h *Handler) CreateView(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid collection ID",
+		})
+	}
+
+	collection, err := models.GetCollectionByID(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Collection not found",
+		})
+	}
+
+	var req ViewRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+
+	view := models.View{CollectionID: collection.ID, Type: models.ViewTable}
+	if err := req.apply(&view); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+	if view.Name == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "View name is required",
+		})
+	}
+	if err := models.ValidateView(collection, &view); err != nil {
+		return invalidPropertiesResponse(c, err)
+	}
+
+	if err := models.CreateView(h.db, &view); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to create view",
+		})
+	}
+
+	return c.JSON(http.StatusCreated, view)
+}
+
+// GetView returns a view together with its collection's schema
+func (h *Handler) GetView(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid view ID",
+		})
+	}
+
+	view, err := models.GetViewByID(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "View not found",
+		})
+	}
+	collection, err := models.GetCollectionByID(h.db, view.CollectionID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Collection not found",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"view":       view,
+		"collection": collection,
+	})
+}
+
+// UpdateView changes a view's settings
+func (h *Handler) UpdateView(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid view ID",
+		})
+	}
+
+	view, err := models.GetViewByID(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "View not found",
+		})
+	}
+	collection, err := models.GetCollectionByID(h.db, view.CollectionID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Collection not found",
+		})
+	}
+
+	var req ViewRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+	if err := req.apply(view); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+	if view.Name == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "View name is required",
+		})
+	}
+	if err := models.ValidateView(collection, view); err != nil {
+		return invalidPropertiesResponse(c, err)
+	}
+
+	err = models.UpdateView(h.db, view.ID, map[string]interface{}{
+		"name":     view.Name,
+		"type":     view.Type,
+		"filters":  view.Filters,
+		"sorts":    view.Sorts,
+		"group_by": view.GroupBy,
+		"columns":  view.Columns,
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to update view",
+		})
+	}
+
+	return c.JSON(http.StatusOK, view)
+}
+
+// DeleteView deletes a view
+func (h *Handler) DeleteView(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid view ID",
+		})
+	}
+
+	if err := models.DeleteView(h.db, uint(id)); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to delete view",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "View deleted successfully",
+	})
+}
+
+// GetViewRows returns the rows of a view, shaped by its type
+func (h *Handler) GetViewRows(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid view ID",
+		})
+	}
+
+	view, err := models.GetViewByID(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "View not found",
+		})
+	}
+
+	return h.viewRowsResponse(c, view)
+}
+
+// GetSharedViewRows returns the rows of a shared view by its token
+func (h *Handler) GetSharedViewRows(c echo.Context) error {
+	view, err := models.GetViewByShareToken(h.db, c.Param("token"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "View not found",
+		})
+	}
+
+	return h.viewRowsResponse(c, view)
+}
+
+// ShareView creates a share token for a view, or returns the existing one
+func (h *Handler) ShareView(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid view ID",
+		})
+	}
+
+	view, err := models.GetViewByID(h.db, uint(id))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "View not found",
+		})
+	}
+
+	if view.ShareToken == nil {
+		token, err := models.GenerateToken()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to share view",
+			})
+		}
+		if err := models.UpdateView(h.db, view.ID, map[string]interface{}{"share_token": token}); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to share view",
+			})
+		}
+		view.ShareToken = &token
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"share_token": *view.ShareToken,
+		"url":         getBaseURL(c) + "/api/shared/views/" + *view.ShareToken,
+	})
+}
+
+// UnshareView revokes a view's share token
+func (h *Handler) UnshareView(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid view ID",
+		})
+	}
+
+	if err := models.UpdateView(h.db, uint(id), map[string]interface{}{"share_token": nil}); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to unshare view",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "View is no longer shared",
+	})
+}
+
+// viewRowsResponse runs a view's query. Table views are paginated with
+// limit and offset, board views return up to per_group rows per column,
+// and calendar views return the days between from and to (defaulting to
+// the current month).
+func (h *Handler) viewRowsResponse(c echo.Context, view *models.View) error {
+	collection, err := models.GetCollectionByID(h.db, view.CollectionID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Collection not found",
+		})
+	}
+
+	// The schema may have changed since the view was saved
+	if err := models.ValidateView(collection, view); err != nil {
+		return invalidPropertiesResponse(c, err)
+	}
+
+	response := map[string]interface{}{
+		"view":   view,
+		"schema": collection.Schema,
+	}
+
+	switch view.Type {
+	case models.ViewBoard:
+		perGroup := 50
+		if p := c.QueryParam("per_group"); p != "" {
+			if n, err := strconv.Atoi(p); err == nil && n > 0 && n <= 200 {
+				perGroup = n
+			}
+		}
+		groups, err := models.GetViewBoard(h.db, collection, view, perGroup)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to retrieve view rows",
+			})
+		}
+		response["groups"] = groups
+
+	case models.ViewCalendar:
+		now := time.Now()
+		from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		to := from.AddDate(0, 1, -1)
+		for _, param := range []struct {
+			name string
+			dst  *time.Time
+		}{{"from", &from}, {"to", &to}} {
+			value := c.QueryParam(param.name)
+			if value == "" {
+				continue
+			}
+			t, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"error": "Invalid " + param.name + " date, expected YYYY-MM-DD",
+				})
+			}
+			*param.dst = t
+		}
+		if to.Before(from) || to.Sub(from) > 366*24*time.Hour {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Date range must be at most one year",
+			})
+		}
+
+		days, err := models.GetViewCalendar(h.db, collection, view, from.Format("2006-01-02"), to.Format("2006-01-02"))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to retrieve view rows",
+			})
+		}
+		response["from"] = from.Format("2006-01-02")
+		response["to"] = to.Format("2006-01-02")
+		response["days"] = days
+
+	default:
+		limit := 50
+		offset := 0
+		if l := c.QueryParam("limit"); l != "" {
+			if n, err := strconv.Atoi(l); err == nil && n > 0 && n <= 100 {
+				limit = n
+			}
+		}
+		if o := c.QueryParam("offset"); o != "" {
+			if n, err := strconv.Atoi(o); err == nil && n >= 0 {
+				offset = n
+			}
+		}
+		rows, total, err := models.GetViewRows(h.db, collection, view, limit, offset)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to retrieve view rows",
+			})
+		}
+		response["rows"] = rows
+		response["total"] = total
+		response["limit"] = limit
+		response["offset"] = offset
+		response["has_more"] = int64(offset+len(rows)) < total
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
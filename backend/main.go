@@ -6,6 +6,7 @@ import (
 	"simultaneous-memo-app/backend/config"
 	"simultaneous-memo-app/backend/handlers"
 	"simultaneous-memo-app/backend/models"
+	"simultaneous-memo-app/backend/storage"
 	"simultaneous-memo-app/backend/websocket"
 	customMiddleware "simultaneous-memo-app/backend/middleware"
 
@@ -28,6 +29,12 @@ func main() {
 		log.Fatal("Failed to migrate database:", err)
 	}
 
+	// Storage for uploaded images and files
+	store, err := storage.New(cfg.Storage)
+	if err != nil {
+		log.Fatal("Failed to initialize storage:", err)
+	}
+
 	// Initialize Echo
 	e := echo.New()
 
@@ -36,8 +43,19 @@ func main() {
 	e.Use(middleware.Recover())
 	e.Use(middleware.CORS())
 
+	// WebSocket hub, shared with handlers for live notifications
+	ws := websocket.NewHub()
+	go ws.Run()
+
 	// Initialize handlers
-	h := handlers.NewHandler(db)
+	handlers.SetImageMetadataPolicy(handlers.ImageMetadataPolicy{
+		StripLocation: cfg.StripImageLocation,
+		StripDevice:   cfg.StripImageDevice,
+	})
+	if len(cfg.ImageVariantWidths) > 0 {
+		handlers.SetImageVariantWidths(cfg.ImageVariantWidths)
+	}
+	h := handlers.NewHandler(db, ws, store, cfg.ImageCacheDir)
 
 	// Initialize rate limiters
 	fileUploadLimiter := customMiddleware.FileUploadRateLimiter()
@@ -52,9 +70,64 @@ func main() {
 	// Page routes
 	api.GET("/pages", h.GetPages)
 	api.POST("/pages", h.CreatePage)
+	api.GET("/pages/graph", h.GetPageGraph)
 	api.GET("/pages/:id", h.GetPage)
 	api.PUT("/pages/:id", h.UpdatePage)
 	api.DELETE("/pages/:id", h.DeletePage)
+	api.GET("/pages/:id/export", h.ExportPage)
+	api.GET("/pages/:id/backlinks", h.GetBacklinks)
+	api.POST("/pages/:id/duplicate", h.DuplicatePage)
+	api.POST("/pages/import", h.ImportPages, fileUploadLimiter.Middleware())
+	api.POST("/pages/from-template/:id", h.CreatePageFromTemplate)
+	api.POST("/pages/:id/publish", h.PublishPage)
+	api.DELETE("/pages/:id/publish", h.UnpublishPage)
+
+	// Tags
+	api.GET("/tags", h.ListTags)
+	api.PUT("/tags/:id", h.UpdateTag)
+	api.DELETE("/tags/:id", h.DeleteTag)
+	api.GET("/pages/:id/tags", h.GetPageTags)
+	api.POST("/pages/:id/tags", h.AddPageTag)
+	api.DELETE("/pages/:id/tags/:tagId", h.RemovePageTag)
+
+	// Collections (typed page properties)
+	api.GET("/collections", h.ListCollections)
+	api.POST("/collections", h.CreateCollection)
+	api.GET("/collections/:id", h.GetCollection)
+	api.PUT("/collections/:id", h.UpdateCollection)
+	api.DELETE("/collections/:id", h.DeleteCollection)
+	api.POST("/collections/:id/query", h.QueryCollection)
+	api.GET("/collections/:id/views", h.ListViews)
+	api.POST("/collections/:id/views", h.CreateView)
+
+	// Saved views of collections
+	api.GET("/views/:id", h.GetView)
+	api.PUT("/views/:id", h.UpdateView)
+	api.DELETE("/views/:id", h.DeleteView)
+	api.GET("/views/:id/rows", h.GetViewRows)
+	api.POST("/views/:id/share", h.ShareView)
+	api.DELETE("/views/:id/share", h.UnshareView)
+	api.GET("/shared/views/:token", h.GetSharedViewRows)
+
+	// Comments
+	api.GET("/pages/:id/comments", h.GetPageComments)
+	api.POST("/pages/:id/comments", h.CreateComment)
+	api.PUT("/comments/:id", h.UpdateComment)
+	api.DELETE("/comments/:id", h.DeleteComment)
+	api.POST("/comments/:id/replies", h.ReplyToComment)
+	api.POST("/comments/:id/resolve", h.ResolveComment)
+	api.POST("/comments/:id/reopen", h.ReopenComment)
+
+	// Tasks collected from taskItem checkboxes
+	api.GET("/tasks", h.ListTasks)
+	api.PUT("/tasks/:id", h.UpdateTask)
+
+	// Feeds, authenticated by a token in the URL
+	api.GET("/feed-tokens", h.ListFeedTokens)
+	api.POST("/feed-tokens", h.CreateFeedToken)
+	api.DELETE("/feed-tokens/:id", h.DeleteFeedToken)
+	api.GET("/feeds/:token/calendar.ics", h.CalendarFeed)
+	api.GET("/feeds/:token/atom.xml", h.AtomFeed)
 
 	// Image upload with stricter rate limiting
 	api.POST("/upload", h.UploadFile, fileUploadLimiter.Middleware())
@@ -70,6 +143,8 @@ func main() {
 	// Image management
 	api.GET("/images", h.GetImages)
 	api.GET("/images/:id", h.GetImageByID)
+	api.PATCH("/images/:id", h.UpdateImageByID)
+	api.POST("/images/:id/revert", h.RevertImageByID)
 	api.DELETE("/images/:id", h.DeleteImageByID)
 	
 	// Responsive image serving
@@ -79,14 +154,20 @@ func main() {
 	api.POST("/admin/cleanup-images", h.CleanupImages)
 
 	// WebSocket endpoint
-	ws := websocket.NewHub()
-	go ws.Run()
-	
 	e.GET("/ws/:pageId", func(c echo.Context) error {
 		pageID := c.Param("pageId")
 		websocket.HandleWebSocket(ws, c.Response(), c.Request(), pageID)
 		return nil
 	})
+	// JSON events of a page (comments, tasks, image edits), kept off the
+	// Yjs socket, which only carries binary sync messages
+	e.GET("/ws/:pageId/events", func(c echo.Context) error {
+		websocket.HandleEvents(ws, c.Response(), c.Request(), c.Param("pageId"))
+		return nil
+	})
+
+	// Published pages, readable without the app
+	e.GET("/p/:slug", h.ServePublishedPage)
 
 	// Health check
 	e.GET("/health", func(c echo.Context) error {
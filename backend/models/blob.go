@@ -0,0 +1,121 @@
+package models
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Blob kinds, kept apart because images and files are stored in
+// different directories
+const (
+	BlobKindImage = "image"
+	BlobKindFile  = "file"
+)
+
+// Blob is uploaded content stored once by its SHA-256 digest. Image and
+// File records with the same Checksum share the blob; RefCount counts them
+// and the blob is removed once no record refers to it.
+type Blob struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	Kind          string    `json:"kind" gorm:"not null;uniqueIndex:idx_blobs_kind_digest"`
+	Digest        string    `json:"digest" gorm:"not null;uniqueIndex:idx_blobs_kind_digest"`
+	Path          string    `json:"path" gorm:"not null"`
+	ThumbnailPath string    `json:"thumbnail_path"`
+	Size          int64     `json:"size"`
+	RefCount      int       `json:"ref_count" gorm:"not null;default:0"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// ImageKey returns the storage key of an image path such as
+// "/images/sha256/ab/<digest>.jpg"
+func ImageKey(path string) string {
+	return strings.TrimPrefix(path, "/")
+}
+
+// FileKey returns the storage key of a file path. Files uploaded before
+// storage drivers have paths relative to the working directory, like
+// "uploads/files/2024/01/report.pdf".
+func FileKey(path string) string {
+	return strings.TrimPrefix(filepath.ToSlash(path), "uploads/")
+}
+
+// Key returns the storage key of the blob's content
+func (b *Blob) Key() string {
+	if b.Kind == BlobKindImage {
+		return ImageKey(b.Path)
+	}
+	return FileKey(b.Path)
+}
+
+// GetBlob retrieves a blob by kind and digest
+func GetBlob(db *gorm.DB, kind, digest string) (*Blob, error) {
+	var blob Blob
+	err := db.Where("kind = ? AND digest = ?", kind, digest).First(&blob).Error
+	if err != nil {
+		return nil, err
+	}
+	return &blob, nil
+}
+
+// AcquireBlob adds a reference to the blob with the kind and digest of
+// blob, creating it from blob if it does not exist yet, and returns the
+// stored blob
+func AcquireBlob(db *gorm.DB, blob *Blob) (*Blob, error) {
+	blob.RefCount = 1
+	err := db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "kind"}, {Name: "digest"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"ref_count": gorm.Expr("blobs.ref_count + 1"),
+		}),
+	}).Create(blob).Error
+	if err != nil {
+		return nil, err
+	}
+	return GetBlob(db, blob.Kind, blob.Digest)
+}
+
+// AddBlobReference adds a reference to an existing blob, e.g. for a copy
+// of a record
+func AddBlobReference(db *gorm.DB, kind, digest string) error {
+	result := db.Model(&Blob{}).Where("kind = ? AND digest = ?", kind, digest).
+		Update("ref_count", gorm.Expr("ref_count + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ReleaseBlob removes a reference to a blob. When it was the last one the
+// blob is deleted and returned so its files can be removed; otherwise nil
+// is returned.
+func ReleaseBlob(db *gorm.DB, kind, digest string) (*Blob, error) {
+	var released *Blob
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var blob Blob
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("kind = ? AND digest = ?", kind, digest).First(&blob).Error
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if blob.RefCount > 1 {
+			return tx.Model(&blob).Update("ref_count", blob.RefCount-1).Error
+		}
+		if err := tx.Delete(&blob).Error; err != nil {
+			return err
+		}
+		released = &blob
+		return nil
+	})
+	return released, err
+}
@@ -0,0 +1,676 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Property types supported in collection schemas
+const (
+	PropertyText        = "text"
+	PropertyStatus      = "status"
+	PropertyAssignee    = "assignee"
+	PropertyDate        = "date"
+	PropertyNumber      = "number"
+	PropertySelect      = "select"
+	PropertyMultiSelect = "multi_select"
+	PropertyURL         = "url"
+	PropertyCheckbox    = "checkbox"
+)
+
+// propertyTypes lists the valid property types
+var propertyTypes = map[string]bool{
+	PropertyText:        true,
+	PropertyStatus:      true,
+	PropertyAssignee:    true,
+	PropertyDate:        true,
+	PropertyNumber:      true,
+	PropertySelect:      true,
+	PropertyMultiSelect: true,
+	PropertyURL:         true,
+	PropertyCheckbox:    true,
+}
+
+// defaultStatusOptions are used for status properties defined without options
+var defaultStatusOptions = []PropertyOption{
+	{Value: "Not started", Color: "#9ca3af"},
+	{Value: "In progress", Color: "#3b82f6"},
+	{Value: "Done", Color: "#22c55e"},
+}
+
+// propertyIDPattern matches valid property IDs
+var propertyIDPattern = regexp.MustCompile(`^[a-z0-9_]{1,64}$`)
+
+// Collection groups pages that share a property schema, like a database
+// table whose rows are pages
+type Collection struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`
+	Name      string         `json:"name" gorm:"not null"`
+	Schema    datatypes.JSON `json:"schema" gorm:"type:jsonb"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// PropertyDefinition describes one property in a collection schema. ID is
+// the key the value is stored under in Page.Properties, so renaming a
+// property does not affect stored values.
+type PropertyDefinition struct {
+	ID      string           `json:"id"`
+	Name    string           `json:"name"`
+	Type    string           `json:"type"`
+	Options []PropertyOption `json:"options,omitempty"`
+}
+
+// PropertyOption is an allowed value of a status, select or multi-select
+// property
+type PropertyOption struct {
+	Value string `json:"value"`
+	Color string `json:"color,omitempty"`
+}
+
+// PropertyValidationError describes an invalid schema or property value
+type PropertyValidationError struct {
+	Property string `json:"property"`
+	Message  string `json:"message"`
+}
+
+func (e *PropertyValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Property, e.Message)
+}
+
+// Definitions decodes the collection's schema
+func (c *Collection) Definitions() ([]PropertyDefinition, error) {
+	var defs []PropertyDefinition
+	if len(c.Schema) == 0 {
+		return defs, nil
+	}
+	err := json.Unmarshal(c.Schema, &defs)
+	return defs, err
+}
+
+// Definition returns the schema entry for a property ID
+func (c *Collection) Definition(id string) (*PropertyDefinition, bool) {
+	defs, err := c.Definitions()
+	if err != nil {
+		return nil, false
+	}
+	for i := range defs {
+		if defs[i].ID == id {
+			return &defs[i], true
+		}
+	}
+	return nil, false
+}
+
+// NormalizeSchema validates property definitions and fills in missing IDs
+// (derived from the name) and the default status options
+func NormalizeSchema(defs []PropertyDefinition) ([]PropertyDefinition, error) {
+	seen := make(map[string]bool)
+	normalized := make([]PropertyDefinition, 0, len(defs))
+	for i, def := range defs {
+		def.Name = strings.TrimSpace(def.Name)
+		if def.Name == "" {
+			return nil, &PropertyValidationError{Property: fmt.Sprintf("schema[%d]", i), Message: "name is required"}
+		}
+		if !propertyTypes[def.Type] {
+			return nil, &PropertyValidationError{Property: def.Name, Message: fmt.Sprintf("unknown property type %q", def.Type)}
+		}
+
+		if def.ID == "" {
+			def.ID = propertyIDFromName(def.Name, i, seen)
+		}
+		if !propertyIDPattern.MatchString(def.ID) {
+			return nil, &PropertyValidationError{Property: def.Name, Message: "id must contain only a-z, 0-9 and _"}
+		}
+		if seen[def.ID] {
+			return nil, &PropertyValidationError{Property: def.Name, Message: fmt.Sprintf("duplicate property id %q", def.ID)}
+		}
+		seen[def.ID] = true
+
+		switch def.Type {
+		case PropertyStatus, PropertySelect, PropertyMultiSelect:
+			if def.Type == PropertyStatus && len(def.Options) == 0 {
+				def.Options = defaultStatusOptions
+			}
+			values := make(map[string]bool)
+			for _, option := range def.Options {
+				if strings.TrimSpace(option.Value) == "" || values[option.Value] {
+					return nil, &PropertyValidationError{Property: def.Name, Message: "options must be unique and non-empty"}
+				}
+				if option.Color != "" && !tagColorPattern.MatchString(option.Color) {
+					return nil, &PropertyValidationError{Property: def.Name, Message: "option colors must be #rrggbb"}
+				}
+				values[option.Value] = true
+			}
+		default:
+			def.Options = nil
+		}
+
+		normalized = append(normalized, def)
+	}
+	return normalized, nil
+}
+
+// propertyIDFromName derives a property ID from its name, falling back to
+// a positional ID for names without ASCII letters or digits
+func propertyIDFromName(name string, index int, taken map[string]bool) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "_"):
+			b.WriteByte('_')
+		}
+	}
+	id := strings.Trim(b.String(), "_")
+	if len(id) > 48 {
+		id = id[:48]
+	}
+	if id == "" {
+		id = fmt.Sprintf("prop_%d", index+1)
+	}
+	candidate := id
+	for n := 2; taken[candidate]; n++ {
+		candidate = fmt.Sprintf("%s_%d", id, n)
+	}
+	return candidate
+}
+
+// ValidateProperties checks property values against a collection schema
+// and returns them normalized: dates as YYYY-MM-DD or UTC RFC 3339, URLs
+// trimmed, and null or empty values removed. Unknown properties are
+// rejected.
+func ValidateProperties(collection *Collection, raw datatypes.JSON) (datatypes.JSON, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return datatypes.JSON("{}"), nil
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return nil, &PropertyValidationError{Property: "properties", Message: "must be an object"}
+	}
+	if len(values) > 0 && collection == nil {
+		return nil, &PropertyValidationError{Property: "properties", Message: "page does not belong to a collection"}
+	}
+
+	normalized := make(map[string]interface{}, len(values))
+	for id, value := range values {
+		def, ok := collection.Definition(id)
+		if !ok {
+			return nil, &PropertyValidationError{Property: id, Message: "unknown property"}
+		}
+		if value == nil {
+			continue
+		}
+		v, err := normalizePropertyValue(def, value)
+		if err != nil {
+			return nil, &PropertyValidationError{Property: id, Message: err.Error()}
+		}
+		if v != nil {
+			normalized[id] = v
+		}
+	}
+
+	encoded, err := json.Marshal(normalized)
+	if err != nil {
+		return nil, err
+	}
+	return datatypes.JSON(encoded), nil
+}
+
+// normalizePropertyValue validates a single value for its definition. A
+// nil result means the value is empty and should not be stored.
+func normalizePropertyValue(def *PropertyDefinition, value interface{}) (interface{}, error) {
+	switch def.Type {
+	case PropertyText, PropertyAssignee:
+		s, ok := value.(string)
+		if !ok {
+			return nil, errors.New("must be a string")
+		}
+		if s = strings.TrimSpace(s); s == "" {
+			return nil, nil
+		}
+		return s, nil
+
+	case PropertyStatus, PropertySelect:
+		s, ok := value.(string)
+		if !ok {
+			return nil, errors.New("must be a string")
+		}
+		if s == "" {
+			return nil, nil
+		}
+		if !def.hasOption(s) {
+			return nil, fmt.Errorf("%q is not one of the options", s)
+		}
+		return s, nil
+
+	case PropertyMultiSelect:
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, errors.New("must be an array of strings")
+		}
+		selected := make([]string, 0, len(items))
+		for _, item := range items {
+			s, ok := item.(string)
+			if !ok {
+				return nil, errors.New("must be an array of strings")
+			}
+			if !def.hasOption(s) {
+				return nil, fmt.Errorf("%q is not one of the options", s)
+			}
+			if !containsString(selected, s) {
+				selected = append(selected, s)
+			}
+		}
+		if len(selected) == 0 {
+			return nil, nil
+		}
+		return selected, nil
+
+	case PropertyDate:
+		s, ok := value.(string)
+		if !ok {
+			return nil, errors.New("must be a date string")
+		}
+		if s = strings.TrimSpace(s); s == "" {
+			return nil, nil
+		}
+		return NormalizePropertyDate(s)
+
+	case PropertyNumber:
+		n, ok := value.(float64)
+		if !ok || math.IsNaN(n) || math.IsInf(n, 0) {
+			return nil, errors.New("must be a number")
+		}
+		return n, nil
+
+	case PropertyURL:
+		s, ok := value.(string)
+		if !ok {
+			return nil, errors.New("must be a string")
+		}
+		if s = strings.TrimSpace(s); s == "" {
+			return nil, nil
+		}
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, errors.New("must be an http or https URL")
+		}
+		return s, nil
+
+	case PropertyCheckbox:
+		b, ok := value.(bool)
+		if !ok {
+			return nil, errors.New("must be true or false")
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("unknown property type %q", def.Type)
+}
+
+// NormalizePropertyDate accepts YYYY-MM-DD or RFC 3339 and returns the date
+// unchanged or the time in UTC, so stored dates sort as strings
+func NormalizePropertyDate(s string) (string, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t.Format("2006-01-02"), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC().Format(time.RFC3339), nil
+	}
+	return "", errors.New("must be YYYY-MM-DD or an RFC 3339 time")
+}
+
+func (d *PropertyDefinition) hasOption(value string) bool {
+	for _, option := range d.Options {
+		if option.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateCollection creates a new collection
+func CreateCollection(db *gorm.DB, collection *Collection) error {
+	return db.Create(collection).Error
+}
+
+// GetCollectionByID retrieves a collection by ID
+func GetCollectionByID(db *gorm.DB, id uint) (*Collection, error) {
+	var collection Collection
+	if err := db.First(&collection, id).Error; err != nil {
+		return nil, err
+	}
+	return &collection, nil
+}
+
+// GetAllCollections retrieves all collections
+func GetAllCollections(db *gorm.DB) ([]Collection, error) {
+	collections := []Collection{}
+	err := db.Order("name ASC").Find(&collections).Error
+	return collections, err
+}
+
+// UpdateCollectionSchema replaces a collection's schema. Values of
+// properties that were removed are deleted from its pages.
+func UpdateCollectionSchema(db *gorm.DB, collection *Collection, defs []PropertyDefinition) error {
+	old, err := collection.Definitions()
+	if err != nil {
+		return err
+	}
+	schema, err := json.Marshal(defs)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(collection).Update("schema", datatypes.JSON(schema)).Error; err != nil {
+			return err
+		}
+		for _, def := range old {
+			kept := false
+			for _, d := range defs {
+				if d.ID == def.ID {
+					kept = true
+					break
+				}
+			}
+			if kept {
+				continue
+			}
+			err := tx.Model(&Page{}).
+				Where("collection_id = ?", collection.ID).
+				Update("properties", gorm.Expr("properties - ?::text", def.ID)).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteCollection deletes a collection and its views. Its pages are kept
+// but lose their properties.
+func DeleteCollection(db *gorm.DB, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Page{}).Where("collection_id = ?", id).
+			Updates(map[string]interface{}{"collection_id": nil, "properties": datatypes.JSON("{}")}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("collection_id = ?", id).Delete(&View{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Collection{}, id).Error
+	})
+}
+
+// PropertyFilter is a condition on a property value. Supported operators
+// depend on the property type:
+//
+//	text, assignee, url: eq, neq, contains, empty, not_empty
+//	status, select:      eq, neq, in, empty, not_empty
+//	multi_select:        contains, contains_all, contains_any, empty, not_empty
+//	number:              eq, neq, gt, gte, lt, lte, empty, not_empty
+//	date:                eq, before, after, on_or_before, on_or_after, empty, not_empty
+//	checkbox:            eq
+type PropertyFilter struct {
+	Property string      `json:"property"`
+	Operator string      `json:"op"`
+	Value    interface{} `json:"value"`
+}
+
+// PropertySort orders query results by a property value, or by the page's
+// "title", "created_at" or "updated_at"
+type PropertySort struct {
+	Property  string `json:"property"`
+	Direction string `json:"direction"`
+}
+
+// PropertyQuery selects pages of a collection
+type PropertyQuery struct {
+	Filters []PropertyFilter `json:"filters"`
+	Sorts   []PropertySort   `json:"sorts"`
+	Limit   int              `json:"limit"`
+	Offset  int              `json:"offset"`
+}
+
+// pageColumnSorts are the page columns queries can sort by
+var pageColumnSorts = map[string]string{
+	"title":      "title",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+}
+
+// CollectionQuery builds the filtered and sorted query for a collection's
+// pages, without limit or offset. Filters and sorts referring to unknown
+// properties or using unsupported operators return a
+// *PropertyValidationError.
+func CollectionQuery(db *gorm.DB, collection *Collection, filters []PropertyFilter, sorts []PropertySort) (*gorm.DB, error) {
+	query, err := CollectionFilter(db, collection, filters)
+	if err != nil {
+		return nil, err
+	}
+	order, err := CollectionOrder(collection, sorts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Allow the query to be reused for counting and fetching
+	return query.Order(clause.OrderBy{Expression: order}).Session(&gorm.Session{}), nil
+}
+
+// CollectionFilter builds the query for a collection's pages matching all
+// filters
+func CollectionFilter(db *gorm.DB, collection *Collection, filters []PropertyFilter) (*gorm.DB, error) {
+	query := db.Model(&Page{}).Where("collection_id = ?", collection.ID)
+
+	for _, filter := range filters {
+		def, ok := collection.Definition(filter.Property)
+		if !ok {
+			return nil, &PropertyValidationError{Property: filter.Property, Message: "unknown property"}
+		}
+		expr, err := propertyFilterExpr(def, filter)
+		if err != nil {
+			return nil, &PropertyValidationError{Property: filter.Property, Message: err.Error()}
+		}
+		query = query.Where(expr)
+	}
+
+	return query, nil
+}
+
+// CollectionOrder builds the ORDER BY terms for sorts, ending with the page
+// ID so results are stable across pages. All terms go into one expression,
+// as GORM cannot combine expression and column ORDER BY clauses.
+func CollectionOrder(collection *Collection, sorts []PropertySort) (clause.Expr, error) {
+	var orderSQL []string
+	var orderVars []interface{}
+	for _, sort := range sorts {
+		direction := "ASC"
+		switch strings.ToLower(sort.Direction) {
+		case "", "asc":
+		case "desc":
+			direction = "DESC"
+		default:
+			return clause.Expr{}, &PropertyValidationError{Property: sort.Property, Message: "direction must be asc or desc"}
+		}
+
+		if column, ok := pageColumnSorts[sort.Property]; ok {
+			orderSQL = append(orderSQL, column+" "+direction)
+			continue
+		}
+		def, ok := collection.Definition(sort.Property)
+		if !ok {
+			return clause.Expr{}, &PropertyValidationError{Property: sort.Property, Message: "unknown property"}
+		}
+		sql, vars := propertyValueSQL(def)
+		orderSQL = append(orderSQL, sql+" "+direction+" NULLS LAST")
+		orderVars = append(orderVars, vars...)
+	}
+	orderSQL = append(orderSQL, "id ASC")
+
+	return clause.Expr{
+		SQL:                strings.Join(orderSQL, ", "),
+		Vars:               orderVars,
+		WithoutParentheses: true,
+	}, nil
+}
+
+// propertyValueSQL returns the expression a property is compared and
+// sorted by, with its variables
+func propertyValueSQL(def *PropertyDefinition) (string, []interface{}) {
+	switch def.Type {
+	case PropertyNumber:
+		// Values are validated on write, but the type check keeps a schema
+		// change from breaking the cast
+		return "(CASE WHEN jsonb_typeof(properties -> ?::text) = 'number' THEN (properties ->> ?::text)::numeric END)", []interface{}{def.ID, def.ID}
+	case PropertyCheckbox:
+		return "((properties -> ?::text) = 'true'::jsonb)", []interface{}{def.ID}
+	case PropertyMultiSelect:
+		return "jsonb_array_length(COALESCE(properties -> ?::text, '[]'::jsonb))", []interface{}{def.ID}
+	}
+	return "(properties ->> ?::text)", []interface{}{def.ID}
+}
+
+// propertyFilterExpr builds the SQL condition for a filter
+func propertyFilterExpr(def *PropertyDefinition, filter PropertyFilter) (clause.Expr, error) {
+	id := def.ID
+	switch filter.Operator {
+	case "empty":
+		return clause.Expr{SQL: "(properties -> ?::text) IS NULL", Vars: []interface{}{id}}, nil
+	case "not_empty":
+		return clause.Expr{SQL: "(properties -> ?::text) IS NOT NULL", Vars: []interface{}{id}}, nil
+	}
+
+	switch def.Type {
+	case PropertyText, PropertyAssignee, PropertyURL, PropertyStatus, PropertySelect:
+		if filter.Operator == "in" && (def.Type == PropertyStatus || def.Type == PropertySelect) {
+			values, err := stringList(filter.Value)
+			if err != nil {
+				return clause.Expr{}, err
+			}
+			return clause.Expr{SQL: "(properties ->> ?::text) IN ?", Vars: []interface{}{id, values}}, nil
+		}
+		s, ok := filter.Value.(string)
+		if !ok {
+			return clause.Expr{}, errors.New("value must be a string")
+		}
+		switch filter.Operator {
+		case "eq":
+			return clause.Expr{SQL: "(properties ->> ?::text) = ?", Vars: []interface{}{id, s}}, nil
+		case "neq":
+			return clause.Expr{SQL: "(properties ->> ?::text) IS DISTINCT FROM ?", Vars: []interface{}{id, s}}, nil
+		case "contains":
+			if def.Type == PropertyStatus || def.Type == PropertySelect {
+				break
+			}
+			return clause.Expr{SQL: "(properties ->> ?::text) ILIKE ?", Vars: []interface{}{id, "%" + escapeLike(s) + "%"}}, nil
+		}
+
+	case PropertyMultiSelect:
+		values, err := stringList(filter.Value)
+		if err != nil {
+			return clause.Expr{}, err
+		}
+		switch filter.Operator {
+		case "contains", "contains_all":
+			encoded, _ := json.Marshal(values)
+			return clause.Expr{SQL: "(properties -> ?::text) @> ?::jsonb", Vars: []interface{}{id, string(encoded)}}, nil
+		case "contains_any":
+			exprs := make([]string, len(values))
+			vars := make([]interface{}, 0, len(values)*2)
+			for i, value := range values {
+				encoded, _ := json.Marshal([]string{value})
+				exprs[i] = "(properties -> ?::text) @> ?::jsonb"
+				vars = append(vars, id, string(encoded))
+			}
+			return clause.Expr{SQL: "(" + strings.Join(exprs, " OR ") + ")", Vars: vars}, nil
+		}
+
+	case PropertyNumber:
+		n, ok := filter.Value.(float64)
+		if !ok {
+			return clause.Expr{}, errors.New("value must be a number")
+		}
+		ops := map[string]string{"eq": "=", "neq": "<>", "gt": ">", "gte": ">=", "lt": "<", "lte": "<="}
+		if op, ok := ops[filter.Operator]; ok {
+			sql, vars := propertyValueSQL(def)
+			return clause.Expr{SQL: sql + " " + op + " ?", Vars: append(vars, n)}, nil
+		}
+
+	case PropertyDate:
+		s, ok := filter.Value.(string)
+		if !ok {
+			return clause.Expr{}, errors.New("value must be a date string")
+		}
+		date, err := NormalizePropertyDate(s)
+		if err != nil {
+			return clause.Expr{}, err
+		}
+		// Dates compare as strings; a date-only bound covers the whole day
+		// of stored times by comparing only their first ten characters
+		column := "(properties ->> ?::text)"
+		if len(date) == len("2006-01-02") {
+			column = "left(properties ->> ?::text, 10)"
+		}
+		ops := map[string]string{"eq": "=", "before": "<", "after": ">", "on_or_before": "<=", "on_or_after": ">="}
+		if op, ok := ops[filter.Operator]; ok {
+			return clause.Expr{SQL: column + " " + op + " ?", Vars: []interface{}{id, date}}, nil
+		}
+
+	case PropertyCheckbox:
+		b, ok := filter.Value.(bool)
+		if !ok {
+			return clause.Expr{}, errors.New("value must be true or false")
+		}
+		if filter.Operator == "eq" {
+			// Unset checkboxes count as unchecked
+			return clause.Expr{SQL: "COALESCE((properties -> ?::text) = 'true'::jsonb, false) = ?", Vars: []interface{}{id, b}}, nil
+		}
+	}
+
+	return clause.Expr{}, fmt.Errorf("operator %q is not supported for %s properties", filter.Operator, def.Type)
+}
+
+// stringList reads a filter value given as a string or an array of strings
+func stringList(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, errors.New("value must be a string or an array of strings")
+			}
+			values = append(values, s)
+		}
+		if len(values) == 0 {
+			return nil, errors.New("value must not be empty")
+		}
+		return values, nil
+	}
+	return nil, errors.New("value must be a string or an array of strings")
+}
@@ -0,0 +1,309 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+const (
+	// CommentStatusOpen lists threads that are not resolved
+	CommentStatusOpen = "open"
+	// CommentStatusResolved lists resolved threads
+	CommentStatusResolved = "resolved"
+	// CommentStatusAll lists every thread
+	CommentStatusAll = "all"
+)
+
+// ErrInvalidAnchor is returned when a comment anchor does not match the page
+var ErrInvalidAnchor = errors.New("invalid comment anchor")
+
+// Comment is a comment on a page. Top-level comments start a thread and
+// carry the anchor; replies point at the thread through ParentID.
+//
+// The anchor is a block ID from the content (the blockId attribute) plus an
+// optional character range within that block's text. A comment without a
+// block ID is about the page as a whole. QuotedText keeps the anchored text
+// so the anchor can be found again after the block is edited.
+type Comment struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	PageID      uint       `json:"page_id" gorm:"not null;index"`
+	ParentID    *uint      `json:"parent_id" gorm:"index"`
+	Author      string     `json:"author"`
+	Body        string     `json:"body" gorm:"type:text;not null"`
+	BlockID     string     `json:"block_id"`
+	AnchorStart *int       `json:"anchor_start"`
+	AnchorEnd   *int       `json:"anchor_end"`
+	QuotedText  string     `json:"quoted_text"`
+	Resolved    bool       `json:"resolved" gorm:"not null;default:false"`
+	ResolvedBy  string     `json:"resolved_by,omitempty"`
+	ResolvedAt  *time.Time `json:"resolved_at"`
+	Replies     []Comment  `json:"replies,omitempty" gorm:"foreignKey:ParentID"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// contentBlock is a block of page content that has a block ID
+type contentBlock struct {
+	id   string
+	text []rune
+}
+
+// contentBlocks returns the blocks of page content that carry a blockId,
+// in document order, with the text of each block
+func contentBlocks(content datatypes.JSON) []contentBlock {
+	doc, err := ParseDocument(content)
+	if err != nil {
+		return nil
+	}
+
+	var blocks []contentBlock
+	var walk func(node map[string]interface{})
+	walk = func(node map[string]interface{}) {
+		attrs, _ := node["attrs"].(map[string]interface{})
+		if id, _ := attrs["blockId"].(string); id != "" {
+			blocks = append(blocks, contentBlock{id: id, text: []rune(inlineText(node))})
+		}
+		children, _ := node["content"].([]interface{})
+		for _, raw := range children {
+			if child, ok := raw.(map[string]interface{}); ok {
+				walk(child)
+			}
+		}
+	}
+	walk(doc)
+
+	return blocks
+}
+
+// inlineText returns the text inside a node without block separators
+func inlineText(node map[string]interface{}) string {
+	switch node["type"] {
+	case "text":
+		text, _ := node["text"].(string)
+		return text
+	case "hardBreak":
+		return "\n"
+	case "mention":
+		attrs, _ := node["attrs"].(map[string]interface{})
+		if label, ok := attrs["label"].(string); ok {
+			return "@" + label
+		}
+		return ""
+	}
+
+	var b strings.Builder
+	children, _ := node["content"].([]interface{})
+	for _, raw := range children {
+		if child, ok := raw.(map[string]interface{}); ok {
+			b.WriteString(inlineText(child))
+		}
+	}
+	return b.String()
+}
+
+// ResolveAnchor checks that blockID exists in the content and that the
+// range, when given, lies within its text. It returns the anchored text.
+// Offsets count characters, not bytes.
+func ResolveAnchor(content datatypes.JSON, blockID string, start, end *int) (string, error) {
+	if (start == nil) != (end == nil) {
+		return "", ErrInvalidAnchor
+	}
+	for _, block := range contentBlocks(content) {
+		if block.id != blockID {
+			continue
+		}
+		if start == nil {
+			return "", nil
+		}
+		if *start < 0 || *start > *end || *end > len(block.text) {
+			return "", ErrInvalidAnchor
+		}
+		return string(block.text[*start:*end]), nil
+	}
+	return "", ErrInvalidAnchor
+}
+
+// CreateComment creates a comment or a reply
+func CreateComment(db *gorm.DB, comment *Comment) error {
+	return db.Create(comment).Error
+}
+
+// GetCommentByID retrieves a comment by ID
+func GetCommentByID(db *gorm.DB, id uint) (*Comment, error) {
+	var comment Comment
+	if err := db.First(&comment, id).Error; err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// GetCommentThread retrieves a top-level comment with its replies
+func GetCommentThread(db *gorm.DB, id uint) (*Comment, error) {
+	var comment Comment
+	err := db.Preload("Replies", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at ASC, id ASC")
+	}).First(&comment, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// GetPageComments retrieves the comment threads of a page, oldest first,
+// filtered by status
+func GetPageComments(db *gorm.DB, pageID uint, status string) ([]Comment, error) {
+	query := db.Where("page_id = ? AND parent_id IS NULL", pageID)
+	switch status {
+	case CommentStatusOpen:
+		query = query.Where("resolved = ?", false)
+	case CommentStatusResolved:
+		query = query.Where("resolved = ?", true)
+	}
+
+	var comments []Comment
+	err := query.Preload("Replies", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at ASC, id ASC")
+	}).Order("created_at ASC, id ASC").Find(&comments).Error
+	return comments, err
+}
+
+// UpdateComment updates a comment
+func UpdateComment(db *gorm.DB, id uint, updates map[string]interface{}) error {
+	return db.Model(&Comment{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// SetCommentResolved resolves or reopens a thread
+func SetCommentResolved(db *gorm.DB, id uint, resolved bool, by string) error {
+	updates := map[string]interface{}{
+		"resolved":    resolved,
+		"resolved_by": "",
+		"resolved_at": nil,
+	}
+	if resolved {
+		updates["resolved_by"] = by
+		updates["resolved_at"] = time.Now()
+	}
+	return UpdateComment(db, id, updates)
+}
+
+// DeleteComment deletes a comment together with its replies
+func DeleteComment(db *gorm.DB, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("parent_id = ?", id).Delete(&Comment{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Comment{}, id).Error
+	})
+}
+
+// ReanchorComments moves the anchors of a page's threads after its content
+// changed and returns the threads whose anchor moved. A range whose text
+// was edited is looked up again by its quoted text, closest to where it
+// was. When the block is gone the quoted text is searched for in the other
+// blocks, and a thread that cannot be placed falls back to the page.
+func ReanchorComments(db *gorm.DB, pageID uint, content datatypes.JSON) ([]Comment, error) {
+	var comments []Comment
+	err := db.Where("page_id = ? AND parent_id IS NULL AND block_id <> ''", pageID).Find(&comments).Error
+	if err != nil || len(comments) == 0 {
+		return nil, err
+	}
+
+	blocks := contentBlocks(content)
+	var moved []Comment
+	for _, comment := range comments {
+		if !reanchor(&comment, blocks) {
+			continue
+		}
+		err := UpdateComment(db, comment.ID, map[string]interface{}{
+			"block_id":     comment.BlockID,
+			"anchor_start": comment.AnchorStart,
+			"anchor_end":   comment.AnchorEnd,
+		})
+		if err != nil {
+			return moved, err
+		}
+		moved = append(moved, comment)
+	}
+	return moved, nil
+}
+
+// reanchor updates a comment's anchor against the current blocks and
+// reports whether it changed
+func reanchor(comment *Comment, blocks []contentBlock) bool {
+	quoted := []rune(comment.QuotedText)
+
+	for _, block := range blocks {
+		if block.id != comment.BlockID {
+			continue
+		}
+		if comment.AnchorStart == nil {
+			return false
+		}
+		start, end := *comment.AnchorStart, *comment.AnchorEnd
+		if start >= 0 && start <= end && end <= len(block.text) && string(block.text[start:end]) == comment.QuotedText {
+			return false
+		}
+		if found := nearestIndex(block.text, quoted, start); found >= 0 {
+			comment.AnchorStart, comment.AnchorEnd = intPtr(found), intPtr(found+len(quoted))
+		} else {
+			// The quoted text was edited away; keep the block anchor
+			comment.AnchorStart, comment.AnchorEnd = nil, nil
+		}
+		return true
+	}
+
+	// The block was deleted. Follow the quoted text if it is unambiguous.
+	if len(quoted) > 0 {
+		var match *contentBlock
+		matches := 0
+		for i := range blocks {
+			if strings.Contains(string(blocks[i].text), comment.QuotedText) {
+				match = &blocks[i]
+				matches++
+			}
+		}
+		if matches == 1 {
+			found := nearestIndex(match.text, quoted, 0)
+			comment.BlockID = match.id
+			comment.AnchorStart, comment.AnchorEnd = intPtr(found), intPtr(found+len(quoted))
+			return true
+		}
+	}
+
+	comment.BlockID = ""
+	comment.AnchorStart, comment.AnchorEnd = nil, nil
+	return true
+}
+
+// nearestIndex returns the character offset of the occurrence of needle in
+// text closest to pos, or -1
+func nearestIndex(text, needle []rune, pos int) int {
+	if len(needle) == 0 {
+		return -1
+	}
+	best := -1
+	for i := 0; i+len(needle) <= len(text); i++ {
+		if string(text[i:i+len(needle)]) != string(needle) {
+			continue
+		}
+		if best < 0 || abs(i-pos) < abs(best-pos) {
+			best = i
+		}
+	}
+	return best
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func intPtr(n int) *int {
+	return &n
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"testing"
+
+	"gorm.io/datatypes"
+)
+
+// savedContent validates content the way a page save does
+func savedContent(t *testing.T, content string) datatypes.JSON {
+	t.Helper()
+	validated, err := ValidatePageContent(datatypes.JSON(content))
+	if err != nil {
+		t.Fatalf("ValidatePageContent() error = %v", err)
+	}
+	return validated
+}
+
+func TestCommentAnchorSurvivesSave(t *testing.T) {
+	// Content as the editor saves it, with a blockId on every block
+	saved := savedContent(t, `{"type":"doc","content":[
+		{"type":"heading","attrs":{"level":1,"blockId":"h1"},"content":[{"type":"text","text":"Plan"}]},
+		{"type":"paragraph","attrs":{"blockId":"p1"},"content":[{"type":"text","text":"Ship the release on Friday"}]},
+		{"type":"taskList","attrs":{"blockId":"l1"},"content":[
+			{"type":"taskItem","attrs":{"checked":false,"blockId":"t1"},"content":[
+				{"type":"paragraph","attrs":{"blockId":"p2"},"content":[{"type":"text","text":"Write notes"}]}]}]}]}`)
+
+	start, end := 20, 26
+	quoted, err := ResolveAnchor(saved, "p1", &start, &end)
+	if err != nil {
+		t.Fatalf("ResolveAnchor() error = %v", err)
+	}
+	if quoted != "Friday" {
+		t.Fatalf("ResolveAnchor() = %q, want %q", quoted, "Friday")
+	}
+	if _, err := ResolveAnchor(saved, "p2", nil, nil); err != nil {
+		t.Errorf("ResolveAnchor() on a nested block error = %v", err)
+	}
+
+	comment := Comment{BlockID: "p1", AnchorStart: &start, AnchorEnd: &end, QuotedText: quoted}
+
+	// Saving the same content again leaves the anchor alone
+	if reanchor(&comment, contentBlocks(saved)) {
+		t.Errorf("anchor moved after saving unchanged content: %+v", comment)
+	}
+
+	// The next autosave edits the block before the anchored text
+	edited := savedContent(t, `{"type":"doc","content":[
+		{"type":"heading","attrs":{"level":1,"blockId":"h1"},"content":[{"type":"text","text":"Plan"}]},
+		{"type":"paragraph","attrs":{"blockId":"p1"},"content":[{"type":"text","text":"Ship the new release on Friday"}]}]}`)
+	if !reanchor(&comment, contentBlocks(edited)) {
+		t.Fatal("anchor did not follow the edit")
+	}
+	if comment.BlockID != "p1" || comment.AnchorStart == nil || *comment.AnchorStart != 24 || *comment.AnchorEnd != 30 {
+		t.Errorf("anchor = %+v, want p1 at 24-30", comment)
+	}
+
+	// Content saved without block IDs loses the anchor
+	stripped := savedContent(t, `{"type":"doc","content":[
+		{"type":"paragraph","content":[{"type":"text","text":"Ship the new release on Friday"}]}]}`)
+	if !reanchor(&comment, contentBlocks(stripped)) || comment.BlockID != "" {
+		t.Errorf("anchor = %q, want the page", comment.BlockID)
+	}
+}
@@ -15,5 +15,5 @@ func InitDB(dsn string) (*gorm.DB, error) {
 }
 
 func AutoMigrate(db *gorm.DB) error {
-	return db.AutoMigrate(&Page{}, &Image{}, &File{})
+	return db.AutoMigrate(&Page{}, &Image{}, &File{}, &PageLink{}, &Tag{}, &PageTag{}, &Collection{}, &View{}, &Comment{}, &PageTask{}, &FeedToken{}, &Blob{})
 }
\ No newline at end of file
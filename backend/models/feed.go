@@ -0,0 +1,136 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FeedToken authenticates a calendar or page feed. Feed readers and
+// calendar apps cannot send headers, so the token is part of the feed URL.
+// A token with a User limits the feed to that person's work, matched
+// against @mentions in tasks and assignee properties; without one the
+// feed covers the whole workspace.
+//
+// The app has a single workspace without accounts or page ACLs, so these
+// scopes are the only permissions a feed has: it can read what the API
+// can, except templates, which are never part of a feed.
+type FeedToken struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	Name       string     `json:"name" gorm:"not null"`
+	Token      string     `json:"token" gorm:"not null;uniqueIndex"`
+	User       string     `json:"user"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// DatedPage is a page whose date property falls on Date
+type DatedPage struct {
+	PageID       uint      `json:"page_id"`
+	Title        string    `json:"title"`
+	PropertyID   string    `json:"property_id"`
+	PropertyName string    `json:"property_name"`
+	Date         string    `json:"date"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// CreateFeedToken creates a feed token with a new random token
+func CreateFeedToken(db *gorm.DB, feed *FeedToken) error {
+	token, err := GenerateToken()
+	if err != nil {
+		return err
+	}
+	feed.Token = token
+	return db.Create(feed).Error
+}
+
+// GetAllFeedTokens retrieves all feed tokens
+func GetAllFeedTokens(db *gorm.DB) ([]FeedToken, error) {
+	var feeds []FeedToken
+	err := db.Order("created_at DESC").Find(&feeds).Error
+	return feeds, err
+}
+
+// GetFeedToken retrieves a feed token by its token and records its use
+func GetFeedToken(db *gorm.DB, token string) (*FeedToken, error) {
+	var feed FeedToken
+	if err := db.Where("token = ?", token).First(&feed).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	db.Model(&FeedToken{}).Where("id = ?", feed.ID).Update("last_used_at", now)
+	feed.LastUsedAt = &now
+	return &feed, nil
+}
+
+// DeleteFeedToken revokes a feed token
+func DeleteFeedToken(db *gorm.DB, id uint) error {
+	return db.Delete(&FeedToken{}, id).Error
+}
+
+// GetDatedPages retrieves the values of every date property of pages in
+// collections, leaving out templates. With a user, only pages whose
+// assignee property names that user are included.
+func GetDatedPages(db *gorm.DB, user string) ([]DatedPage, error) {
+	collections, err := GetAllCollections(db)
+	if err != nil {
+		return nil, err
+	}
+
+	dated := []DatedPage{}
+	for i := range collections {
+		defs, err := collections[i].Definitions()
+		if err != nil {
+			return nil, err
+		}
+
+		var dates, assignees []PropertyDefinition
+		for _, def := range defs {
+			switch def.Type {
+			case PropertyDate:
+				dates = append(dates, def)
+			case PropertyAssignee:
+				assignees = append(assignees, def)
+			}
+		}
+		if len(dates) == 0 || (user != "" && len(assignees) == 0) {
+			continue
+		}
+
+		query := db.Where("collection_id = ? AND is_template = ?", collections[i].ID, false)
+		if user != "" {
+			match := db.Where("properties ->> ?::text = ?", assignees[0].ID, user)
+			for _, def := range assignees[1:] {
+				match = match.Or("properties ->> ?::text = ?", def.ID, user)
+			}
+			query = query.Where(match)
+		}
+
+		var pages []Page
+		if err := query.Select("id, title, properties, updated_at").Find(&pages).Error; err != nil {
+			return nil, err
+		}
+		for _, page := range pages {
+			var values map[string]interface{}
+			if err := json.Unmarshal(page.Properties, &values); err != nil {
+				continue
+			}
+			for _, def := range dates {
+				date, _ := values[def.ID].(string)
+				if date == "" {
+					continue
+				}
+				dated = append(dated, DatedPage{
+					PageID:       page.ID,
+					Title:        page.Title,
+					PropertyID:   def.ID,
+					PropertyName: def.Name,
+					Date:         date,
+					UpdatedAt:    page.UpdatedAt,
+				})
+			}
+		}
+	}
+	return dated, nil
+}
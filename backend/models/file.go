@@ -4,13 +4,16 @@ import (
 	"time"
 )
 
+// File is an uploaded file. Files with a Checksum share the Blob with that
+// digest, so Path is not unique; older files own their file.
 type File struct {
 	ID           uint      `gorm:"primaryKey" json:"id"`
 	Filename     string    `gorm:"not null" json:"filename"`
 	OriginalName string    `gorm:"not null" json:"original_name"`
 	ContentType  string    `gorm:"not null" json:"content_type"`
 	Size         int64     `gorm:"not null" json:"size"`
-	Path         string    `gorm:"not null;unique" json:"path"`
+	Path         string    `gorm:"not null;index" json:"path"`
+	Checksum     string    `gorm:"index" json:"checksum"`
 	PageID       *uint     `json:"page_id,omitempty"`
 	Page         *Page     `json:"page,omitempty" gorm:"constraint:OnDelete:SET NULL;"`
 	CreatedAt    time.Time `json:"created_at"`
@@ -24,6 +27,7 @@ type FileMetadata struct {
 	OriginalName string    `json:"original_name"`
 	ContentType  string    `json:"content_type"`
 	Size         int64     `json:"size"`
+	Checksum     string    `json:"checksum,omitempty"`
 	URL          string    `json:"url"`
 	PageID       *uint     `json:"page_id,omitempty"`
 	CreatedAt    time.Time `json:"created_at"`
@@ -37,6 +41,7 @@ func (f *File) ToMetadata(baseURL string) FileMetadata {
 		OriginalName: f.OriginalName,
 		ContentType:  f.ContentType,
 		Size:         f.Size,
+		Checksum:     f.Checksum,
 		URL:          baseURL + "/api/file/" + f.Filename,
 		PageID:       f.PageID,
 		CreatedAt:    f.CreatedAt,
@@ -3,10 +3,12 @@ package models
 import (
 	"time"
 
+	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
 
-// Image represents an uploaded image with metadata
+// Image represents an uploaded image with metadata. Images with a
+// Checksum share the Blob with that digest; older images own their files.
 type Image struct {
 	ID            uint      `json:"id" gorm:"primaryKey"`
 	Filename      string    `json:"filename" gorm:"not null"`
@@ -17,9 +19,83 @@ type Image struct {
 	Width         int       `json:"width"`
 	Height        int       `json:"height"`
 	ContentType   string    `json:"content_type"`
+	Checksum      string    `json:"checksum" gorm:"index"`
 	PageID        *uint     `json:"page_id" gorm:"index"`
 	CreatedAt     time.Time `json:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at"`
+
+	// EXIF data read at upload. It is kept here even when it is stripped
+	// from the stored files.
+	CameraMake   string     `json:"camera_make"`
+	CameraModel  string     `json:"camera_model"`
+	TakenAt      *time.Time `json:"taken_at"`
+	Orientation  int        `json:"orientation"`
+	GPSLatitude  *float64   `json:"gps_latitude"`
+	GPSLongitude *float64   `json:"gps_longitude"`
+	// MetadataRemoved lists the EXIF tags and metadata blocks removed from
+	// the stored files, such as "GPS", "Model" or "XMP"
+	MetadataRemoved datatypes.JSON `json:"metadata_removed" gorm:"type:jsonb"`
+
+	// Placeholders shown while the image loads: a blurhash, a tiny
+	// base64 data URL and the dominant color as "#rrggbb"
+	BlurHash      string `json:"blurhash"`
+	Preview       string `json:"preview" gorm:"type:text"`
+	DominantColor string `json:"dominant_color"`
+
+	// Variants lists the resized copies generated at upload, narrowest
+	// first, and Srcset joins them with the original for an img tag
+	Variants datatypes.JSON `json:"variants" gorm:"type:jsonb"`
+	Srcset   string         `json:"srcset" gorm:"type:text"`
+
+	// Transform is the edit applied to the original upload. Edited images
+	// keep a reference to the original blob, identified by
+	// OriginalChecksum, so the edit can be changed or reverted without
+	// loss; the other fields describe the edited file.
+	Transform        datatypes.JSON `json:"transform" gorm:"type:jsonb"`
+	OriginalChecksum string         `json:"original_checksum" gorm:"index"`
+	OriginalPath     string         `json:"original_path"`
+}
+
+// ImageTransform is a non-destructive edit of an image. The crop, in
+// pixels of the original, is applied first, followed by the clockwise
+// rotation and the flips. The focal point does not change the pixels; it
+// marks the part of the result to keep in view, from 0 to 1 on each axis.
+type ImageTransform struct {
+	Crop   *ImageCrop  `json:"crop,omitempty"`
+	Rotate int         `json:"rotate,omitempty"`
+	FlipH  bool        `json:"flip_h,omitempty"`
+	FlipV  bool        `json:"flip_v,omitempty"`
+	Focal  *FocalPoint `json:"focal,omitempty"`
+}
+
+// ImageCrop is a rectangle of an image in pixels
+type ImageCrop struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// FocalPoint is a point of an image relative to its size
+type FocalPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// ChangesPixels reports whether the transform produces a file different
+// from the original
+func (t *ImageTransform) ChangesPixels() bool {
+	return t != nil && (t.Crop != nil || t.Rotate != 0 || t.FlipH || t.FlipV)
+}
+
+// ImageVariant is a resized copy of an image stored next to it
+type ImageVariant struct {
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Size   int64  `json:"size"`
+	Format string `json:"format"`
+	Path   string `json:"path"`
+	URL    string `json:"url"`
 }
 
 // CreateImage creates a new image record
@@ -47,6 +123,16 @@ func GetImageByFilename(db *gorm.DB, filename string) (*Image, error) {
 	return &image, nil
 }
 
+// GetImageByPath retrieves an image by its path relative to the uploads directory
+func GetImageByPath(db *gorm.DB, path string) (*Image, error) {
+	var image Image
+	err := db.Where("path = ?", path).First(&image).Error
+	if err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
 // GetImagesByPageID retrieves all images associated with a page
 func GetImagesByPageID(db *gorm.DB, pageID uint) ([]Image, error) {
 	var images []Image
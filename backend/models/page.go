@@ -2,6 +2,9 @@ package models
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
 	"time"
 
 	"gorm.io/datatypes"
@@ -9,11 +12,24 @@ import (
 )
 
 type Page struct {
-	ID        uint           `json:"id" gorm:"primaryKey"`
-	Title     string         `json:"title" gorm:"not null"`
-	Content   datatypes.JSON `json:"content" gorm:"type:jsonb"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
+	ID           uint           `json:"id" gorm:"primaryKey"`
+	Title        string         `json:"title" gorm:"not null"`
+	Content      datatypes.JSON `json:"content" gorm:"type:jsonb"`
+	ParentID     *uint          `json:"parent_id" gorm:"index"`
+	IsTemplate   bool           `json:"is_template" gorm:"not null;default:false"`
+	CollectionID *uint          `json:"collection_id" gorm:"index"`
+	Properties   datatypes.JSON `json:"properties" gorm:"type:jsonb"`
+	Author       string         `json:"author"`
+	UpdatedBy    string         `json:"updated_by"`
+	PublishSlug  *string        `json:"publish_slug,omitempty" gorm:"uniqueIndex"`
+	PublishedAt  *time.Time     `json:"published_at"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+
+	// Excerpt is the start of the content's text, kept for summaries so
+	// listings need not load the content. It is nil for pages saved
+	// before it was added.
+	Excerpt *string `json:"-" gorm:"type:text"`
 }
 
 // ImageReference represents an image reference within page content
@@ -34,6 +50,8 @@ type BlockContent struct {
 
 // CreatePage creates a new page
 func CreatePage(db *gorm.DB, page *Page) error {
+	excerpt := Excerpt(page.Content, PageExcerptLength)
+	page.Excerpt = &excerpt
 	return db.Create(page).Error
 }
 
@@ -54,14 +72,59 @@ func GetAllPages(db *gorm.DB) ([]Page, error) {
 	return pages, err
 }
 
-// UpdatePage updates an existing page
+// UpdatePage updates an existing page, refreshing its excerpt when the
+// content changes. updated_by is cleared unless the update names an editor.
 func UpdatePage(db *gorm.DB, id uint, updates map[string]interface{}) error {
+	if _, ok := updates["updated_by"]; !ok {
+		updates["updated_by"] = ""
+	}
+	if content, ok := updates["content"].(datatypes.JSON); ok {
+		updates["excerpt"] = Excerpt(content, PageExcerptLength)
+	}
 	return db.Model(&Page{}).Where("id = ?", id).Updates(updates).Error
 }
 
-// DeletePage deletes a page
+// DeletePage deletes a page. Its subpages are moved up to the deleted
+// page's parent so they are not left pointing at a missing page.
 func DeletePage(db *gorm.DB, id uint) error {
-	return db.Delete(&Page{}, id).Error
+	return db.Transaction(func(tx *gorm.DB) error {
+		var page Page
+		if err := tx.First(&page, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		if err := tx.Model(&Page{}).Where("parent_id = ?", id).Update("parent_id", page.ParentID).Error; err != nil {
+			return err
+		}
+		// Links from the page go with it; links to it are kept as dangling
+		if err := tx.Where("source_page_id = ?", id).Delete(&PageLink{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("page_id = ?", id).Delete(&PageTag{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("page_id = ?", id).Delete(&Comment{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("page_id = ?", id).Delete(&PageTask{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Page{}, id).Error
+	})
+}
+
+// GetChildPages retrieves the direct subpages of a page
+func GetChildPages(db *gorm.DB, parentID uint) ([]Page, error) {
+	var pages []Page
+	err := db.Where("parent_id = ?", parentID).Order("created_at ASC").Find(&pages).Error
+	return pages, err
+}
+
+// PageURL returns the in-app path used to link to a page from content
+func PageURL(id uint) string {
+	return fmt.Sprintf("/pages/%d", id)
 }
 
 // ExtractImageReferences extracts all image references from page content
@@ -79,10 +142,15 @@ func ExtractImageReferences(content datatypes.JSON) ([]uint, error) {
 
 	// Extract image IDs from the content structure
 	extractFromBlock := func(block map[string]interface{}) {
-		if blockType, ok := block["type"].(string); ok && blockType == "image" {
+		if blockType, ok := block["type"].(string); ok && (blockType == "image" || blockType == "resizableImage") {
 			if attrs, ok := block["attrs"].(map[string]interface{}); ok {
 				if imageID, ok := attrs["image_id"].(float64); ok {
 					imageIDs = append(imageIDs, uint(imageID))
+				} else if imageID, ok := attrs["data-image-id"].(string); ok {
+					// The editor stores the ID as a string data attribute
+					if id, err := strconv.ParseUint(imageID, 10, 32); err == nil {
+						imageIDs = append(imageIDs, uint(id))
+					}
 				}
 			}
 		}
@@ -127,12 +195,36 @@ func UpdateImageReferences(db *gorm.DB, pageID uint, content datatypes.JSON) err
 		return err
 	}
 
-	// Then, link the referenced images
+	// Then, link the referenced images. Images owned by another page are
+	// left alone, so content that still points at a template's or original's
+	// images cannot take them over.
 	if len(imageIDs) > 0 {
-		if err := db.Model(&Image{}).Where("id IN ?", imageIDs).Update("page_id", pageID).Error; err != nil {
+		if err := db.Model(&Image{}).Where("id IN ? AND page_id IS NULL", imageIDs).Update("page_id", pageID).Error; err != nil {
 			return err
 		}
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// UpdateContentIndexes refreshes everything derived from a page's content:
+// image ownership, links to other pages, hashtags and tasks. Each index is
+// refreshed even if another fails, and the errors are returned together.
+// Comment anchors are left to the caller, which notifies about the moved
+// comments.
+func UpdateContentIndexes(db *gorm.DB, pageID uint, content datatypes.JSON) error {
+	var errs []error
+	if err := UpdateImageReferences(db, pageID, content); err != nil {
+		errs = append(errs, fmt.Errorf("image references: %w", err))
+	}
+	if err := UpdatePageLinks(db, pageID, content); err != nil {
+		errs = append(errs, fmt.Errorf("page links: %w", err))
+	}
+	if err := UpdatePageHashtags(db, pageID, content); err != nil {
+		errs = append(errs, fmt.Errorf("hashtags: %w", err))
+	}
+	if err := UpdatePageTasks(db, pageID, content); err != nil {
+		errs = append(errs, fmt.Errorf("tasks: %w", err))
+	}
+	return errors.Join(errs...)
+}
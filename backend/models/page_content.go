@@ -0,0 +1,501 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"gorm.io/datatypes"
+)
+
+const (
+	// MaxContentSize is the largest serialized document accepted (2MB)
+	MaxContentSize = 2 * 1024 * 1024
+	// MaxContentDepth limits how deeply nodes may be nested
+	MaxContentDepth = 32
+	// MaxContentNodes limits the total number of nodes in a document
+	MaxContentNodes = 50000
+)
+
+// ContentValidationError describes why a page document was rejected.
+// Path points at the offending node, e.g. "doc.content[2].marks[0].attrs.href".
+type ContentValidationError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e *ContentValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// attrKind describes the accepted JSON type of a node or mark attribute
+type attrKind int
+
+const (
+	attrString attrKind = iota
+	attrInt
+	attrBool
+	attrNumberOrString
+	attrLinkURL
+	attrImageURL
+	attrIntList
+)
+
+// attrSpec describes a single allowed attribute
+type attrSpec struct {
+	kind     attrKind
+	min, max int
+}
+
+// nodeSpec describes an allowed node type
+type nodeSpec struct {
+	attrs map[string]attrSpec
+	text  bool
+	leaf  bool
+}
+
+var imageAttrs = map[string]attrSpec{
+	"src":           {kind: attrImageURL},
+	"alt":           {kind: attrString},
+	"title":         {kind: attrString},
+	"width":         {kind: attrNumberOrString},
+	"height":        {kind: attrNumberOrString},
+	"image_id":      {kind: attrInt, min: 1},
+	"data-image-id": {kind: attrNumberOrString},
+	"data-width":    {kind: attrNumberOrString},
+	"data-height":   {kind: attrNumberOrString},
+	"blockId":       {kind: attrString},
+}
+
+var tableCellAttrs = map[string]attrSpec{
+	"colspan":  {kind: attrInt, min: 1, max: 1000},
+	"rowspan":  {kind: attrInt, min: 1, max: 1000},
+	"colwidth": {kind: attrIntList},
+}
+
+// blockAttrs holds the stable block ID that comments are anchored to
+var blockAttrs = map[string]attrSpec{
+	"blockId": {kind: attrString},
+}
+
+// contentNodeSpecs lists the TipTap node types the editor supports
+var contentNodeSpecs = map[string]nodeSpec{
+	"doc":            {},
+	"paragraph":      {attrs: blockAttrs},
+	"text":           {text: true, leaf: true},
+	"heading":        {attrs: map[string]attrSpec{"level": {kind: attrInt, min: 1, max: 6}, "blockId": {kind: attrString}}},
+	"blockquote":     {attrs: blockAttrs},
+	"bulletList":     {attrs: blockAttrs},
+	"orderedList":    {attrs: map[string]attrSpec{"start": {kind: attrInt, min: 0, max: 1 << 30}, "blockId": {kind: attrString}}},
+	"listItem":       {attrs: blockAttrs},
+	"taskList":       {attrs: blockAttrs},
+	"taskItem":       {attrs: map[string]attrSpec{"checked": {kind: attrBool}, "blockId": {kind: attrString}}},
+	"codeBlock":      {attrs: map[string]attrSpec{"language": {kind: attrString}, "blockId": {kind: attrString}}},
+	"horizontalRule": {leaf: true},
+	"hardBreak":      {leaf: true},
+	"image":          {attrs: imageAttrs, leaf: true},
+	"resizableImage": {attrs: imageAttrs, leaf: true},
+	"table":          {attrs: blockAttrs},
+	"tableRow":       {},
+	"tableHeader":    {attrs: tableCellAttrs},
+	"tableCell":      {attrs: tableCellAttrs},
+	"mention": {attrs: map[string]attrSpec{
+		"id":    {kind: attrNumberOrString},
+		"label": {kind: attrString},
+		"type":  {kind: attrString},
+	}, leaf: true},
+}
+
+// contentMarkSpecs lists the TipTap mark types the editor supports
+var contentMarkSpecs = map[string]map[string]attrSpec{
+	"bold":      nil,
+	"italic":    nil,
+	"strike":    nil,
+	"code":      nil,
+	"underline": nil,
+	"link": {
+		"href":   {kind: attrLinkURL},
+		"target": {kind: attrString},
+		"rel":    {kind: attrString},
+		"class":  {kind: attrString},
+	},
+}
+
+// safeLinkSchemes are the URL schemes allowed in link hrefs
+var safeLinkSchemes = map[string]bool{
+	"http":   true,
+	"https":  true,
+	"mailto": true,
+	"tel":    true,
+}
+
+// safeImageSchemes are the URL schemes allowed in image sources
+var safeImageSchemes = map[string]bool{
+	"http":  true,
+	"https": true,
+}
+
+// IsSafeLinkURL reports whether href is a relative URL or uses an allowed scheme
+func IsSafeLinkURL(href string) bool {
+	return isSafeURL(href, safeLinkSchemes)
+}
+
+// IsSafeImageURL reports whether src is a relative URL, an allowed scheme
+// or an inline raster data URL
+func IsSafeImageURL(src string) bool {
+	lower := strings.ToLower(strings.TrimSpace(src))
+	if strings.HasPrefix(lower, "data:") {
+		for _, prefix := range []string{"data:image/png;", "data:image/jpeg;", "data:image/gif;", "data:image/webp;"} {
+			if strings.HasPrefix(lower, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	return isSafeURL(src, safeImageSchemes)
+}
+
+func isSafeURL(raw string, schemes map[string]bool) bool {
+	// Browsers ignore control characters and whitespace inside schemes,
+	// so "java\tscript:" must be treated the same as "javascript:"
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, raw)
+	if cleaned == "" {
+		return false
+	}
+
+	u, err := url.Parse(cleaned)
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "" {
+		// Relative URLs and fragments are always allowed
+		return true
+	}
+	return schemes[strings.ToLower(u.Scheme)]
+}
+
+// ParseDocument decodes page content and returns its root "doc" node,
+// accepting both the {"doc": {...}} wrapper and a bare doc node
+func ParseDocument(content datatypes.JSON) (map[string]interface{}, error) {
+	if len(content) == 0 {
+		return map[string]interface{}{"type": "doc"}, nil
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, err
+	}
+	if doc, ok := data["doc"].(map[string]interface{}); ok {
+		return doc, nil
+	}
+	return data, nil
+}
+
+// ExtractPlainText returns the text of page content with blocks separated
+// by newlines
+func ExtractPlainText(content datatypes.JSON) string {
+	doc, err := ParseDocument(content)
+	if err != nil {
+		return ""
+	}
+
+	var b strings.Builder
+	var walk func(node map[string]interface{})
+	walk = func(node map[string]interface{}) {
+		switch node["type"] {
+		case "text":
+			text, _ := node["text"].(string)
+			b.WriteString(text)
+			return
+		case "hardBreak":
+			b.WriteString("\n")
+			return
+		case "mention":
+			attrs, _ := node["attrs"].(map[string]interface{})
+			if label, ok := attrs["label"].(string); ok {
+				b.WriteString("@" + label)
+			}
+			return
+		}
+
+		children, _ := node["content"].([]interface{})
+		for _, raw := range children {
+			if child, ok := raw.(map[string]interface{}); ok {
+				walk(child)
+			}
+		}
+		if t, _ := node["type"].(string); t != "doc" && t != "text" && b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+			b.WriteString("\n")
+		}
+	}
+	walk(doc)
+
+	return strings.TrimSpace(b.String())
+}
+
+// Excerpt returns the first maxRunes characters of the content's text on a
+// single line, with an ellipsis when it was truncated
+func Excerpt(content datatypes.JSON, maxRunes int) string {
+	text := strings.Join(strings.Fields(ExtractPlainText(content)), " ")
+	runes := []rune(text)
+	if len(runes) <= maxRunes {
+		return text
+	}
+	return strings.TrimSpace(string(runes[:maxRunes])) + "…"
+}
+
+// contentValidator walks a document, validating it and building a sanitized copy
+type contentValidator struct {
+	nodes int
+}
+
+// ValidatePageContent checks a TipTap document against the supported schema
+// and returns a sanitized copy. Unknown attributes are dropped and link marks
+// with unsafe hrefs are removed; structural problems are reported as a
+// *ContentValidationError. Both the {"doc": {...}} wrapper and a bare doc
+// node are accepted, and the input's shape is preserved.
+func ValidatePageContent(content datatypes.JSON) (datatypes.JSON, error) {
+	if len(content) > MaxContentSize {
+		return nil, &ContentValidationError{
+			Path:    "content",
+			Message: fmt.Sprintf("document exceeds the maximum size of %d bytes", MaxContentSize),
+		}
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, &ContentValidationError{Path: "content", Message: "content must be a JSON object"}
+	}
+
+	v := &contentValidator{}
+	var result interface{}
+	if doc, ok := data["doc"]; ok {
+		clean, err := v.validateRoot(doc, "doc")
+		if err != nil {
+			return nil, err
+		}
+		result = map[string]interface{}{"doc": clean}
+	} else {
+		clean, err := v.validateRoot(data, "doc")
+		if err != nil {
+			return nil, err
+		}
+		result = clean
+	}
+
+	out, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	return datatypes.JSON(out), nil
+}
+
+func (v *contentValidator) validateRoot(raw interface{}, path string) (map[string]interface{}, error) {
+	root, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, &ContentValidationError{Path: path, Message: "document must be an object"}
+	}
+	if t, _ := root["type"].(string); t != "doc" {
+		return nil, &ContentValidationError{Path: path + ".type", Message: `root node must be of type "doc"`}
+	}
+	return v.validateNode(root, path, 0)
+}
+
+func (v *contentValidator) validateNode(raw interface{}, path string, depth int) (map[string]interface{}, error) {
+	if depth > MaxContentDepth {
+		return nil, &ContentValidationError{Path: path, Message: fmt.Sprintf("document is nested deeper than %d levels", MaxContentDepth)}
+	}
+	v.nodes++
+	if v.nodes > MaxContentNodes {
+		return nil, &ContentValidationError{Path: path, Message: fmt.Sprintf("document contains more than %d nodes", MaxContentNodes)}
+	}
+
+	node, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, &ContentValidationError{Path: path, Message: "node must be an object"}
+	}
+
+	nodeType, ok := node["type"].(string)
+	if !ok {
+		return nil, &ContentValidationError{Path: path + ".type", Message: "node type is missing"}
+	}
+	spec, ok := contentNodeSpecs[nodeType]
+	if !ok {
+		return nil, &ContentValidationError{Path: path + ".type", Message: fmt.Sprintf("unsupported node type %q", nodeType)}
+	}
+	if nodeType == "doc" && depth > 0 {
+		return nil, &ContentValidationError{Path: path + ".type", Message: `"doc" is only allowed as the root node`}
+	}
+
+	clean := map[string]interface{}{"type": nodeType}
+
+	if spec.text {
+		text, ok := node["text"].(string)
+		if !ok || text == "" {
+			return nil, &ContentValidationError{Path: path + ".text", Message: "text nodes must have non-empty text"}
+		}
+		clean["text"] = text
+	}
+
+	if rawAttrs, ok := node["attrs"]; ok && rawAttrs != nil {
+		attrs, err := validateAttrs(rawAttrs, spec.attrs, path+".attrs")
+		if err != nil {
+			return nil, err
+		}
+		if len(attrs) > 0 {
+			clean["attrs"] = attrs
+		}
+	}
+
+	if rawMarks, ok := node["marks"]; ok && rawMarks != nil {
+		if !spec.text {
+			return nil, &ContentValidationError{Path: path + ".marks", Message: "marks are only allowed on text nodes"}
+		}
+		marks, err := validateMarks(rawMarks, path+".marks")
+		if err != nil {
+			return nil, err
+		}
+		if len(marks) > 0 {
+			clean["marks"] = marks
+		}
+	}
+
+	if rawContent, ok := node["content"]; ok && rawContent != nil {
+		if spec.leaf {
+			return nil, &ContentValidationError{Path: path + ".content", Message: fmt.Sprintf("%q nodes cannot have content", nodeType)}
+		}
+		children, ok := rawContent.([]interface{})
+		if !ok {
+			return nil, &ContentValidationError{Path: path + ".content", Message: "content must be an array"}
+		}
+		cleanChildren := make([]interface{}, 0, len(children))
+		for i, child := range children {
+			cleanChild, err := v.validateNode(child, fmt.Sprintf("%s.content[%d]", path, i), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			cleanChildren = append(cleanChildren, cleanChild)
+		}
+		clean["content"] = cleanChildren
+	}
+
+	return clean, nil
+}
+
+func validateMarks(raw interface{}, path string) ([]interface{}, error) {
+	marks, ok := raw.([]interface{})
+	if !ok {
+		return nil, &ContentValidationError{Path: path, Message: "marks must be an array"}
+	}
+
+	clean := make([]interface{}, 0, len(marks))
+	for i, rawMark := range marks {
+		markPath := fmt.Sprintf("%s[%d]", path, i)
+		mark, ok := rawMark.(map[string]interface{})
+		if !ok {
+			return nil, &ContentValidationError{Path: markPath, Message: "mark must be an object"}
+		}
+		markType, _ := mark["type"].(string)
+		specs, ok := contentMarkSpecs[markType]
+		if !ok {
+			return nil, &ContentValidationError{Path: markPath + ".type", Message: fmt.Sprintf("unsupported mark type %q", markType)}
+		}
+
+		cleanMark := map[string]interface{}{"type": markType}
+		if rawAttrs, ok := mark["attrs"]; ok && rawAttrs != nil {
+			attrs, err := validateAttrs(rawAttrs, specs, markPath+".attrs")
+			if err != nil {
+				return nil, err
+			}
+			if len(attrs) > 0 {
+				cleanMark["attrs"] = attrs
+			}
+		}
+
+		// Links without a safe href are dropped rather than rejected so that
+		// pasted content with javascript: links still saves as plain text
+		if markType == "link" {
+			attrs, _ := cleanMark["attrs"].(map[string]interface{})
+			if href, _ := attrs["href"].(string); !IsSafeLinkURL(href) {
+				continue
+			}
+		}
+
+		clean = append(clean, cleanMark)
+	}
+	return clean, nil
+}
+
+func validateAttrs(raw interface{}, specs map[string]attrSpec, path string) (map[string]interface{}, error) {
+	attrs, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, &ContentValidationError{Path: path, Message: "attrs must be an object"}
+	}
+
+	clean := make(map[string]interface{})
+	for name, value := range attrs {
+		spec, ok := specs[name]
+		if !ok || value == nil {
+			// Unknown attributes are silently dropped
+			continue
+		}
+		attrPath := path + "." + name
+		switch spec.kind {
+		case attrString:
+			if _, ok := value.(string); !ok {
+				return nil, &ContentValidationError{Path: attrPath, Message: "must be a string"}
+			}
+		case attrBool:
+			if _, ok := value.(bool); !ok {
+				return nil, &ContentValidationError{Path: attrPath, Message: "must be a boolean"}
+			}
+		case attrInt:
+			n, ok := value.(float64)
+			if !ok || n != float64(int64(n)) {
+				return nil, &ContentValidationError{Path: attrPath, Message: "must be an integer"}
+			}
+			if spec.max == 0 && int(n) < spec.min {
+				return nil, &ContentValidationError{Path: attrPath, Message: fmt.Sprintf("must be at least %d", spec.min)}
+			}
+			if int(n) < spec.min || (spec.max > 0 && int(n) > spec.max) {
+				return nil, &ContentValidationError{Path: attrPath, Message: fmt.Sprintf("must be between %d and %d", spec.min, spec.max)}
+			}
+		case attrNumberOrString:
+			switch value.(type) {
+			case float64, string:
+			default:
+				return nil, &ContentValidationError{Path: attrPath, Message: "must be a number or a string"}
+			}
+		case attrIntList:
+			list, ok := value.([]interface{})
+			if !ok {
+				return nil, &ContentValidationError{Path: attrPath, Message: "must be an array of integers"}
+			}
+			for _, item := range list {
+				if _, ok := item.(float64); !ok {
+					return nil, &ContentValidationError{Path: attrPath, Message: "must be an array of integers"}
+				}
+			}
+		case attrLinkURL:
+			if _, ok := value.(string); !ok {
+				return nil, &ContentValidationError{Path: attrPath, Message: "must be a string"}
+			}
+		case attrImageURL:
+			src, ok := value.(string)
+			if !ok {
+				return nil, &ContentValidationError{Path: attrPath, Message: "must be a string"}
+			}
+			if !IsSafeImageURL(src) {
+				return nil, &ContentValidationError{Path: attrPath, Message: "image source must be an http(s) URL, a relative path or a raster data URL"}
+			}
+		}
+		clean[name] = value
+	}
+	return clean, nil
+}
@@ -0,0 +1,158 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"gorm.io/datatypes"
+)
+
+func TestValidatePageContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "wrapped document",
+			content: `{"doc":{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"hi"}]}]}}`,
+			want:    `{"doc":{"content":[{"content":[{"text":"hi","type":"text"}],"type":"paragraph"}],"type":"doc"}}`,
+		},
+		{
+			name:    "bare document",
+			content: `{"type":"doc","content":[{"type":"heading","attrs":{"level":2},"content":[{"type":"text","text":"T"}]}]}`,
+			want:    `{"content":[{"attrs":{"level":2},"content":[{"text":"T","type":"text"}],"type":"heading"}],"type":"doc"}`,
+		},
+		{
+			name:    "unknown attributes dropped",
+			content: `{"type":"doc","content":[{"type":"paragraph","attrs":{"onclick":"x","blockId":"b1"}}]}`,
+			want:    `{"content":[{"attrs":{"blockId":"b1"},"type":"paragraph"}],"type":"doc"}`,
+		},
+		{
+			name:    "unsafe link mark dropped",
+			content: `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"x","marks":[{"type":"bold"},{"type":"link","attrs":{"href":"java\tscript:alert(1)"}}]}]}]}`,
+			want:    `{"content":[{"content":[{"marks":[{"type":"bold"}],"text":"x","type":"text"}],"type":"paragraph"}],"type":"doc"}`,
+		},
+		{
+			name:    "safe link mark kept",
+			content: `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"x","marks":[{"type":"link","attrs":{"href":"https://example.com"}}]}]}]}`,
+			want:    `{"content":[{"content":[{"marks":[{"attrs":{"href":"https://example.com"},"type":"link"}],"text":"x","type":"text"}],"type":"paragraph"}],"type":"doc"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ValidatePageContent(datatypes.JSON(tt.content))
+			if err != nil {
+				t.Fatalf("ValidatePageContent() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("ValidatePageContent() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePageContentErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		path    string
+	}{
+		{"not an object", `[]`, "content"},
+		{"root not doc", `{"type":"paragraph"}`, "doc.type"},
+		{"unknown node", `{"type":"doc","content":[{"type":"script"}]}`, "doc.content[0].type"},
+		{"nested doc", `{"type":"doc","content":[{"type":"doc"}]}`, "doc.content[0].type"},
+		{"empty text", `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":""}]}]}`, "doc.content[0].content[0].text"},
+		{"content on leaf", `{"type":"doc","content":[{"type":"hardBreak","content":[]}]}`, "doc.content[0].content"},
+		{"marks on block", `{"type":"doc","content":[{"type":"paragraph","marks":[{"type":"bold"}]}]}`, "doc.content[0].marks"},
+		{"unknown mark", `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"x","marks":[{"type":"font"}]}]}]}`, "doc.content[0].content[0].marks[0].type"},
+		{"heading level", `{"type":"doc","content":[{"type":"heading","attrs":{"level":7}}]}`, "doc.content[0].attrs.level"},
+		{"checked not bool", `{"type":"doc","content":[{"type":"taskList","content":[{"type":"taskItem","attrs":{"checked":"yes"}}]}]}`, "doc.content[0].content[0].attrs.checked"},
+		{"unsafe image", `{"type":"doc","content":[{"type":"image","attrs":{"src":"javascript:alert(1)"}}]}`, "doc.content[0].attrs.src"},
+		{"svg data image", `{"type":"doc","content":[{"type":"image","attrs":{"src":"data:image/svg+xml;base64,PHN2Zz4="}}]}`, "doc.content[0].attrs.src"},
+		{"too deep", `{"type":"doc","content":[` + strings.Repeat(`{"type":"blockquote","content":[`, MaxContentDepth+1) + strings.Repeat(`]}`, MaxContentDepth+1) + `]}`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ValidatePageContent(datatypes.JSON(tt.content))
+			var validationErr *ContentValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("ValidatePageContent() error = %v, want *ContentValidationError", err)
+			}
+			if tt.path != "" && validationErr.Path != tt.path {
+				t.Errorf("error path = %q, want %q", validationErr.Path, tt.path)
+			}
+		})
+	}
+}
+
+func TestValidatePageContentRangeMessages(t *testing.T) {
+	tests := map[string]string{
+		`{"type":"doc","content":[{"type":"heading","attrs":{"level":0}}]}`:      "must be between 1 and 6",
+		`{"type":"doc","content":[{"type":"image","attrs":{"image_id":0}}]}`:     "must be at least 1",
+		`{"type":"doc","content":[{"type":"image","attrs":{"image_id":-5}}]}`:    "must be at least 1",
+		`{"type":"doc","content":[{"type":"orderedList","attrs":{"start":-1}}]}`: "must be between 0 and 1073741824",
+	}
+	for content, want := range tests {
+		_, err := ValidatePageContent(datatypes.JSON(content))
+		var validationErr *ContentValidationError
+		if !errors.As(err, &validationErr) {
+			t.Errorf("ValidatePageContent(%s) error = %v, want *ContentValidationError", content, err)
+			continue
+		}
+		if validationErr.Message != want {
+			t.Errorf("ValidatePageContent(%s) message = %q, want %q", content, validationErr.Message, want)
+		}
+	}
+}
+
+func TestIsSafeURL(t *testing.T) {
+	tests := []struct {
+		url   string
+		link  bool
+		image bool
+	}{
+		{"https://example.com/a.png", true, true},
+		{"http://example.com", true, true},
+		{"/api/img/images/a.png", true, true},
+		{"#heading", true, true},
+		{"mailto:a@example.com", true, false},
+		{"tel:+81000000000", true, false},
+		{"javascript:alert(1)", false, false},
+		{"JavaScript:alert(1)", false, false},
+		{" java\nscript:alert(1)", false, false},
+		{"vbscript:msgbox(1)", false, false},
+		{"data:text/html,<script>", false, false},
+		{"data:image/png;base64,iVBORw0KGgo=", false, true},
+		{"data:image/svg+xml,<svg/>", false, false},
+		{"", false, false},
+	}
+
+	for _, tt := range tests {
+		if got := IsSafeLinkURL(tt.url); got != tt.link {
+			t.Errorf("IsSafeLinkURL(%q) = %v, want %v", tt.url, got, tt.link)
+		}
+		if got := IsSafeImageURL(tt.url); got != tt.image {
+			t.Errorf("IsSafeImageURL(%q) = %v, want %v", tt.url, got, tt.image)
+		}
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	content := datatypes.JSON(`{"type":"doc","content":[` +
+		`{"type":"heading","attrs":{"level":1},"content":[{"type":"text","text":"Title"}]},` +
+		`{"type":"paragraph","content":[{"type":"text","text":"Hello "},{"type":"mention","attrs":{"label":"alice"}}]}]}`)
+
+	if got, want := Excerpt(content, 200), "Title Hello @alice"; got != want {
+		t.Errorf("Excerpt() = %q, want %q", got, want)
+	}
+	if got, want := Excerpt(content, 5), "Title…"; got != want {
+		t.Errorf("Excerpt() = %q, want %q", got, want)
+	}
+	if got := Excerpt(nil, 200); got != "" {
+		t.Errorf("Excerpt(nil) = %q, want empty", got)
+	}
+}
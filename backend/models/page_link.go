@@ -0,0 +1,268 @@
+package models
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+const (
+	// PageLinkKindLink is a link mark whose href points at a page
+	PageLinkKindLink = "link"
+	// PageLinkKindMention is a mention node of type "page"
+	PageLinkKindMention = "mention"
+)
+
+// PageLink records a link or mention from one page's content to another.
+// Links are rebuilt from the content whenever a page is saved. The target
+// is deliberately not a foreign key so links to deleted pages are kept
+// and reported as dangling.
+type PageLink struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	SourcePageID uint      `json:"source_page_id" gorm:"not null;index"`
+	TargetPageID uint      `json:"target_page_id" gorm:"not null;index"`
+	Kind         string    `json:"kind" gorm:"not null"`
+	Text         string    `json:"text"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// PageLinkRef is an internal link found in page content
+type PageLinkRef struct {
+	TargetPageID uint
+	Kind         string
+	Text         string
+}
+
+// Backlink is a page that links to another page
+type Backlink struct {
+	SourcePageID uint      `json:"source_page_id"`
+	SourceTitle  string    `json:"source_title"`
+	Kind         string    `json:"kind"`
+	Text         string    `json:"text"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// OutgoingLink is a link from a page, flagged when its target was deleted
+type OutgoingLink struct {
+	TargetPageID uint   `json:"target_page_id"`
+	TargetTitle  string `json:"target_title"`
+	Kind         string `json:"kind"`
+	Text         string `json:"text"`
+	Dangling     bool   `json:"dangling"`
+}
+
+// PageGraphNode is a page in the link graph
+type PageGraphNode struct {
+	ID        uint      `json:"id"`
+	Title     string    `json:"title"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PageGraphEdge is a link between two pages in the link graph
+type PageGraphEdge struct {
+	SourcePageID uint   `json:"source"`
+	TargetPageID uint   `json:"target"`
+	Kind         string `json:"kind"`
+	Dangling     bool   `json:"dangling"`
+}
+
+// PageGraph is the link graph of the whole workspace
+type PageGraph struct {
+	Nodes []PageGraphNode `json:"nodes"`
+	Edges []PageGraphEdge `json:"edges"`
+}
+
+// pageURLPattern matches the in-app page paths produced by PageURL
+var pageURLPattern = regexp.MustCompile(`^/pages/(\d+)/?$`)
+
+// ParsePageURL returns the page ID of an in-app page link such as
+// "/pages/12" or "/pages/12#heading"
+func ParsePageURL(href string) (uint, bool) {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return 0, false
+	}
+	match := pageURLPattern.FindStringSubmatch(u.Path)
+	if match == nil {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(match[1], 10, 32)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// ExtractPageLinks extracts links and page mentions from page content.
+// Adjacent text nodes sharing the same link are reported once, with their
+// combined text.
+func ExtractPageLinks(content datatypes.JSON) ([]PageLinkRef, error) {
+	doc, err := ParseDocument(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []PageLinkRef
+	var walk func(node map[string]interface{})
+	walk = func(node map[string]interface{}) {
+		children, _ := node["content"].([]interface{})
+
+		var current *PageLinkRef
+		for _, raw := range children {
+			child, ok := raw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			childType, _ := child["type"].(string)
+			if childType == "text" {
+				text, _ := child["text"].(string)
+				if id, ok := textNodePageLink(child); ok {
+					if current != nil && current.TargetPageID == id {
+						current.Text += text
+						continue
+					}
+					refs = append(refs, PageLinkRef{TargetPageID: id, Kind: PageLinkKindLink, Text: text})
+					current = &refs[len(refs)-1]
+					continue
+				}
+				current = nil
+				continue
+			}
+			current = nil
+
+			if childType == "mention" {
+				attrs, _ := child["attrs"].(map[string]interface{})
+				if mentionType, _ := attrs["type"].(string); mentionType == "page" {
+					if id, ok := numericAttr(attrs["id"]); ok {
+						label, _ := attrs["label"].(string)
+						refs = append(refs, PageLinkRef{TargetPageID: id, Kind: PageLinkKindMention, Text: label})
+					}
+				}
+				continue
+			}
+
+			walk(child)
+		}
+	}
+	walk(doc)
+
+	return refs, nil
+}
+
+// textNodePageLink returns the target of a text node's link mark when it
+// points at a page
+func textNodePageLink(node map[string]interface{}) (uint, bool) {
+	marks, _ := node["marks"].([]interface{})
+	for _, raw := range marks {
+		mark, _ := raw.(map[string]interface{})
+		if markType, _ := mark["type"].(string); markType != "link" {
+			continue
+		}
+		attrs, _ := mark["attrs"].(map[string]interface{})
+		href, _ := attrs["href"].(string)
+		return ParsePageURL(href)
+	}
+	return 0, false
+}
+
+// numericAttr reads an ID attribute stored either as a number or a string
+func numericAttr(value interface{}) (uint, bool) {
+	switch v := value.(type) {
+	case float64:
+		if v > 0 && v == float64(uint(v)) {
+			return uint(v), true
+		}
+	case string:
+		if id, err := strconv.ParseUint(v, 10, 32); err == nil && id > 0 {
+			return uint(id), true
+		}
+	}
+	return 0, false
+}
+
+// UpdatePageLinks replaces the stored links of a page with those found in
+// its content. Self-links are ignored and duplicates are stored once.
+func UpdatePageLinks(db *gorm.DB, pageID uint, content datatypes.JSON) error {
+	refs, err := ExtractPageLinks(content)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("source_page_id = ?", pageID).Delete(&PageLink{}).Error; err != nil {
+			return err
+		}
+
+		seen := make(map[string]bool)
+		links := make([]PageLink, 0, len(refs))
+		for _, ref := range refs {
+			key := ref.Kind + ":" + strconv.FormatUint(uint64(ref.TargetPageID), 10)
+			if ref.TargetPageID == pageID || seen[key] {
+				continue
+			}
+			seen[key] = true
+			links = append(links, PageLink{
+				SourcePageID: pageID,
+				TargetPageID: ref.TargetPageID,
+				Kind:         ref.Kind,
+				Text:         ref.Text,
+			})
+		}
+
+		if len(links) == 0 {
+			return nil
+		}
+		return tx.Create(&links).Error
+	})
+}
+
+// GetBacklinks retrieves the pages that link to or mention a page
+func GetBacklinks(db *gorm.DB, pageID uint) ([]Backlink, error) {
+	backlinks := []Backlink{}
+	err := db.Table("page_links").
+		Select("page_links.source_page_id, pages.title AS source_title, page_links.kind, page_links.text, pages.updated_at").
+		Joins("JOIN pages ON pages.id = page_links.source_page_id").
+		Where("page_links.target_page_id = ?", pageID).
+		Order("pages.updated_at DESC").
+		Scan(&backlinks).Error
+	return backlinks, err
+}
+
+// GetOutgoingLinks retrieves the links from a page, flagging those whose
+// target page no longer exists
+func GetOutgoingLinks(db *gorm.DB, pageID uint) ([]OutgoingLink, error) {
+	links := []OutgoingLink{}
+	err := db.Table("page_links").
+		Select("page_links.target_page_id, COALESCE(pages.title, '') AS target_title, page_links.kind, page_links.text, pages.id IS NULL AS dangling").
+		Joins("LEFT JOIN pages ON pages.id = page_links.target_page_id").
+		Where("page_links.source_page_id = ?", pageID).
+		Order("page_links.id ASC").
+		Scan(&links).Error
+	return links, err
+}
+
+// GetPageGraph retrieves every page and the links between them
+func GetPageGraph(db *gorm.DB) (*PageGraph, error) {
+	graph := &PageGraph{Nodes: []PageGraphNode{}, Edges: []PageGraphEdge{}}
+
+	if err := db.Model(&Page{}).Select("id, title, updated_at").Order("id ASC").Scan(&graph.Nodes).Error; err != nil {
+		return nil, err
+	}
+
+	err := db.Table("page_links").
+		Select("page_links.source_page_id, page_links.target_page_id, page_links.kind, pages.id IS NULL AS dangling").
+		Joins("LEFT JOIN pages ON pages.id = page_links.target_page_id").
+		Order("page_links.source_page_id ASC, page_links.target_page_id ASC").
+		Scan(&graph.Edges).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return graph, nil
+}
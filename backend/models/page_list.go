@@ -0,0 +1,232 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+const (
+	// PageSortUpdated orders pages by last update
+	PageSortUpdated = "updated"
+	// PageSortCreated orders pages by creation time
+	PageSortCreated = "created"
+	// PageSortTitle orders pages alphabetically by title
+	PageSortTitle = "title"
+
+	// PageExcerptLength is the number of characters in a page summary excerpt
+	PageExcerptLength = 200
+)
+
+// pageSortColumns maps sort options to their columns
+var pageSortColumns = map[string]string{
+	PageSortUpdated: "updated_at",
+	PageSortCreated: "created_at",
+	PageSortTitle:   "title",
+}
+
+// ErrInvalidCursor is returned when a page list cursor cannot be decoded or
+// was issued for a different sort order
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// PageListOptions controls which pages ListPages returns and in what order
+type PageListOptions struct {
+	Limit int
+	Sort  string
+	Desc  bool
+	// DateField selects the column From and To apply to ("updated" or "created")
+	DateField string
+	From      *time.Time
+	To        *time.Time
+	Cursor    string
+	// IsTemplate restricts the listing to templates or regular pages
+	IsTemplate *bool
+	// Tags restricts the listing to pages with all of these tags, or any
+	// of them when TagsAny is set
+	Tags    []string
+	TagsAny bool
+	// Subtree restricts the listing to a page and all of its descendants
+	Subtree *uint
+	// Summary loads only what ToSummary needs instead of whole pages
+	Summary bool
+}
+
+// PageSummary is a page without its content, used for lightweight listings
+type PageSummary struct {
+	ID           uint           `json:"id"`
+	Title        string         `json:"title"`
+	Excerpt      string         `json:"excerpt"`
+	ParentID     *uint          `json:"parent_id"`
+	IsTemplate   bool           `json:"is_template"`
+	CollectionID *uint          `json:"collection_id,omitempty"`
+	Properties   datatypes.JSON `json:"properties,omitempty"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+}
+
+// pageCursor is the position after the last page of a listing. The sort
+// options are recorded so a cursor cannot be reused with a different order.
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+// PageSummaryColumns are the columns ToSummary needs, for queries that
+// only list summaries. The content is only loaded for pages saved before
+// excerpts were stored.
+const PageSummaryColumns = "id, title, excerpt, CASE WHEN excerpt IS NULL THEN content END AS content, " +
+	"parent_id, is_template, collection_id, properties, created_at, updated_at"
+
+// ToSummary returns the page without content, with an excerpt of its text
+func (p *Page) ToSummary() PageSummary {
+	var excerpt string
+	if p.Excerpt != nil {
+		excerpt = *p.Excerpt
+	} else {
+		excerpt = Excerpt(p.Content, PageExcerptLength)
+	}
+	return PageSummary{
+		ID:           p.ID,
+		Title:        p.Title,
+		Excerpt:      excerpt,
+		ParentID:     p.ParentID,
+		IsTemplate:   p.IsTemplate,
+		CollectionID: p.CollectionID,
+		Properties:   p.Properties,
+		CreatedAt:    p.CreatedAt,
+		UpdatedAt:    p.UpdatedAt,
+	}
+}
+
+// IsValidPageSort reports whether sort is a supported sort option
+func IsValidPageSort(sort string) bool {
+	_, ok := pageSortColumns[sort]
+	return ok
+}
+
+// ListPages retrieves one page of a listing using keyset pagination. It
+// returns the cursor for the next page, or an empty string when there are
+// no more pages.
+func ListPages(db *gorm.DB, opts PageListOptions) ([]Page, string, error) {
+	column, ok := pageSortColumns[opts.Sort]
+	if !ok {
+		column = pageSortColumns[PageSortUpdated]
+		opts.Sort = PageSortUpdated
+	}
+
+	query := db.Model(&Page{})
+	if opts.Summary {
+		query = query.Select(PageSummaryColumns)
+	}
+
+	dateColumn := pageSortColumns[PageSortUpdated]
+	if opts.DateField == PageSortCreated {
+		dateColumn = pageSortColumns[PageSortCreated]
+	}
+	if opts.From != nil {
+		query = query.Where(dateColumn+" >= ?", *opts.From)
+	}
+	if opts.To != nil {
+		query = query.Where(dateColumn+" < ?", *opts.To)
+	}
+	if opts.IsTemplate != nil {
+		query = query.Where("is_template = ?", *opts.IsTemplate)
+	}
+	if len(opts.Tags) > 0 {
+		tagged := db.Table("page_tags").
+			Select("page_tags.page_id").
+			Joins("JOIN tags ON tags.id = page_tags.tag_id").
+			Where("tags.name IN ?", opts.Tags)
+		if !opts.TagsAny {
+			tagged = tagged.Group("page_tags.page_id").Having("COUNT(DISTINCT page_tags.tag_id) = ?", len(opts.Tags))
+		}
+		query = query.Where("id IN (?)", tagged)
+	}
+	if opts.Subtree != nil {
+		subtree := db.Raw(`WITH RECURSIVE subtree AS (
+			SELECT id FROM pages WHERE id = ?
+			UNION
+			SELECT pages.id FROM pages JOIN subtree ON pages.parent_id = subtree.id
+		) SELECT id FROM subtree`, *opts.Subtree)
+		query = query.Where("id IN (?)", subtree)
+	}
+
+	direction := "ASC"
+	comparison := ">"
+	if opts.Desc {
+		direction = "DESC"
+		comparison = "<"
+	}
+
+	if opts.Cursor != "" {
+		cursor, err := decodePageCursor(opts.Cursor)
+		if err != nil || cursor.Sort != opts.Sort || cursor.Desc != opts.Desc {
+			return nil, "", ErrInvalidCursor
+		}
+
+		var value interface{} = cursor.Value
+		if opts.Sort != PageSortTitle {
+			t, err := time.Parse(time.RFC3339Nano, cursor.Value)
+			if err != nil {
+				return nil, "", ErrInvalidCursor
+			}
+			value = t
+		}
+		query = query.Where("("+column+", id) "+comparison+" (?, ?)", value, cursor.ID)
+	}
+
+	var pages []Page
+	err := query.Order(column + " " + direction).Order("id " + direction).
+		Limit(opts.Limit + 1).Find(&pages).Error
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(pages) <= opts.Limit {
+		return pages, "", nil
+	}
+
+	pages = pages[:opts.Limit]
+	last := pages[len(pages)-1]
+	cursor := pageCursor{Sort: opts.Sort, Desc: opts.Desc, ID: last.ID}
+	switch opts.Sort {
+	case PageSortTitle:
+		cursor.Value = last.Title
+	case PageSortCreated:
+		cursor.Value = last.CreatedAt.Format(time.RFC3339Nano)
+	default:
+		cursor.Value = last.UpdatedAt.Format(time.RFC3339Nano)
+	}
+
+	next, err := encodePageCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	return pages, next, nil
+}
+
+func encodePageCursor(cursor pageCursor) (string, error) {
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodePageCursor(raw string) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
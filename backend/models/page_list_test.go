@@ -0,0 +1,123 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// dryRunDB returns a database that builds statements without connecting
+func dryRunDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	if err != nil {
+		t.Fatalf("gorm.Open() error = %v", err)
+	}
+	return db
+}
+
+func TestPageCursorRoundTrip(t *testing.T) {
+	cursors := []pageCursor{
+		{Sort: PageSortUpdated, Desc: true, Value: time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC).Format(time.RFC3339Nano), ID: 42},
+		{Sort: PageSortTitle, Value: "日本語のタイトル, with \"quotes\"", ID: 7},
+		{Sort: PageSortCreated, Value: "", ID: 1},
+	}
+
+	for _, want := range cursors {
+		raw, err := encodePageCursor(want)
+		if err != nil {
+			t.Fatalf("encodePageCursor() error = %v", err)
+		}
+		if strings.ContainsAny(raw, "+/=") {
+			t.Errorf("cursor %q is not URL safe", raw)
+		}
+		got, err := decodePageCursor(raw)
+		if err != nil {
+			t.Fatalf("decodePageCursor(%q) error = %v", raw, err)
+		}
+		if *got != want {
+			t.Errorf("decodePageCursor() = %+v, want %+v", *got, want)
+		}
+	}
+}
+
+func TestListPagesCursorValidation(t *testing.T) {
+	db := dryRunDB(t)
+	encode := func(c pageCursor) string {
+		raw, err := encodePageCursor(c)
+		if err != nil {
+			t.Fatalf("encodePageCursor() error = %v", err)
+		}
+		return raw
+	}
+	updatedAt := time.Now().UTC().Format(time.RFC3339Nano)
+
+	tests := []struct {
+		name    string
+		opts    PageListOptions
+		wantErr bool
+	}{
+		{"no cursor", PageListOptions{Limit: 10, Sort: PageSortUpdated}, false},
+		{"valid time cursor", PageListOptions{Limit: 10, Sort: PageSortUpdated, Desc: true,
+			Cursor: encode(pageCursor{Sort: PageSortUpdated, Desc: true, Value: updatedAt, ID: 3})}, false},
+		{"valid title cursor", PageListOptions{Limit: 10, Sort: PageSortTitle,
+			Cursor: encode(pageCursor{Sort: PageSortTitle, Value: "b", ID: 3})}, false},
+		{"not base64", PageListOptions{Limit: 10, Sort: PageSortUpdated, Cursor: "!!!"}, true},
+		{"not JSON", PageListOptions{Limit: 10, Sort: PageSortUpdated,
+			Cursor: base64.RawURLEncoding.EncodeToString([]byte("nope"))}, true},
+		{"other sort", PageListOptions{Limit: 10, Sort: PageSortCreated,
+			Cursor: encode(pageCursor{Sort: PageSortUpdated, Value: updatedAt, ID: 3})}, true},
+		{"other direction", PageListOptions{Limit: 10, Sort: PageSortUpdated,
+			Cursor: encode(pageCursor{Sort: PageSortUpdated, Desc: true, Value: updatedAt, ID: 3})}, true},
+		{"bad time", PageListOptions{Limit: 10, Sort: PageSortUpdated,
+			Cursor: encode(pageCursor{Sort: PageSortUpdated, Value: "yesterday", ID: 3})}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, next, err := ListPages(db, tt.opts)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidCursor) {
+					t.Errorf("ListPages() error = %v, want ErrInvalidCursor", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("ListPages() error = %v", err)
+			}
+			if next != "" {
+				t.Errorf("ListPages() next = %q, want none for an empty result", next)
+			}
+		})
+	}
+}
+
+func TestListPagesSummaryColumns(t *testing.T) {
+	var sql string
+	db := dryRunDB(t)
+	db.Callback().Query().After("gorm:query").Register("test:capture", func(tx *gorm.DB) {
+		sql = tx.Statement.SQL.String()
+	})
+
+	if _, _, err := ListPages(db, PageListOptions{Limit: 10, Sort: PageSortTitle, Summary: true}); err != nil {
+		t.Fatalf("ListPages() error = %v", err)
+	}
+	if !strings.Contains(sql, "CASE WHEN excerpt IS NULL THEN content END AS content") || strings.Contains(sql, "SELECT *") {
+		t.Errorf("summary listing selects %q", sql)
+	}
+
+	if _, _, err := ListPages(db, PageListOptions{Limit: 10, Sort: PageSortTitle}); err != nil {
+		t.Fatalf("ListPages() error = %v", err)
+	}
+	if !strings.Contains(sql, "SELECT *") {
+		t.Errorf("full listing selects %q", sql)
+	}
+}
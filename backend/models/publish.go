@@ -0,0 +1,100 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// maxSlugTitleLength limits the part of a publish slug taken from the title
+const maxSlugTitleLength = 48
+
+// slugEncoding encodes the random suffix of publish slugs
+var slugEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newPublishSlug builds a slug from the ASCII letters and digits of a
+// title followed by a random suffix, e.g. "weekly-notes-k3j9x2qa"
+func newPublishSlug(title string) (string, error) {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+		if b.Len() >= maxSlugTitleLength {
+			break
+		}
+	}
+
+	random := make([]byte, 5)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	suffix := strings.ToLower(slugEncoding.EncodeToString(random))
+	if b.Len() == 0 {
+		return suffix, nil
+	}
+	return strings.TrimSuffix(b.String(), "-") + "-" + suffix, nil
+}
+
+// PublishPage makes a page publicly readable. A page keeps its slug when it
+// is unpublished, so publishing it again brings back the same URL.
+func PublishPage(db *gorm.DB, page *Page) error {
+	updates := map[string]interface{}{"published_at": time.Now()}
+	if page.PublishSlug == nil {
+		slug, err := newPublishSlug(page.Title)
+		if err != nil {
+			return err
+		}
+		updates["publish_slug"] = slug
+	}
+	// Publishing is not an edit of the page
+	return db.Model(&Page{}).Where("id = ?", page.ID).UpdateColumns(updates).Error
+}
+
+// UnpublishPage revokes public access to a page
+func UnpublishPage(db *gorm.DB, id uint) error {
+	return db.Model(&Page{}).Where("id = ?", id).UpdateColumn("published_at", nil).Error
+}
+
+// GetPublishedPage retrieves a published page by its slug
+func GetPublishedPage(db *gorm.DB, slug string) (*Page, error) {
+	var page Page
+	err := db.Where("publish_slug = ? AND published_at IS NOT NULL", slug).First(&page).Error
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// GetPublishedSlugs returns the slugs of the published pages among ids
+func GetPublishedSlugs(db *gorm.DB, ids []uint) (map[uint]string, error) {
+	slugs := make(map[uint]string)
+	if len(ids) == 0 {
+		return slugs, nil
+	}
+
+	var pages []Page
+	err := db.Select("id, publish_slug").
+		Where("id IN ? AND published_at IS NOT NULL", ids).
+		Find(&pages).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, page := range pages {
+		if page.PublishSlug != nil {
+			slugs[page.ID] = *page.PublishSlug
+		}
+	}
+	return slugs, nil
+}
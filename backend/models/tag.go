@@ -0,0 +1,306 @@
+package models
+
+import (
+	"errors"
+	"hash/fnv"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// PageTagSourceManual is a tag added through the API
+	PageTagSourceManual = "manual"
+	// PageTagSourceContent is a tag extracted from a #hashtag in the content
+	PageTagSourceContent = "content"
+
+	// MaxTagNameLength is the longest tag name accepted, in characters
+	MaxTagNameLength = 50
+)
+
+// Tag is a label that can be attached to any number of pages. Names are
+// stored normalized, so "#Design" and "design" are the same tag.
+type Tag struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Name      string    `json:"name" gorm:"not null;uniqueIndex"`
+	Color     string    `json:"color" gorm:"not null"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PageTag attaches a tag to a page. Source records whether the tag was
+// added by hand or extracted from the content; only extracted tags are
+// removed again when the hashtag disappears from the content.
+type PageTag struct {
+	PageID    uint      `json:"page_id" gorm:"primaryKey"`
+	TagID     uint      `json:"tag_id" gorm:"primaryKey;index"`
+	Source    string    `json:"source" gorm:"not null"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TagWithCount is a tag with the number of pages it is attached to
+type TagWithCount struct {
+	Tag
+	PageCount int64 `json:"page_count"`
+}
+
+// PageTagInfo is a tag attached to a page
+type PageTagInfo struct {
+	Tag
+	Source string `json:"source"`
+}
+
+// ErrInvalidTagName is returned for empty or overly long tag names
+var ErrInvalidTagName = errors.New("invalid tag name")
+
+// ErrInvalidTagColor is returned for colors that are not #rrggbb
+var ErrInvalidTagColor = errors.New("invalid tag color")
+
+// tagColorPattern matches #rrggbb colors
+var tagColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// tagColors is the palette new tags are assigned from
+var tagColors = []string{
+	"#ef4444", "#f97316", "#eab308", "#22c55e",
+	"#14b8a6", "#3b82f6", "#8b5cf6", "#ec4899",
+}
+
+// hashtagPattern matches #hashtags that start a word. The name must not be
+// purely numeric so references like "#12" are not treated as tags.
+var hashtagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&#/])#([\p{L}\p{N}_][\p{L}\p{N}_\-]*)`)
+
+// NormalizeTagName trims a leading '#' and surrounding space, lowercases the
+// name and collapses inner whitespace
+func NormalizeTagName(name string) (string, error) {
+	name = strings.TrimPrefix(strings.TrimSpace(name), "#")
+	name = strings.ToLower(strings.Join(strings.Fields(name), " "))
+	if name == "" || utf8.RuneCountInString(name) > MaxTagNameLength || strings.Contains(name, ",") {
+		return "", ErrInvalidTagName
+	}
+	return name, nil
+}
+
+// ValidateTagColor reports an error unless color is empty or #rrggbb
+func ValidateTagColor(color string) error {
+	if color != "" && !tagColorPattern.MatchString(color) {
+		return ErrInvalidTagColor
+	}
+	return nil
+}
+
+// defaultTagColor picks a palette color from the tag name so the same
+// name always gets the same color
+func defaultTagColor(name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return tagColors[h.Sum32()%uint32(len(tagColors))]
+}
+
+// ExtractHashtags returns the normalized #hashtags in page content, in
+// order of first appearance. Code spans and code blocks are ignored.
+func ExtractHashtags(content datatypes.JSON) ([]string, error) {
+	doc, err := ParseDocument(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	var walk func(node map[string]interface{})
+	walk = func(node map[string]interface{}) {
+		switch node["type"] {
+		case "codeBlock":
+			return
+		case "text":
+			if hasMark(node, "code") {
+				return
+			}
+			text, _ := node["text"].(string)
+			for _, match := range hashtagPattern.FindAllStringSubmatch(text, -1) {
+				if !strings.ContainsFunc(match[1], unicode.IsLetter) {
+					continue
+				}
+				name, err := NormalizeTagName(match[1])
+				if err != nil || seen[name] {
+					continue
+				}
+				seen[name] = true
+				names = append(names, name)
+			}
+			return
+		}
+
+		children, _ := node["content"].([]interface{})
+		for _, raw := range children {
+			if child, ok := raw.(map[string]interface{}); ok {
+				walk(child)
+			}
+		}
+	}
+	walk(doc)
+
+	return names, nil
+}
+
+// hasMark reports whether a text node carries a mark of the given type
+func hasMark(node map[string]interface{}, markType string) bool {
+	marks, _ := node["marks"].([]interface{})
+	for _, raw := range marks {
+		if mark, ok := raw.(map[string]interface{}); ok && mark["type"] == markType {
+			return true
+		}
+	}
+	return false
+}
+
+// FindOrCreateTag returns the tag with the given name, creating it with
+// color (or a default color) if it does not exist
+func FindOrCreateTag(db *gorm.DB, name, color string) (*Tag, error) {
+	name, err := NormalizeTagName(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateTagColor(color); err != nil {
+		return nil, err
+	}
+	if color == "" {
+		color = defaultTagColor(name)
+	}
+
+	tag := Tag{Name: name, Color: color}
+	// Concurrent saves may create the same tag; the unique index makes the
+	// loser fall through to the lookup
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&tag).Error; err != nil {
+		return nil, err
+	}
+	if tag.ID == 0 {
+		if err := db.Where("name = ?", name).First(&tag).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &tag, nil
+}
+
+// GetTagByID retrieves a tag by ID
+func GetTagByID(db *gorm.DB, id uint) (*Tag, error) {
+	var tag Tag
+	if err := db.First(&tag, id).Error; err != nil {
+		return nil, err
+	}
+	return &tag, nil
+}
+
+// SearchTags returns tags matching query for autocomplete. Tags starting
+// with the query come first, then those containing it; ties are broken by
+// how many pages use the tag.
+func SearchTags(db *gorm.DB, query string, limit int) ([]TagWithCount, error) {
+	query = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(query), "#"))
+	pattern := "%" + escapeLike(query) + "%"
+	prefix := escapeLike(query) + "%"
+
+	tags := []TagWithCount{}
+	err := db.Table("tags").
+		Select("tags.*, COUNT(page_tags.page_id) AS page_count").
+		Joins("LEFT JOIN page_tags ON page_tags.tag_id = tags.id").
+		Where("tags.name LIKE ?", pattern).
+		Group("tags.id").
+		Order(clause.OrderBy{Expression: clause.Expr{
+			SQL:                "tags.name LIKE ? DESC, page_count DESC, tags.name ASC",
+			Vars:               []interface{}{prefix},
+			WithoutParentheses: true,
+		}}).
+		Limit(limit).
+		Scan(&tags).Error
+	return tags, err
+}
+
+// escapeLike escapes the LIKE wildcards in s
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// UpdateTag updates a tag's name or color
+func UpdateTag(db *gorm.DB, id uint, updates map[string]interface{}) error {
+	return db.Model(&Tag{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// DeleteTag deletes a tag and detaches it from every page
+func DeleteTag(db *gorm.DB, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("tag_id = ?", id).Delete(&PageTag{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Tag{}, id).Error
+	})
+}
+
+// GetPageTags retrieves the tags attached to a page
+func GetPageTags(db *gorm.DB, pageID uint) ([]PageTagInfo, error) {
+	tags := []PageTagInfo{}
+	err := db.Table("page_tags").
+		Select("tags.*, page_tags.source").
+		Joins("JOIN tags ON tags.id = page_tags.tag_id").
+		Where("page_tags.page_id = ?", pageID).
+		Order("tags.name ASC").
+		Scan(&tags).Error
+	return tags, err
+}
+
+// AddPageTag attaches a tag to a page by hand. A tag that was extracted
+// from the content becomes a manual tag, so it is kept even if the hashtag
+// is later removed.
+func AddPageTag(db *gorm.DB, pageID, tagID uint) error {
+	pageTag := PageTag{PageID: pageID, TagID: tagID, Source: PageTagSourceManual}
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "page_id"}, {Name: "tag_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"source": PageTagSourceManual}),
+	}).Create(&pageTag).Error
+}
+
+// RemovePageTag detaches a tag from a page
+func RemovePageTag(db *gorm.DB, pageID, tagID uint) error {
+	return db.Where("page_id = ? AND tag_id = ?", pageID, tagID).Delete(&PageTag{}).Error
+}
+
+// UpdatePageHashtags syncs the tags extracted from a page's #hashtags.
+// Manually added tags are never removed.
+func UpdatePageHashtags(db *gorm.DB, pageID uint, content datatypes.JSON) error {
+	names, err := ExtractHashtags(content)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		tagIDs := make([]uint, 0, len(names))
+		for _, name := range names {
+			tag, err := FindOrCreateTag(tx, name, "")
+			if err != nil {
+				return err
+			}
+			tagIDs = append(tagIDs, tag.ID)
+		}
+
+		stale := tx.Where("page_id = ? AND source = ?", pageID, PageTagSourceContent)
+		if len(tagIDs) > 0 {
+			stale = stale.Where("tag_id NOT IN ?", tagIDs)
+		}
+		if err := stale.Delete(&PageTag{}).Error; err != nil {
+			return err
+		}
+
+		if len(tagIDs) == 0 {
+			return nil
+		}
+		pageTags := make([]PageTag, len(tagIDs))
+		for i, tagID := range tagIDs {
+			pageTags[i] = PageTag{PageID: pageID, TagID: tagID, Source: PageTagSourceContent}
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&pageTags).Error
+	})
+}
@@ -0,0 +1,360 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+const (
+	// TaskStatusOpen lists unchecked tasks
+	TaskStatusOpen = "open"
+	// TaskStatusDone lists checked tasks
+	TaskStatusDone = "done"
+	// TaskStatusAll lists every task
+	TaskStatusAll = "all"
+)
+
+// ErrTaskChanged is returned when a task no longer matches the page content
+var ErrTaskChanged = errors.New("task was changed in the page")
+
+// taskDueDate finds a due date written as YYYY-MM-DD in a task's text,
+// e.g. "期限: 2026-10-20" or "due 2026-10-20"
+var taskDueDate = regexp.MustCompile(`\b(\d{4}-\d{2}-\d{2})\b`)
+
+// PageTask is a taskItem found in page content. Tasks are re-indexed from
+// the content whenever a page is saved, keeping the ID of a task that is
+// still there; Position is the task's index among the page's task items in
+// document order.
+type PageTask struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`
+	PageID    uint           `json:"page_id" gorm:"not null;index"`
+	Position  int            `json:"position" gorm:"not null"`
+	BlockID   string         `json:"block_id,omitempty"`
+	Text      string         `json:"text"`
+	Checked   bool           `json:"checked" gorm:"not null;default:false;index"`
+	Assignees datatypes.JSON `json:"assignees" gorm:"type:jsonb"`
+	DueDate   *time.Time     `json:"due_date" gorm:"type:date;index"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// TaskWithPage is a task together with the title of its page
+type TaskWithPage struct {
+	PageTask
+	PageTitle string `json:"page_title"`
+}
+
+// TaskListOptions filters the task list
+type TaskListOptions struct {
+	Status   string
+	PageID   uint
+	Assignee string
+	DueFrom  *time.Time
+	DueTo    *time.Time
+	DueOnly  bool
+	Limit    int
+}
+
+// taskNode is a taskItem node and its position in the document
+type taskNode struct {
+	node     map[string]interface{}
+	position int
+}
+
+// findTaskNodes returns the taskItem nodes of a document in order
+func findTaskNodes(doc map[string]interface{}) []taskNode {
+	var tasks []taskNode
+	var walk func(node map[string]interface{})
+	walk = func(node map[string]interface{}) {
+		if node["type"] == "taskItem" {
+			tasks = append(tasks, taskNode{node: node, position: len(tasks)})
+		}
+		children, _ := node["content"].([]interface{})
+		for _, raw := range children {
+			if child, ok := raw.(map[string]interface{}); ok {
+				walk(child)
+			}
+		}
+	}
+	walk(doc)
+	return tasks
+}
+
+// newPageTask builds the index entry of a taskItem node. Nested task lists
+// are tasks of their own and are not part of the text.
+func newPageTask(pageID uint, item taskNode) PageTask {
+	attrs, _ := item.node["attrs"].(map[string]interface{})
+	checked, _ := attrs["checked"].(bool)
+	blockID, _ := attrs["blockId"].(string)
+
+	var parts []string
+	assignees := []string{}
+	children, _ := item.node["content"].([]interface{})
+	for _, raw := range children {
+		child, ok := raw.(map[string]interface{})
+		if !ok || child["type"] == "taskList" {
+			continue
+		}
+		parts = append(parts, inlineText(child))
+		assignees = appendMentions(assignees, child)
+	}
+
+	task := PageTask{
+		PageID:   pageID,
+		Position: item.position,
+		BlockID:  blockID,
+		Text:     strings.TrimSpace(strings.Join(parts, " ")),
+		Checked:  checked,
+	}
+	task.Assignees, _ = json.Marshal(assignees)
+	if match := taskDueDate.FindStringSubmatch(task.Text); match != nil {
+		if due, err := time.Parse("2006-01-02", match[1]); err == nil {
+			task.DueDate = &due
+		}
+	}
+	return task
+}
+
+// appendMentions adds the labels of person mentions under node, skipping
+// mentions of pages
+func appendMentions(labels []string, node map[string]interface{}) []string {
+	if node["type"] == "mention" {
+		attrs, _ := node["attrs"].(map[string]interface{})
+		label, _ := attrs["label"].(string)
+		if mentionType, _ := attrs["type"].(string); mentionType != "page" && label != "" {
+			for _, existing := range labels {
+				if existing == label {
+					return labels
+				}
+			}
+			labels = append(labels, label)
+		}
+		return labels
+	}
+	children, _ := node["content"].([]interface{})
+	for _, raw := range children {
+		if child, ok := raw.(map[string]interface{}); ok {
+			labels = appendMentions(labels, child)
+		}
+	}
+	return labels
+}
+
+// ExtractTasks returns the task items of page content
+func ExtractTasks(pageID uint, content datatypes.JSON) ([]PageTask, error) {
+	doc, err := ParseDocument(content)
+	if err != nil {
+		return nil, err
+	}
+
+	items := findTaskNodes(doc)
+	tasks := make([]PageTask, 0, len(items))
+	for _, item := range items {
+		tasks = append(tasks, newPageTask(pageID, item))
+	}
+	return tasks, nil
+}
+
+// UpdatePageTasks re-indexes the tasks of a page from its content. Tasks
+// already indexed keep their IDs so they can still be addressed after the
+// page is saved again.
+func UpdatePageTasks(db *gorm.DB, pageID uint, content datatypes.JSON) error {
+	tasks, err := ExtractTasks(pageID, content)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var existing []PageTask
+		if err := tx.Where("page_id = ?", pageID).Find(&existing).Error; err != nil {
+			return err
+		}
+
+		stale := matchTasks(existing, tasks)
+		if len(stale) > 0 {
+			if err := tx.Delete(&PageTask{}, stale).Error; err != nil {
+				return err
+			}
+		}
+
+		current := make(map[uint]PageTask, len(existing))
+		for _, task := range existing {
+			current[task.ID] = task
+		}
+		for i := range tasks {
+			task := &tasks[i]
+			if task.ID == 0 {
+				if err := tx.Create(task).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			if sameTask(current[task.ID], *task) {
+				continue
+			}
+			err := tx.Model(&PageTask{}).Where("id = ?", task.ID).Updates(map[string]interface{}{
+				"position":  task.Position,
+				"block_id":  task.BlockID,
+				"text":      task.Text,
+				"checked":   task.Checked,
+				"assignees": task.Assignees,
+				"due_date":  task.DueDate,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// matchTasks gives tasks extracted from content the IDs of the indexed
+// tasks they continue, matched by block ID and then by position when one
+// of the two has no block ID. It returns the IDs of indexed tasks that are
+// gone.
+func matchTasks(existing []PageTask, tasks []PageTask) []uint {
+	used := make(map[uint]bool, len(existing))
+	byBlock := make(map[string]uint)
+	for _, task := range existing {
+		if task.BlockID != "" {
+			byBlock[task.BlockID] = task.ID
+		}
+	}
+	for i := range tasks {
+		if id, ok := byBlock[tasks[i].BlockID]; ok && tasks[i].BlockID != "" && !used[id] {
+			tasks[i].ID = id
+			used[id] = true
+		}
+	}
+
+	byPosition := make(map[int]PageTask)
+	for _, task := range existing {
+		if !used[task.ID] {
+			byPosition[task.Position] = task
+		}
+	}
+	for i := range tasks {
+		if tasks[i].ID != 0 {
+			continue
+		}
+		old, ok := byPosition[tasks[i].Position]
+		if ok && !used[old.ID] && (old.BlockID == "" || tasks[i].BlockID == "") {
+			tasks[i].ID = old.ID
+			used[old.ID] = true
+		}
+	}
+
+	var stale []uint
+	for _, task := range existing {
+		if !used[task.ID] {
+			stale = append(stale, task.ID)
+		}
+	}
+	return stale
+}
+
+// sameTask reports whether an indexed task is unchanged
+func sameTask(a, b PageTask) bool {
+	sameDue := (a.DueDate == nil && b.DueDate == nil) ||
+		(a.DueDate != nil && b.DueDate != nil && a.DueDate.Equal(*b.DueDate))
+	return a.Position == b.Position && a.BlockID == b.BlockID && a.Text == b.Text &&
+		a.Checked == b.Checked && string(a.Assignees) == string(b.Assignees) && sameDue
+}
+
+// GetTaskByID retrieves a task by ID
+func GetTaskByID(db *gorm.DB, id uint) (*PageTask, error) {
+	var task PageTask
+	if err := db.First(&task, id).Error; err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// ListTasks retrieves tasks across pages, soonest due first. Tasks without
+// a due date come last, in page order. Tasks in templates are not work to
+// be done and are left out.
+func ListTasks(db *gorm.DB, opts TaskListOptions) ([]TaskWithPage, error) {
+	query := db.Table("page_tasks").
+		Select("page_tasks.*, pages.title AS page_title").
+		Joins("JOIN pages ON pages.id = page_tasks.page_id").
+		Where("pages.is_template = ?", false)
+
+	switch opts.Status {
+	case TaskStatusOpen:
+		query = query.Where("page_tasks.checked = ?", false)
+	case TaskStatusDone:
+		query = query.Where("page_tasks.checked = ?", true)
+	}
+	if opts.PageID != 0 {
+		query = query.Where("page_tasks.page_id = ?", opts.PageID)
+	}
+	if opts.Assignee != "" {
+		encoded, err := json.Marshal([]string{opts.Assignee})
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("page_tasks.assignees @> ?::jsonb", string(encoded))
+	}
+	if opts.DueFrom != nil {
+		query = query.Where("page_tasks.due_date >= ?", *opts.DueFrom)
+	}
+	if opts.DueTo != nil {
+		query = query.Where("page_tasks.due_date < ?", *opts.DueTo)
+	}
+	if opts.DueOnly {
+		query = query.Where("page_tasks.due_date IS NOT NULL")
+	}
+
+	tasks := []TaskWithPage{}
+	err := query.Order("page_tasks.due_date ASC NULLS LAST, page_tasks.page_id ASC, page_tasks.position ASC").
+		Limit(opts.Limit).
+		Scan(&tasks).Error
+	return tasks, err
+}
+
+// SetTaskChecked checks or unchecks a task in its page's content and
+// returns the updated content. The task is located by its block ID, or by
+// its position when it has none; ErrTaskChanged is returned when the item
+// found there no longer has the indexed text.
+func SetTaskChecked(content datatypes.JSON, task *PageTask, checked bool) (datatypes.JSON, error) {
+	var data map[string]interface{}
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, err
+	}
+	doc := data
+	if wrapped, ok := data["doc"].(map[string]interface{}); ok {
+		doc = wrapped
+	}
+
+	var target *taskNode
+	items := findTaskNodes(doc)
+	for i := range items {
+		attrs, _ := items[i].node["attrs"].(map[string]interface{})
+		blockID, _ := attrs["blockId"].(string)
+		if (task.BlockID != "" && blockID == task.BlockID) || (task.BlockID == "" && items[i].position == task.Position) {
+			target = &items[i]
+			break
+		}
+	}
+	if target == nil || newPageTask(task.PageID, *target).Text != task.Text {
+		return nil, ErrTaskChanged
+	}
+
+	attrs, _ := target.node["attrs"].(map[string]interface{})
+	if attrs == nil {
+		attrs = make(map[string]interface{})
+		target.node["attrs"] = attrs
+	}
+	attrs["checked"] = checked
+
+	out, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return datatypes.JSON(out), nil
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"slices"
+	"testing"
+
+	"gorm.io/datatypes"
+)
+
+func TestMatchTasks(t *testing.T) {
+	existing := []PageTask{
+		{ID: 10, Position: 0, BlockID: "a", Text: "first"},
+		{ID: 11, Position: 1, BlockID: "b", Text: "second"},
+		{ID: 12, Position: 2, Text: "legacy"},
+		{ID: 13, Position: 3, BlockID: "d", Text: "deleted"},
+	}
+	tasks := []PageTask{
+		// Moved up and edited
+		{Position: 0, BlockID: "b", Text: "second, edited"},
+		{Position: 1, BlockID: "a", Text: "first"},
+		// The editor gave the legacy task a block ID
+		{Position: 2, BlockID: "c", Text: "legacy"},
+		// Took the place of the deleted task
+		{Position: 3, BlockID: "new", Text: "added"},
+	}
+
+	stale := matchTasks(existing, tasks)
+
+	got := make([]uint, len(tasks))
+	for i, task := range tasks {
+		got[i] = task.ID
+	}
+	if want := []uint{11, 10, 12, 0}; !slices.Equal(got, want) {
+		t.Errorf("matched IDs = %v, want %v", got, want)
+	}
+	if want := []uint{13}; !slices.Equal(stale, want) {
+		t.Errorf("stale IDs = %v, want %v", stale, want)
+	}
+}
+
+func TestMatchTasksByPosition(t *testing.T) {
+	// Tasks without block IDs keep their IDs while they stay in place
+	existing := []PageTask{{ID: 1, Position: 0}, {ID: 2, Position: 1}, {ID: 3, Position: 2}}
+	tasks := []PageTask{{Position: 0}, {Position: 1}}
+	if stale := matchTasks(existing, tasks); !slices.Equal(stale, []uint{3}) {
+		t.Errorf("stale IDs = %v, want [3]", stale)
+	}
+	if tasks[0].ID != 1 || tasks[1].ID != 2 {
+		t.Errorf("matched IDs = %d, %d, want 1, 2", tasks[0].ID, tasks[1].ID)
+	}
+
+	// A task with another block ID at the same position is a new task
+	existing = []PageTask{{ID: 1, Position: 0, BlockID: "a"}}
+	tasks = []PageTask{{Position: 0, BlockID: "b"}}
+	if stale := matchTasks(existing, tasks); !slices.Equal(stale, []uint{1}) || tasks[0].ID != 0 {
+		t.Errorf("matchTasks() gave the new task ID %d, stale %v", tasks[0].ID, stale)
+	}
+}
+
+func TestSetTaskChecked(t *testing.T) {
+	content := datatypes.JSON(`{"type":"doc","content":[{"type":"taskList","content":[
+		{"type":"taskItem","attrs":{"checked":false,"blockId":"t1"},"content":[{"type":"paragraph","content":[{"type":"text","text":"one"}]}]},
+		{"type":"taskItem","attrs":{"checked":false},"content":[{"type":"paragraph","content":[{"type":"text","text":"two"}]}]}]}]}`)
+	tasks, err := ExtractTasks(1, content)
+	if err != nil || len(tasks) != 2 {
+		t.Fatalf("ExtractTasks() = %v, %v", tasks, err)
+	}
+
+	for i := range tasks {
+		updated, err := SetTaskChecked(content, &tasks[i], true)
+		if err != nil {
+			t.Fatalf("SetTaskChecked() error = %v", err)
+		}
+		after, _ := ExtractTasks(1, updated)
+		if !after[i].Checked || after[1-i].Checked {
+			t.Errorf("SetTaskChecked(%q) checked %v", tasks[i].Text, after)
+		}
+	}
+
+	changed := tasks[1]
+	changed.Text = "renamed"
+	if _, err := SetTaskChecked(content, &changed, true); err != ErrTaskChanged {
+		t.Errorf("SetTaskChecked() error = %v, want ErrTaskChanged", err)
+	}
+}
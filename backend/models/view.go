@@ -0,0 +1,402 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// View types
+const (
+	ViewTable    = "table"
+	ViewBoard    = "board"
+	ViewCalendar = "calendar"
+)
+
+// View is a saved way of looking at a collection's pages: a filter, sort,
+// grouping and the visible property columns. A view with a share token can
+// be read without knowing its ID.
+type View struct {
+	ID           uint           `json:"id" gorm:"primaryKey"`
+	CollectionID uint           `json:"collection_id" gorm:"not null;index"`
+	Name         string         `json:"name" gorm:"not null"`
+	Type         string         `json:"type" gorm:"not null"`
+	Filters      datatypes.JSON `json:"filters" gorm:"type:jsonb"`
+	Sorts        datatypes.JSON `json:"sorts" gorm:"type:jsonb"`
+	GroupBy      string         `json:"group_by"`
+	Columns      datatypes.JSON `json:"columns" gorm:"type:jsonb"`
+	ShareToken   *string        `json:"share_token,omitempty" gorm:"uniqueIndex"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+}
+
+// ViewRow is a page as shown in a view, with only the visible properties
+type ViewRow struct {
+	ID         uint           `json:"id"`
+	Title      string         `json:"title"`
+	Properties datatypes.JSON `json:"properties"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+// ViewGroup is a board column. Value is empty for pages without a value.
+type ViewGroup struct {
+	Value string    `json:"value"`
+	Color string    `json:"color,omitempty"`
+	Total int64     `json:"total"`
+	Rows  []ViewRow `json:"rows"`
+}
+
+// CalendarDay holds the pages whose date property falls on Date
+type CalendarDay struct {
+	Date string    `json:"date"`
+	Rows []ViewRow `json:"rows"`
+}
+
+// MaxCalendarRows limits the pages returned for a calendar range
+const MaxCalendarRows = 1000
+
+// FilterList decodes the view's filters
+func (v *View) FilterList() ([]PropertyFilter, error) {
+	var filters []PropertyFilter
+	if len(v.Filters) == 0 || string(v.Filters) == "null" {
+		return filters, nil
+	}
+	err := json.Unmarshal(v.Filters, &filters)
+	return filters, err
+}
+
+// SortList decodes the view's sorts
+func (v *View) SortList() ([]PropertySort, error) {
+	var sorts []PropertySort
+	if len(v.Sorts) == 0 || string(v.Sorts) == "null" {
+		return sorts, nil
+	}
+	err := json.Unmarshal(v.Sorts, &sorts)
+	return sorts, err
+}
+
+// ColumnList decodes the view's visible property IDs. An empty list shows
+// every property.
+func (v *View) ColumnList() ([]string, error) {
+	var columns []string
+	if len(v.Columns) == 0 || string(v.Columns) == "null" {
+		return columns, nil
+	}
+	err := json.Unmarshal(v.Columns, &columns)
+	return columns, err
+}
+
+// ValidateView checks a view's type, grouping, filters, sorts and columns
+// against its collection's schema
+func ValidateView(collection *Collection, view *View) error {
+	switch view.Type {
+	case ViewTable:
+	case ViewBoard:
+		def, ok := collection.Definition(view.GroupBy)
+		if !ok {
+			return &PropertyValidationError{Property: "group_by", Message: "board views must be grouped by a property"}
+		}
+		switch def.Type {
+		case PropertyStatus, PropertySelect, PropertyCheckbox, PropertyAssignee, PropertyText:
+		default:
+			return &PropertyValidationError{Property: "group_by", Message: fmt.Sprintf("cannot group a board by %s properties", def.Type)}
+		}
+	case ViewCalendar:
+		def, ok := collection.Definition(view.GroupBy)
+		if !ok || def.Type != PropertyDate {
+			return &PropertyValidationError{Property: "group_by", Message: "calendar views must be grouped by a date property"}
+		}
+	default:
+		return &PropertyValidationError{Property: "type", Message: "type must be table, board or calendar"}
+	}
+	if view.Type == ViewTable && view.GroupBy != "" {
+		return &PropertyValidationError{Property: "group_by", Message: "table views cannot be grouped"}
+	}
+
+	filters, err := view.FilterList()
+	if err != nil {
+		return &PropertyValidationError{Property: "filters", Message: "must be an array of filters"}
+	}
+	for _, filter := range filters {
+		def, ok := collection.Definition(filter.Property)
+		if !ok {
+			return &PropertyValidationError{Property: filter.Property, Message: "unknown property"}
+		}
+		if _, err := propertyFilterExpr(def, filter); err != nil {
+			return &PropertyValidationError{Property: filter.Property, Message: err.Error()}
+		}
+	}
+
+	sorts, err := view.SortList()
+	if err != nil {
+		return &PropertyValidationError{Property: "sorts", Message: "must be an array of sorts"}
+	}
+	if _, err := CollectionOrder(collection, sorts); err != nil {
+		return err
+	}
+
+	columns, err := view.ColumnList()
+	if err != nil {
+		return &PropertyValidationError{Property: "columns", Message: "must be an array of property IDs"}
+	}
+	for _, column := range columns {
+		if _, ok := collection.Definition(column); !ok {
+			return &PropertyValidationError{Property: column, Message: "unknown property"}
+		}
+	}
+	return nil
+}
+
+// CreateView creates a new view
+func CreateView(db *gorm.DB, view *View) error {
+	return db.Create(view).Error
+}
+
+// GetViewByID retrieves a view by ID
+func GetViewByID(db *gorm.DB, id uint) (*View, error) {
+	var view View
+	if err := db.First(&view, id).Error; err != nil {
+		return nil, err
+	}
+	return &view, nil
+}
+
+// GetViewByShareToken retrieves a shared view
+func GetViewByShareToken(db *gorm.DB, token string) (*View, error) {
+	var view View
+	if err := db.Where("share_token = ?", token).First(&view).Error; err != nil {
+		return nil, err
+	}
+	return &view, nil
+}
+
+// GetCollectionViews retrieves the views of a collection
+func GetCollectionViews(db *gorm.DB, collectionID uint) ([]View, error) {
+	views := []View{}
+	err := db.Where("collection_id = ?", collectionID).Order("id ASC").Find(&views).Error
+	return views, err
+}
+
+// UpdateView updates an existing view
+func UpdateView(db *gorm.DB, id uint, updates map[string]interface{}) error {
+	return db.Model(&View{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// DeleteView deletes a view
+func DeleteView(db *gorm.DB, id uint) error {
+	return db.Delete(&View{}, id).Error
+}
+
+// viewQuery returns the view's filtered query and its order
+func viewQuery(db *gorm.DB, collection *Collection, view *View, extra ...PropertyFilter) (*gorm.DB, clause.Expr, error) {
+	filters, err := view.FilterList()
+	if err != nil {
+		return nil, clause.Expr{}, err
+	}
+	sorts, err := view.SortList()
+	if err != nil {
+		return nil, clause.Expr{}, err
+	}
+
+	query, err := CollectionFilter(db, collection, append(filters, extra...))
+	if err != nil {
+		return nil, clause.Expr{}, err
+	}
+	order, err := CollectionOrder(collection, sorts)
+	if err != nil {
+		return nil, clause.Expr{}, err
+	}
+	return query, order, nil
+}
+
+// GetViewRows retrieves one page of a table view's rows and the total
+// number of matching pages
+func GetViewRows(db *gorm.DB, collection *Collection, view *View, limit, offset int) ([]ViewRow, int64, error) {
+	query, order, err := viewQuery(db, collection, view)
+	if err != nil {
+		return nil, 0, err
+	}
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	rows := []ViewRow{}
+	err = query.Select("id, title, properties, updated_at").
+		Order(clause.OrderBy{Expression: order}).
+		Limit(limit).Offset(offset).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return projectRows(view, rows), total, nil
+}
+
+// GetViewBoard retrieves a board view's groups with up to perGroup rows
+// each. The grouping is done by Postgres with window functions, so large
+// groups are never loaded in full.
+func GetViewBoard(db *gorm.DB, collection *Collection, view *View, perGroup int) ([]ViewGroup, error) {
+	def, ok := collection.Definition(view.GroupBy)
+	if !ok {
+		return nil, &PropertyValidationError{Property: "group_by", Message: "unknown property"}
+	}
+	query, order, err := viewQuery(db, collection, view)
+	if err != nil {
+		return nil, err
+	}
+
+	groupSQL := "COALESCE(properties ->> ?::text, '')"
+	if def.Type == PropertyCheckbox {
+		groupSQL = "COALESCE((properties -> ?::text) = 'true'::jsonb, false)::text"
+	}
+	group := clause.Expr{SQL: groupSQL, Vars: []interface{}{def.ID}, WithoutParentheses: true}
+
+	inner := query.Select(
+		"id, title, properties, updated_at, ? AS group_value, "+
+			"ROW_NUMBER() OVER (PARTITION BY ? ORDER BY ?) AS group_row, "+
+			"COUNT(*) OVER (PARTITION BY ?) AS group_total",
+		group, group, order, group,
+	)
+
+	var results []struct {
+		ViewRow
+		GroupValue string
+		GroupTotal int64
+	}
+	err = db.Table("(?) AS grouped", inner).
+		Where("group_row <= ?", perGroup).
+		Order("group_value, group_row").
+		Scan(&results).Error
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string]*ViewGroup)
+	for _, result := range results {
+		g, ok := groups[result.GroupValue]
+		if !ok {
+			g = &ViewGroup{Value: result.GroupValue, Total: result.GroupTotal, Rows: []ViewRow{}}
+			groups[result.GroupValue] = g
+		}
+		g.Rows = append(g.Rows, result.ViewRow)
+	}
+
+	// Columns follow the option order, starting with pages without a
+	// value; options without pages still get an empty column
+	var values []string
+	switch def.Type {
+	case PropertyStatus, PropertySelect:
+		values = append(values, "")
+		for _, option := range def.Options {
+			values = append(values, option.Value)
+		}
+	case PropertyCheckbox:
+		values = []string{"false", "true"}
+	}
+	listed := make(map[string]bool)
+	for _, value := range values {
+		listed[value] = true
+	}
+	var others []string
+	for value := range groups {
+		if !listed[value] {
+			others = append(others, value)
+		}
+	}
+	sort.Strings(others)
+	values = append(values, others...)
+
+	board := make([]ViewGroup, 0, len(values))
+	for _, value := range values {
+		g, ok := groups[value]
+		if !ok {
+			if value == "" && def.Type != PropertyStatus && def.Type != PropertySelect {
+				continue
+			}
+			g = &ViewGroup{Value: value, Rows: []ViewRow{}}
+		}
+		for _, option := range def.Options {
+			if option.Value == value {
+				g.Color = option.Color
+			}
+		}
+		g.Rows = projectRows(view, g.Rows)
+		board = append(board, *g)
+	}
+	return board, nil
+}
+
+// GetViewCalendar retrieves the pages of a calendar view whose date falls
+// between from and to (inclusive, YYYY-MM-DD), grouped by day
+func GetViewCalendar(db *gorm.DB, collection *Collection, view *View, from, to string) ([]CalendarDay, error) {
+	query, order, err := viewQuery(db, collection, view,
+		PropertyFilter{Property: view.GroupBy, Operator: "on_or_after", Value: from},
+		PropertyFilter{Property: view.GroupBy, Operator: "on_or_before", Value: to},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []struct {
+		ViewRow
+		Day string
+	}
+	err = query.Select("id, title, properties, updated_at, left(properties ->> ?::text, 10) AS day", view.GroupBy).
+		Order(clause.OrderBy{Expression: clause.Expr{
+			SQL:                "day ASC, ?",
+			Vars:               []interface{}{order},
+			WithoutParentheses: true,
+		}}).
+		Limit(MaxCalendarRows).
+		Scan(&results).Error
+	if err != nil {
+		return nil, err
+	}
+
+	days := []CalendarDay{}
+	for _, result := range results {
+		if len(days) == 0 || days[len(days)-1].Date != result.Day {
+			days = append(days, CalendarDay{Date: result.Day, Rows: []ViewRow{}})
+		}
+		day := &days[len(days)-1]
+		day.Rows = append(day.Rows, result.ViewRow)
+	}
+	for i := range days {
+		days[i].Rows = projectRows(view, days[i].Rows)
+	}
+	return days, nil
+}
+
+// projectRows keeps only the view's visible properties on each row
+func projectRows(view *View, rows []ViewRow) []ViewRow {
+	columns, err := view.ColumnList()
+	if err != nil || len(columns) == 0 {
+		return rows
+	}
+
+	for i := range rows {
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(rows[i].Properties, &values); err != nil {
+			continue
+		}
+		visible := make(map[string]json.RawMessage, len(columns))
+		for _, column := range columns {
+			if value, ok := values[column]; ok {
+				visible[column] = value
+			}
+		}
+		encoded, err := json.Marshal(visible)
+		if err != nil {
+			continue
+		}
+		rows[i].Properties = encoded
+	}
+	return rows
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Storage drivers
+const (
+	DriverLocal = "local"
+	DriverS3    = "s3"
+)
+
+var (
+	// ErrNotExist is returned for keys that are not stored
+	ErrNotExist = errors.New("storage: object does not exist")
+	// ErrUnsupported is returned by drivers that cannot presign URLs
+	ErrUnsupported = errors.New("storage: operation not supported")
+)
+
+// Object is the content of a stored object
+type Object interface {
+	io.ReadSeekCloser
+}
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage stores uploads under slash-separated keys such as
+// "images/sha256/ab/<digest>.jpg" or "files/sha256/ab/<digest>"
+type Storage interface {
+	// Put stores the content of r under key, replacing any existing object
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens a stored object
+	Get(ctx context.Context, key string) (Object, error)
+	// Stat describes a stored object
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// Delete removes an object; deleting a missing object is not an error
+	Delete(ctx context.Context, key string) error
+	// List calls fn for every object whose key starts with prefix
+	List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error
+	// PresignGet returns a URL that downloads key directly from the backend
+	// until it expires, responding with the given Content-Type and
+	// Content-Disposition when they are set. Drivers without such URLs
+	// return ErrUnsupported.
+	PresignGet(ctx context.Context, key string, expires time.Duration, contentType, disposition string) (string, error)
+}
+
+// Config selects and configures a storage driver
+type Config struct {
+	Driver string
+	// LocalRoot is the directory the local driver stores objects in
+	LocalRoot string
+
+	S3Endpoint  string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+	S3Region    string
+	S3UseSSL    bool
+	// S3PublicEndpoint is used for presigned URLs when browsers reach the
+	// backend under a different host than the server does
+	S3PublicEndpoint string
+}
+
+// New creates the storage driver selected by cfg
+func New(cfg Config) (Storage, error) {
+	switch cfg.Driver {
+	case "", DriverLocal:
+		return NewLocal(cfg.LocalRoot)
+	case DriverS3:
+		return NewS3(cfg)
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Driver)
+	}
+}
+
+// PutFile stores a local file under key
+func PutFile(ctx context.Context, s Storage, key, path, contentType string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, key, file, info.Size(), contentType)
+}
+
+// Copy copies an object from one storage to another, or to a new key
+func Copy(ctx context.Context, dst Storage, dstKey string, src Storage, srcKey, contentType string) error {
+	info, err := src.Stat(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	obj, err := src.Get(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	return dst.Put(ctx, dstKey, obj, info.Size, contentType)
+}
@@ -25,8 +25,10 @@ const (
 type Client struct {
 	hub    *Hub
 	conn   *websocket.Conn
-	send   chan []byte
+	send   chan *Message
 	pageID string
+	// events clients receive server events instead of Yjs sync messages
+	events bool
 }
 
 // readPump pumps messages from the websocket connection to the hub.
@@ -52,8 +54,9 @@ func (c *Client) readPump() {
 			break
 		}
 
-		// Handle binary messages (Yjs sync protocol)
-		if messageType == websocket.BinaryMessage {
+		// Handle binary messages (Yjs sync protocol). Event clients only
+		// listen, so anything they send is dropped.
+		if messageType == websocket.BinaryMessage && !c.events {
 			// Broadcast the binary message to all clients in the same page
 			c.hub.broadcast <- &Message{
 				PageID:  c.pageID,
@@ -82,8 +85,12 @@ func (c *Client) writePump() {
 				return
 			}
 
-			// Write binary message for Yjs sync
-			if err := c.conn.WriteMessage(websocket.BinaryMessage, message); err != nil {
+			// Yjs sync messages are binary, server events are JSON text
+			frameType := websocket.BinaryMessage
+			if c.events {
+				frameType = websocket.TextMessage
+			}
+			if err := c.conn.WriteMessage(frameType, message.Content); err != nil {
 				return
 			}
 
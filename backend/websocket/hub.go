@@ -29,6 +29,17 @@ type Message struct {
 	Content []byte `json:"content"`
 }
 
+// Publish sends a JSON event to every client listening for the events of
+// a page. Events never reach the Yjs sync connections, which only carry
+// binary sync messages.
+func (h *Hub) Publish(pageID string, messageType string, content []byte) {
+	h.broadcast <- &Message{
+		PageID:  pageID,
+		Type:    messageType,
+		Content: content,
+	}
+}
+
 // NewHub creates a new Hub instance
 func NewHub() *Hub {
 	return &Hub{
@@ -90,8 +101,11 @@ func (h *Hub) broadcastToRoom(message *Message) {
 
 	if clients, ok := h.rooms[message.PageID]; ok {
 		for client := range clients {
+			if client.events != (message.Type != "yjs-sync") {
+				continue
+			}
 			select {
-			case client.send <- message.Content:
+			case client.send <- message:
 			default:
 				// Client's send channel is full, close it
 				close(client.send)
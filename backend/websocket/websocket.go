@@ -19,6 +19,17 @@ var upgrader = websocket.Upgrader{
 
 // HandleWebSocket handles websocket requests from the peer.
 func HandleWebSocket(hub *Hub, w http.ResponseWriter, r *http.Request, pageID string) {
+	serveClient(hub, w, r, pageID, false)
+}
+
+// HandleEvents handles websocket requests from peers listening for the
+// JSON events of a page, such as comment and task changes.
+func HandleEvents(hub *Hub, w http.ResponseWriter, r *http.Request, pageID string) {
+	serveClient(hub, w, r, pageID, true)
+}
+
+// serveClient upgrades the connection and registers its client
+func serveClient(hub *Hub, w http.ResponseWriter, r *http.Request, pageID string, events bool) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("WebSocket upgrade error:", err)
@@ -28,8 +39,9 @@ func HandleWebSocket(hub *Hub, w http.ResponseWriter, r *http.Request, pageID st
 	client := &Client{
 		hub:    hub,
 		conn:   conn,
-		send:   make(chan []byte, 256),
+		send:   make(chan *Message, 256),
 		pageID: pageID,
+		events: events,
 	}
 
 	client.hub.register <- client
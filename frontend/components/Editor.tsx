@@ -6,6 +6,7 @@ import StarterKit from '@tiptap/starter-kit'
 import Placeholder from '@tiptap/extension-placeholder'
 import CodeBlockLowlight from '@tiptap/extension-code-block-lowlight'
 import { ResizableImageExtension } from '@/lib/image-resize-extension'
+import { BlockIdExtension } from '@/lib/block-id-extension'
 import { common, createLowlight } from 'lowlight'
 import Collaboration from '@tiptap/extension-collaboration'
 import CollaborationCursor from '@tiptap/extension-collaboration-cursor'
@@ -16,6 +17,7 @@ import { api } from '@/lib/api'
 import { EditorMenuBar } from './EditorMenuBar'
 import { imageUploader } from '@/lib/image-upload'
 import { getFullImageUrl } from '@/lib/image-utils'
+import { subscribePageEvents } from '@/lib/page-events'
 import FileUpload from './FileUpload'
 
 const lowlight = createLowlight(common)
@@ -72,6 +74,7 @@ export function Editor({ pageId }: EditorProps) {
         inline: false,
         allowBase64: false,
       }),
+      BlockIdExtension,
       ...(ydocRef.current && providerRef.current ? [
         Collaboration.configure({
           document: ydocRef.current,
@@ -135,6 +138,48 @@ export function Editor({ pageId }: EditorProps) {
     }
   }, [editor, currentPage])
 
+  useEffect(() => {
+    if (!editor) return
+
+    // Apply changes made outside the editor to the shared document, so the
+    // next autosave keeps them instead of saving over them
+    return subscribePageEvents(pageId, (event) => {
+      const { tr } = editor.state
+
+      if (event.type === 'image-updated' && event.image) {
+        // Point image nodes at the new file when an image is edited
+        const { id, path, width, height } = event.image
+        editor.state.doc.descendants((node, pos) => {
+          if (node.type.name === 'resizableImage' && node.attrs['data-image-id'] === String(id)) {
+            tr.setNodeMarkup(pos, undefined, {
+              ...node.attrs,
+              src: getFullImageUrl(`/api/img${path}`),
+              'data-width': String(width),
+              'data-height': String(height),
+            })
+          }
+        })
+      } else if (event.type === 'task-updated' && event.task) {
+        // Check or uncheck a task toggled from the task list, found by its
+        // block ID or else by its position among the page's tasks
+        const { block_id: blockId, position, checked } = event.task
+        let index = 0
+        editor.state.doc.descendants((node, pos) => {
+          if (node.type.name !== 'taskItem') return
+          const matches = blockId ? node.attrs.blockId === blockId : index === position
+          index++
+          if (matches && node.attrs.checked !== checked) {
+            tr.setNodeMarkup(pos, undefined, { ...node.attrs, checked })
+          }
+        })
+      }
+
+      if (tr.docChanged) {
+        editor.view.dispatch(tr)
+      }
+    })
+  }, [editor, pageId])
+
   const saveContent = async (content: any) => {
     try {
       await api.updatePage(pageId, { content })
@@ -0,0 +1,82 @@
+import { Extension } from '@tiptap/core'
+import { isChangeOrigin } from '@tiptap/extension-collaboration'
+import { Plugin, PluginKey } from '@tiptap/pm/state'
+
+// Block types that comments and tasks can be anchored to. The backend keeps
+// blockId on the same types when it validates content.
+const blockTypes = [
+  'paragraph',
+  'heading',
+  'blockquote',
+  'bulletList',
+  'orderedList',
+  'listItem',
+  'taskList',
+  'taskItem',
+  'codeBlock',
+  'table',
+  'resizableImage',
+]
+
+const newBlockId = () =>
+  Date.now().toString(36) + Math.random().toString(36).slice(2, 10)
+
+/**
+ * Give every block a stable blockId attribute that is saved with the
+ * content. IDs are assigned by the client that made the change, never for
+ * changes synced from other editors, and a block that ends up with the ID
+ * of an earlier one (after a paste, for example) gets a new ID.
+ */
+export const BlockIdExtension = Extension.create({
+  name: 'blockId',
+
+  addGlobalAttributes() {
+    return [
+      {
+        types: blockTypes,
+        attributes: {
+          blockId: {
+            default: null,
+            keepOnSplit: false,
+            parseHTML: element => element.getAttribute('data-block-id'),
+            renderHTML: attributes => {
+              if (!attributes.blockId) {
+                return {}
+              }
+              return { 'data-block-id': attributes.blockId }
+            },
+          },
+        },
+      },
+    ]
+  },
+
+  addProseMirrorPlugins() {
+    return [
+      new Plugin({
+        key: new PluginKey('blockId'),
+        appendTransaction: (transactions, _oldState, newState) => {
+          const changed = transactions.some(tr => tr.docChanged && !isChangeOrigin(tr))
+          if (!changed) {
+            return null
+          }
+
+          const { tr } = newState
+          const seen = new Set<string>()
+          newState.doc.descendants((node, pos) => {
+            if (!blockTypes.includes(node.type.name)) {
+              return
+            }
+            let id = node.attrs.blockId
+            if (!id || seen.has(id)) {
+              id = newBlockId()
+              tr.setNodeMarkup(pos, undefined, { ...node.attrs, blockId: id })
+            }
+            seen.add(id)
+          })
+          return tr.docChanged ? tr : null
+        },
+      }),
+    ]
+  },
+})
@@ -0,0 +1,44 @@
+export interface PageEvent {
+  type: string
+  [key: string]: any
+}
+
+/**
+ * Listen for the JSON events of a page, such as comment, task and image
+ * changes. They arrive on their own socket so the Yjs connection only
+ * carries sync messages. Returns a function that stops listening.
+ */
+export function subscribePageEvents(
+  pageId: number,
+  onEvent: (event: PageEvent) => void
+): () => void {
+  const wsUrl = process.env.NEXT_PUBLIC_WS_URL || 'ws://localhost:8080'
+  let socket: WebSocket | null = null
+  let retryTimeout: ReturnType<typeof setTimeout> | null = null
+  let closed = false
+
+  const connect = () => {
+    socket = new WebSocket(`${wsUrl}/ws/${pageId}/events`)
+    socket.onmessage = (message) => {
+      try {
+        onEvent(JSON.parse(message.data))
+      } catch (error) {
+        console.error('Failed to parse page event:', error)
+      }
+    }
+    socket.onclose = () => {
+      if (!closed) {
+        retryTimeout = setTimeout(connect, 3000)
+      }
+    }
+  }
+  connect()
+
+  return () => {
+    closed = true
+    if (retryTimeout) {
+      clearTimeout(retryTimeout)
+    }
+    socket?.close()
+  }
+}